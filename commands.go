@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gitgub.com/UbunTom/environmentmonitor/monitor"
+)
+
+// A command is run by giving its name as the first argument, e.g.
+// `environmentmonitor test-sensor -bus /dev/i2c-1`, and parses the
+// arguments after it with its own flags
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// The commands, with the one run when none is named first. Set in `init`, as
+// the usage message of `run` lists them.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "Read the sensors and write the averages to the sinks (the default)", runMonitor},
+		{"test-sensor", "Print a few readings from a sensor, without writing them anywhere", testSensor},
+		{"list-buses", "Print the I²C buses and which sensor addresses respond on each", listBuses},
+		{"version", "Print the version, git commit and build date", showVersion},
+	}
+}
+
+func findCommand(args []string) (command, []string, error) {
+	// The command named by the first of `args`, and the arguments to pass to
+	// it. Without a name, such as when `args` is empty or starts with a flag,
+	// it's `run`, as before there were commands.
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commands[0], args, nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c, args[1:], nil
+		}
+	}
+	return command{}, nil, fmt.Errorf("unknown command %q", args[0])
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> -h for the flags of each command.\n", os.Args[0])
+}
+
+func runMonitor(args []string) {
+	// Read the sensors and write their averages until stopped by SIGINT or
+	// SIGTERM, as set up by the flags in `args`. SIGHUP re-reads the config
+	// file, if there is one.
+
+	config, logging, maxRuntime, reload := parseFlags(args)
+	logs, err := openLog(logging)
+	if err != nil {
+		log.Fatalf("Could not open the log file: %v", err)
+	}
+	defer logs.Close()
+	setupLogging(logs, logging)
+
+	ctx := context.Background()
+	if maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxRuntime)
+		defer cancel()
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var reloadConfig func()
+	if reload != nil {
+		reloads := make(chan monitor.LiveSettings, 1)
+		config.Reloads = reloads
+		reloadConfig = func() {
+			settings, err := reload()
+			if err != nil {
+				slog.Error("Could not reload the config, keeping the current settings", "error", err)
+				return
+			}
+			// Replace settings the monitor hasn't applied yet, such as while
+			// it's starting up, rather than waiting for it
+			select {
+			case <-reloads:
+			default:
+			}
+			reloads <- settings
+		}
+	}
+	go watchSignals(ctx, stop, reloadConfig)
+
+	if err := monitor.Run(ctx, config); err != nil {
+		fatal("Monitor failed", "error", err)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		slog.Info("Stopped after the maximum runtime", "max_runtime", maxRuntime)
+	}
+}
+
+func testSensor(args []string) {
+	// Print readings from the sensor chosen by the flags in `args`, exiting
+	// with status 1 if any of them fail
+
+	flags := flag.NewFlagSet("test-sensor", flag.ExitOnError)
+	var address uint
+	var config monitor.Config
+	count := flags.Int("count", 3, "Number of readings to take")
+	interval := flags.Duration("interval", time.Second, "Time between readings")
+	flags.StringVar(&config.Interface, "interface", "i2c", "How the sensor is connected: "+strings.Join(monitor.BusInterfaces, ", "))
+	flags.StringVar(&config.BusName, "bus", "", "I²C bus or SPI port the sensor is on (default: the first one)")
+	flags.UintVar(&address, "i2c_address", 0x76, "I²C address of the sensor")
+	flags.StringVar(&config.SensorModel, "sensor_model", "bme280", "Model of the sensor: bme280 or bme680")
+	flags.DurationVar(&config.ReadTimeout, "read_timeout", 5*time.Second, "Give up on a read that takes longer than this (0: wait indefinitely)")
+	flags.BoolVar(&config.Mock, "mock", false, "Read a mock sensor instead of real hardware")
+	var sensorOpts sensorOptFlags
+	sensorOpts.register(flags)
+	flags.Parse(args)
+
+	if err := monitor.ValidateAddress(address); err != nil {
+		log.Fatal(err)
+	}
+	if *count < 1 {
+		log.Fatalf("Invalid count %d: must be at least 1", *count)
+	}
+	config.I2CAddress = uint16(address)
+	config.Output.Fields = monitor.Fields{Temperature: true, Pressure: true, Humidity: true}
+	opts, err := sensorOpts.parse()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.SensorOpts = opts
+
+	if err := monitor.TestSensor(os.Stdout, config, *count, *interval); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func listBuses(args []string) {
+	flags := flag.NewFlagSet("list-buses", flag.ExitOnError)
+	flags.Parse(args)
+	if err := monitor.ListBuses(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func showVersion(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	flags.Parse(args)
+	printVersion(os.Stdout)
+}
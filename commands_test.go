@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		command string
+		rest    []string
+		wantErr bool
+	}{
+		{"no arguments", nil, "run", nil, false},
+		{"flags only", []string{"-window", "8"}, "run", []string{"-window", "8"}, false},
+		{"run", []string{"run", "-window", "8"}, "run", []string{"-window", "8"}, false},
+		{"test-sensor", []string{"test-sensor", "-count", "1"}, "test-sensor", []string{"-count", "1"}, false},
+		{"list-buses", []string{"list-buses"}, "list-buses", []string{}, false},
+		{"version", []string{"version"}, "version", []string{}, false},
+		{"unknown", []string{"frobnicate"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rest, err := findCommand(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findCommand(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+			if c.name != tt.command || !slices.Equal(rest, tt.rest) {
+				t.Errorf("findCommand(%q) = %q, %q, want %q, %q", tt.args, c.name, rest, tt.command, tt.rest)
+			}
+		})
+	}
+}
+
+func TestParseFlagsUsesItsOwnFlagSet(t *testing.T) {
+	config, _, _, _ := parseFlags([]string{"-mock", "-dry_run", "-temp_oversampling", "16", "-iir_filter", "8"})
+	if !config.Mock || !config.DryRun {
+		t.Errorf("parseFlags() Mock, DryRun = %v, %v, want true, true", config.Mock, config.DryRun)
+	}
+	if flag.CommandLine.Lookup("temp_oversampling") != nil {
+		t.Error("parseFlags() defined its flags on the global flag set")
+	}
+	want, err := (&sensorOptFlags{"16", "4", "4", "8"}).parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SensorOpts != want {
+		t.Errorf("parseFlags() SensorOpts = %+v, want %+v", config.SensorOpts, want)
+	}
+
+	// A second parse starts from the defaults again
+	config, _, _, _ = parseFlags([]string{"-mock"})
+	if config.DryRun {
+		t.Error("second parseFlags() kept -dry_run from the first")
+	}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRuntime time.Duration
+		window     string
+	}{
+		{"averages", 300 * time.Millisecond, "2"},
+		{"partial window", 300 * time.Millisecond, "100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "readings.csv")
+			args := []string{
+				"-mock", "-sink", "csv", "-csv_path", path, "-read_interval", "50ms",
+				"-window", tt.window, "-max_runtime", tt.maxRuntime.String(),
+				"-log_file", filepath.Join(dir, "monitor.log"),
+			}
+
+			start := time.Now()
+			runMonitor(args)
+			elapsed := time.Since(start)
+			if elapsed < tt.maxRuntime || elapsed > tt.maxRuntime+2*time.Second {
+				t.Errorf("ran for %v, want just over %v", elapsed, tt.maxRuntime)
+			}
+
+			// The averages so far, including any partial window, are written
+			// before exiting, after the header and the self-test's reading
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows := strings.Split(strings.TrimSpace(string(data)), "\n"); len(rows) < 3 {
+				t.Errorf("wrote %d rows, want at least one average:\n%s", len(rows), data)
+			}
+		})
+	}
+}
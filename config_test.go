@@ -0,0 +1,452 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gitgub.com/UbunTom/environmentmonitor/monitor"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	// Flags override environment variables, which override the config file,
+	// which overrides the defaults. Legacy variables such as INFLUX_URL only
+	// override the defaults.
+
+	const defaultURL, legacyURL, fileURL, flagURL = "http://localhost:8086", "http://legacy:8086", "http://file:8086", "http://flag:8086"
+	tests := []struct {
+		name   string
+		args   []string
+		env    string
+		legacy string
+		file   string
+		window int
+		url    string
+	}{
+		{"default", nil, "", "", "", 8, defaultURL},
+		{"config file", nil, "", "", "window: 4\n", 4, defaultURL},
+		{"environment over config file", nil, "6", "", "window: 4\n", 6, defaultURL},
+		{"flag over environment and config file", []string{"-window", "2"}, "6", "", "window: 4\n", 2, defaultURL},
+		{"flag over config file", []string{"-window", "2"}, "", "", "window: 4\n", 2, defaultURL},
+		{"legacy variable over default", nil, "", legacyURL, "", 8, legacyURL},
+		{"config file over legacy variable", nil, "", legacyURL, "influx_url: " + fileURL + "\n", 8, fileURL},
+		{"flag over legacy variable", []string{"-influx_url", flagURL}, "", legacyURL, "", 8, flagURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-mock", "-dry_run"}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.file+"read_interval: 30s\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			args = append(args, "-config", path)
+			if tt.env != "" {
+				t.Setenv("EM_WINDOW", tt.env)
+			}
+			if tt.legacy != "" {
+				t.Setenv("INFLUX_URL", tt.legacy)
+			}
+
+			config, _, _, _ := parseFlags(append(args, tt.args...))
+			if config.WindowSize != tt.window {
+				t.Errorf("window = %d, want %d", config.WindowSize, tt.window)
+			}
+			if config.Influx.URL != tt.url {
+				t.Errorf("InfluxDB URL = %q, want %q", config.Influx.URL, tt.url)
+			}
+			// Options only set by the file are kept whatever overrides others
+			if config.ReadInterval != 30*time.Second {
+				t.Errorf("read interval = %v, want 30s", config.ReadInterval)
+			}
+		})
+	}
+}
+
+func TestIntervalFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"15s", 15 * time.Second, false},
+		{"2m", 2 * time.Minute, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"1h30m", 90 * time.Minute, false},
+		// Bare numbers are seconds, as the flag used to take
+		{"15", 15 * time.Second, false},
+		{"0", 0, false},
+		{"1.5", 0, true},
+		{"fast", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var interval intervalFlag
+			err := interval.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got := time.Duration(interval); !tt.wantErr && got != tt.want {
+				t.Errorf("Set(%q) gave %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadIntervalFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"500ms", 500 * time.Millisecond},
+		{"2m", 2 * time.Minute},
+		{"30", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			config, _, _, _ := parseFlags([]string{"-mock", "-dry_run", "-read_interval", tt.value})
+			if config.ReadInterval != tt.want {
+				t.Errorf("read interval = %v, want %v", config.ReadInterval, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		reloaded string
+		wantErr  bool
+		interval time.Duration
+		window   int
+	}{
+		{"interval and window applied", nil, "read_interval: 1m\nwindow: 6\n", false, time.Minute, 6},
+		{"options removed keep their value", nil, "window: 6\n", false, 30 * time.Second, 6},
+		{"flags aren't overridden", []string{"-read_interval", "10s"}, "read_interval: 1m\n", false, 10 * time.Second, 4},
+		{"other options are ignored", nil, "read_interval: 1m\nbus: /dev/i2c-2\n", false, time.Minute, 4},
+		{"invalid interval", nil, "read_interval: 0s\n", true, 30 * time.Second, 4},
+		{"unparseable interval", nil, "read_interval: soon\n", true, 30 * time.Second, 4},
+		// Averaging can't be turned off while the monitor is running
+		{"window of 0", nil, "window: 0\n", true, 30 * time.Second, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("read_interval: 30s\nwindow: 4\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, _, _, reload := parseFlags(append([]string{"-mock", "-dry_run", "-config", path}, tt.args...))
+			if reload == nil {
+				t.Fatal("no reloader with a config file")
+			}
+
+			if err := os.WriteFile(path, []byte(tt.reloaded), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			settings, err := reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reload() error = %v, want error %v", err, tt.wantErr)
+			}
+			// A failed reload keeps the settings in use
+			if settings.ReadInterval != tt.interval || settings.WindowSize != tt.window {
+				t.Errorf("reload() = %v, window %d, want %v, window %d", settings.ReadInterval, settings.WindowSize, tt.interval, tt.window)
+			}
+		})
+	}
+}
+
+func TestNoReloaderWithoutConfigFile(t *testing.T) {
+	if _, _, _, reload := parseFlags([]string{"-mock", "-dry_run"}); reload != nil {
+		t.Error("parseFlags() returned a reloader without a config file")
+	}
+}
+
+func TestEnvOptions(t *testing.T) {
+	sensorLabels := func(config monitor.Config) any {
+		var labels []string
+		for _, sensor := range config.Sensors {
+			labels = append(labels, sensor.Label)
+		}
+		return strings.Join(labels, ",")
+	}
+	readInterval := func(config monitor.Config) any { return config.ReadInterval }
+	influxURL := func(config monitor.Config) any { return config.Influx.URL }
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		get  func(monitor.Config) any
+		want any
+	}{
+		{"EM_ variable", map[string]string{"EM_READ_INTERVAL": "45s"}, nil, readInterval, 45 * time.Second},
+		{"flag over EM_ variable", map[string]string{"EM_READ_INTERVAL": "45s"}, []string{"-read_interval", "10s"}, readInterval, 10 * time.Second},
+		{"default without either", nil, nil, readInterval, 15 * time.Second},
+		{"older variable", map[string]string{"INFLUX_URL": "http://old:8086"}, nil, influxURL, "http://old:8086"},
+		{"EM_ over older variable", map[string]string{"INFLUX_URL": "http://old:8086", "EM_INFLUX_URL": "http://new:8086"}, nil, influxURL, "http://new:8086"},
+		{"repeatable flag as a list", map[string]string{"EM_SENSOR": "0x76:indoor, 0x77:outdoor"}, nil, sensorLabels, "indoor,outdoor"},
+		{"repeatable flag given on the command line", map[string]string{"EM_SENSOR": "0x76:indoor,0x77:outdoor"}, []string{"-sensor", "0x77:shed"}, sensorLabels, "shed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+			if got := tt.get(config); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloaderKeepsEnvOptions(t *testing.T) {
+	// Options set by an EM_ variable aren't overridden by the config file,
+	// when it's first read or reloaded
+
+	t.Setenv("EM_READ_INTERVAL", "10s")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("read_interval: 30s\nwindow: 4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, _, _, reload := parseFlags([]string{"-mock", "-dry_run", "-config", path})
+	if config.ReadInterval != 10*time.Second {
+		t.Errorf("read interval = %v, want 10s", config.ReadInterval)
+	}
+
+	if err := os.WriteFile(path, []byte("read_interval: 1m\nwindow: 6\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ReadInterval != 10*time.Second || settings.WindowSize != 6 {
+		t.Errorf("reload() = %v, window %d, want 10s, window 6", settings.ReadInterval, settings.WindowSize)
+	}
+}
+
+func TestSensorIntervalFlags(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{[]string{"outdoor=5m"}, map[string]time.Duration{"outdoor": 5 * time.Minute}, false},
+		{[]string{"outdoor=5m", "indoor=30"}, map[string]time.Duration{"outdoor": 5 * time.Minute, "indoor": 30 * time.Second}, false},
+		{[]string{"outdoor=5m", "outdoor=1m"}, nil, true},
+		{[]string{"outdoor"}, nil, true},
+		{[]string{"=5m"}, nil, true},
+		{[]string{"outdoor=0s"}, nil, true},
+		{[]string{"outdoor=soon"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.values, " "), func(t *testing.T) {
+			intervals := sensorIntervalFlags{}
+			var err error
+			for _, value := range tt.values {
+				if err = intervals.Set(value); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(intervals, sensorIntervalFlags(tt.want)) {
+				t.Errorf("intervals = %v, want %v", intervals, tt.want)
+			}
+		})
+	}
+}
+
+func TestSensorWindowFlags(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    map[string]int
+		wantErr bool
+	}{
+		{[]string{"outdoor=4"}, map[string]int{"outdoor": 4}, false},
+		{[]string{"outdoor=4", "indoor=12"}, map[string]int{"outdoor": 4, "indoor": 12}, false},
+		{[]string{"outdoor=4", "outdoor=2"}, nil, true},
+		{[]string{"outdoor=0"}, nil, true},
+		{[]string{"outdoor=2.5"}, nil, true},
+		{[]string{"4"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.values, " "), func(t *testing.T) {
+			windows := sensorWindowFlags{}
+			var err error
+			for _, value := range tt.values {
+				if err = windows.Set(value); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(windows, sensorWindowFlags(tt.want)) {
+				t.Errorf("windows = %v, want %v", windows, tt.want)
+			}
+		})
+	}
+}
+
+func TestPerSensorOptions(t *testing.T) {
+	config, _, _, _ := parseFlags([]string{"-mock", "-dry_run", "-read_interval", "10s",
+		"-sensor", "0x76:indoor", "-sensor", "0x77:outdoor",
+		"-sensor_interval", "outdoor=2m", "-sensor_window", "indoor=6"})
+
+	want := []monitor.SensorConfig{
+		{Address: 0x76, Label: "indoor", WindowSize: 6},
+		{Address: 0x77, Label: "outdoor", ReadInterval: 2 * time.Minute},
+	}
+	if !slices.Equal(config.Sensors, want) {
+		t.Errorf("sensors = %+v, want %+v", config.Sensors, want)
+	}
+	// The health check allows for the slowest sensor
+	if config.HealthMaxAge != 6*time.Minute {
+		t.Errorf("health max age = %v, want 6m", config.HealthMaxAge)
+	}
+}
+
+func TestWindowZeroTurnsOffAveraging(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default", nil, "window"},
+		{"window of 0", []string{"-window", "0"}, "none"},
+		{"average mode none", []string{"-average_mode", "none"}, "none"},
+		{"windows of a duration", []string{"-window", "0", "-window_duration", "5m"}, "window"},
+		{"ema", []string{"-window", "0", "-average_mode", "ema"}, "ema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+			if config.AverageMode != tt.want {
+				t.Errorf("average mode = %q, want %q", config.AverageMode, tt.want)
+			}
+		})
+	}
+}
+
+func TestSinkWriteTimeoutFlag(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		sinks []string
+		want  []time.Duration
+	}{
+		{"default", nil, []string{"influx", "postgres"}, []time.Duration{30 * time.Second, 30 * time.Second}},
+		{"for one sink", []string{"-sink_write_timeout", "10s,postgres=1m"}, []string{"influx", "postgres"}, []time.Duration{10 * time.Second, time.Minute}},
+		{"turned off", []string{"-sink_write_timeout", "0"}, []string{"influx"}, []time.Duration{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+			for i, sink := range tt.sinks {
+				if got := config.WriteTimeouts.For(sink); got != tt.want[i] {
+					t.Errorf("write timeout for %s = %v, want %v", sink, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceIDFlag(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"defaults to the host name", nil, host},
+		{"given", []string{"-device_id", "unit-7"}, "unit-7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, logging, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+			if config.Output.DeviceID != tt.want || logging.device != tt.want {
+				t.Errorf("device ID = %q, logged as %q, want %q", config.Output.DeviceID, logging.device, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowModeFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default", nil, "tumbling"},
+		{"sliding", []string{"-window_mode", "sliding"}, "sliding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+			if config.WindowMode != tt.want {
+				t.Errorf("window mode = %q, want %q", config.WindowMode, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxCreateBucketFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		create    bool
+		retention time.Duration
+	}{
+		{"default", nil, false, 0},
+		{"kept forever", []string{"-influx_create_bucket"}, true, 0},
+		{"with a retention", []string{"-influx_create_bucket", "-influx_retention", "720h"}, true, 720 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+			if config.Influx.CreateBucket != tt.create || config.Influx.Retention != tt.retention {
+				t.Errorf("create bucket, retention = %v, %v, want %v, %v", config.Influx.CreateBucket, config.Influx.Retention, tt.create, tt.retention)
+			}
+		})
+	}
+}
+
+func TestMaxSamplesFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, 0},
+		{[]string{"-max_samples", "100"}, 100},
+	}
+	for _, tt := range tests {
+		config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+		if config.MaxSamples != tt.want {
+			t.Errorf("parseFlags(%q) max samples = %d, want %d", tt.args, config.MaxSamples, tt.want)
+		}
+	}
+}
+
+func TestHeartbeatIntervalFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want time.Duration
+	}{
+		{nil, 0},
+		{[]string{"-heartbeat_interval", "5m"}, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		config, _, _, _ := parseFlags(append([]string{"-mock", "-dry_run"}, tt.args...))
+		if config.HeartbeatInterval != tt.want {
+			t.Errorf("parseFlags(%q) heartbeat interval = %v, want %v", tt.args, config.HeartbeatInterval, tt.want)
+		}
+	}
+}
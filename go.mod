@@ -1,12 +1,49 @@
 module gitgub.com/UbunTom/environmentmonitor
 
-go 1.16
+go 1.21
 
 require (
-	github.com/influxdata/influxdb-client-go/v2 v2.4.0 // indirect
-	github.com/quhar/bme280 v0.1.0 // indirect
-	golang.org/x/exp v0.0.0-20210604202826-bacb2583bd66 // indirect
-	periph.io/x/conn/v3 v3.6.8 // indirect
-	periph.io/x/devices/v3 v3.6.11 // indirect
-	periph.io/x/host/v3 v3.7.0 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/gorilla/websocket v1.4.2
+	github.com/influxdata/influxdb-client-go/v2 v2.4.0
+	github.com/influxdata/line-protocol v0.0.0-20200327222509-2487e7298839
+	github.com/lib/pq v1.10.2
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp v1.24.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.24.0
+	go.opentelemetry.io/otel/metric v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/sdk/metric v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+	gopkg.in/yaml.v2 v2.3.0
+	periph.io/x/conn/v3 v3.6.8
+	periph.io/x/devices/v3 v3.6.11
+	periph.io/x/host/v3 v3.7.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cenkalti/backoff/v4 v4.2.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/deepmap/oapi-codegen v1.6.0 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.19.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.26.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.24.0 // indirect
+	go.opentelemetry.io/proto/otlp v1.1.0 // indirect
+	golang.org/x/net v0.19.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20240102182953-50ed04b92917 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240102182953-50ed04b92917 // indirect
+	google.golang.org/grpc v1.61.1 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+	periph.io/x/d2xx v0.0.1 // indirect
 )
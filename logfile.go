@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rotatingFile appends log output to a file, renaming it once it grows past a
+// size limit and starting a new one. Rotated files are numbered like
+// logrotate's, monitor.log.1 being the newest, and only the newest `keep` of
+// them are kept.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxBytes int64, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	// Open the file at `f.path` for appending, continuing from its current
+	// size
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	// Write `p`, first rotating the file if it would take it past
+	// `f.maxBytes`. Records aren't split between files, so a record larger
+	// than the limit gets a file of its own.
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			// There's nowhere else to log this, and losing log records is
+			// worse than letting the file grow, so carry on with the old one
+			fmt.Fprintf(os.Stderr, "Could not rotate the log file %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	// Shift the rotated files along, so monitor.log.1 becomes monitor.log.2
+	// and so on, rename the current file to monitor.log.1 and start a new
+	// one, then remove any beyond the newest `f.keep`. The current file is
+	// only closed once the new one is open, so it's kept if that fails.
+
+	for i := f.keep - 1; i >= 1; i-- {
+		if err := os.Rename(f.rotatedPath(i), f.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if f.keep > 0 {
+		if err := os.Rename(f.path, f.rotatedPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return f.prune()
+}
+
+func (f *rotatingFile) rotatedPath(n int) string {
+	return f.path + "." + strconv.Itoa(n)
+}
+
+func (f *rotatingFile) prune() error {
+	// Remove rotated files numbered beyond `f.keep`, including those left by
+	// an earlier run that kept more
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(match, f.path+"."))
+		if err != nil || n <= f.keep {
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	// Each record is 10 bytes, numbered from 0
+	tests := []struct {
+		name     string
+		maxBytes int64
+		keep     int
+		records  int
+		// Records in each file, from monitor.log through the rotated ones
+		want [][]int
+	}{
+		{"under the limit", 100, 2, 5, [][]int{{0, 1, 2, 3, 4}}},
+		{"rotated at the limit", 30, 2, 4, [][]int{{3}, {0, 1, 2}}},
+		{"rotated files shift along", 20, 3, 6, [][]int{{4, 5}, {2, 3}, {0, 1}}},
+		{"oldest pruned", 20, 2, 8, [][]int{{6, 7}, {4, 5}, {2, 3}}},
+		{"none kept", 20, 0, 5, [][]int{{4}}},
+		{"never rotated", 0, 2, 20, [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}}},
+		{"records larger than the limit", 5, 1, 3, [][]int{{2}, {1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "monitor.log")
+			f, err := newRotatingFile(path, tt.maxBytes, tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.records; i++ {
+				if _, err := fmt.Fprintf(f, "record %02d\n", i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.Close()
+
+			var got [][]int
+			for i := 0; ; i++ {
+				name := path
+				if i > 0 {
+					name = f.rotatedPath(i)
+				}
+				data, err := os.ReadFile(name)
+				if os.IsNotExist(err) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				records := []int{}
+				for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+					n, err := strconv.Atoi(strings.TrimPrefix(line, "record "))
+					if err != nil {
+						t.Fatalf("unexpected line %q", line)
+					}
+					records = append(records, n)
+				}
+				got = append(got, records)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]int]) {
+				t.Errorf("files hold records %v, want %v", got, tt.want)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != len(tt.want) {
+				t.Errorf("%d files, want %d", len(entries), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestRotatingFilePrunesEarlierRuns(t *testing.T) {
+	// Files left by a run that kept more are removed on the next rotation
+	dir := t.TempDir()
+	path := filepath.Join(dir, "monitor.log")
+	for _, name := range []string{"monitor.log", "monitor.log.1", "monitor.log.2", "monitor.log.3", "monitor.log.4"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("old record\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := newRotatingFile(path, 15, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new record\n"))
+	f.Close()
+
+	var names []string
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"monitor.log", "monitor.log.1", "monitor.log.2"}; !slices.Equal(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+}
+
+func TestLogDestination(t *testing.T) {
+	defer func(logger *slog.Logger, level slog.Level) {
+		slog.SetDefault(logger)
+		minLevel.Set(level)
+	}(slog.Default(), minLevel.Level())
+
+	w, err := openLog(logOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closer, ok := w.(nopCloser); !ok || closer.Writer != os.Stderr {
+		t.Errorf("openLog() without a file = %#v, want stderr", w)
+	}
+
+	var out bytes.Buffer
+	setupLogging(&out, logOptions{level: slog.LevelWarn, device: "unit-7"})
+	slog.Info("not written")
+	slog.Warn("written")
+	if got := out.String(); strings.Contains(got, "not written") || !strings.Contains(got, "msg=written device=unit-7") {
+		t.Errorf("logged %q, want only the warning, with the device", got)
+	}
+}
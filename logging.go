@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Minimum level of log records that are written, which can be changed by
+// reloading the config file
+var minLevel slog.LevelVar
+
+// Where log records are written, and which of them, as set by the -log_*
+// flags
+type logOptions struct {
+	level slog.Level
+	// Device ID added to every log record, or "" for none
+	device string
+	// File to write to, or "" for stderr
+	file string
+	// Size in bytes at which the file is rotated, or 0 to never rotate, and
+	// the number of rotated files kept
+	maxBytes int64
+	keep     int
+}
+
+func openLog(options logOptions) (io.WriteCloser, error) {
+	// Open the destination of log records described by `options`
+
+	if options.file == "" {
+		return nopCloser{os.Stderr}, nil
+	}
+	return newRotatingFile(options.file, options.maxBytes, options.keep)
+}
+
+// nopCloser leaves stderr open when the log is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func setupLogging(w io.Writer, options logOptions) {
+	// Send log records at `options.level` and above to `w`, including those
+	// from the standard `log` package, each with the device ID if there is
+	// one
+
+	minLevel.Set(options.level)
+	logger := slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: &minLevel}))
+	if options.device != "" {
+		logger = logger.With("device", options.device)
+	}
+	slog.SetDefault(logger)
+}
+
+func fatal(msg string, args ...any) {
+	// Log `msg` as an error and exit
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
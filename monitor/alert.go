@@ -0,0 +1,251 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An AlertThreshold is a limit on one of the values written, such as temp>30
+type AlertThreshold struct {
+	// "temp", "pressure" or "humidity", compared in the unit it's written in
+	Field string
+	// Whether the alert fires above `Limit`, rather than below it
+	Above bool
+	Limit float64
+}
+
+func (t AlertThreshold) String() string {
+	op := "<"
+	if t.Above {
+		op = ">"
+	}
+	return t.Field + op + strconv.FormatFloat(t.Limit, 'f', -1, 64)
+}
+
+func ParseAlertThreshold(value string) (AlertThreshold, error) {
+	// Parse a threshold given as <field>><limit> or <field><<limit>, e.g.
+	// temp>30 or humidity<20
+
+	i := strings.IndexAny(value, "<>")
+	if i < 0 {
+		return AlertThreshold{}, fmt.Errorf("invalid alert %q: expected <field>><limit> or <field><<limit>, e.g. temp>30", value)
+	}
+	threshold := AlertThreshold{Field: strings.TrimSpace(value[:i]), Above: value[i] == '>'}
+	switch threshold.Field {
+	case "temp", "pressure", "humidity":
+	default:
+		return threshold, fmt.Errorf("invalid alert %q: field must be temp, pressure or humidity", value)
+	}
+	limit, err := strconv.ParseFloat(strings.TrimSpace(value[i+1:]), 64)
+	if err != nil {
+		return threshold, fmt.Errorf("invalid alert %q: limit must be a number", value)
+	}
+	threshold.Limit = limit
+	return threshold, nil
+}
+
+type AlertConfig struct {
+	Thresholds []AlertThreshold
+	// How far a value must return past its threshold before the alert clears,
+	// in the unit it's written in, so a value hovering around the threshold
+	// doesn't raise an alert on every average
+	Hysteresis float64
+	// URL to POST each alert to as JSON, or "" to only log them
+	Webhook string
+}
+
+// Time allowed for each webhook request
+const alertWebhookTimeout = 10 * time.Second
+
+// Number of alerts waiting to be sent to the webhook, beyond which new ones
+// are dropped, so a slow webhook can't hold up averaging
+const alertQueueSize = 16
+
+// The JSON body POSTed to the webhook when an alert fires or clears
+type alertPayload struct {
+	// "firing" when the threshold is crossed, or "resolved" once the value
+	// has come back past it by the hysteresis
+	State     string  `json:"state"`
+	Threshold string  `json:"threshold"`
+	Field     string  `json:"field"`
+	Limit     float64 `json:"limit"`
+	Value     float64 `json:"value"`
+	// Unit of `Limit` and `Value`, e.g. c or hpa
+	Unit string `json:"unit"`
+	// Time of the average that crossed the threshold, in RFC3339 format
+	Time     string `json:"time"`
+	Sensor   string `json:"sensor,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Location string `json:"location,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+// alerter checks averaged readings against the alert thresholds, and sends
+// alerts to the webhook in the background
+type alerter struct {
+	config AlertConfig
+	output OutputConfig
+	client *http.Client
+
+	mu sync.Mutex
+	// Whether each threshold is firing for each sensor, keyed by
+	// `alertKey`. Missing keys aren't firing.
+	firing map[string]bool
+
+	queue chan alertPayload
+	sent  chan struct{}
+	// Set under `mu` once `queue` is closed, so nothing more is queued
+	stopped bool
+}
+
+func setupAlerts(config AlertConfig, output OutputConfig) (a *alerter, stop func()) {
+	// Return an alerter checking averages against `config.Thresholds` until
+	// `stop` is called, which waits for queued alerts to be sent. Averages
+	// checked after that don't send alerts.
+
+	a = newAlerter(config, output)
+	go a.send()
+	return a, func() {
+		a.mu.Lock()
+		a.stopped = true
+		close(a.queue)
+		a.mu.Unlock()
+		<-a.sent
+	}
+}
+
+func newAlerter(config AlertConfig, output OutputConfig) *alerter {
+	return &alerter{
+		config: config,
+		output: output,
+		client: &http.Client{Timeout: alertWebhookTimeout},
+		firing: map[string]bool{},
+		queue:  make(chan alertPayload, alertQueueSize),
+		sent:   make(chan struct{}),
+	}
+}
+
+func alertKey(label string, threshold AlertThreshold) string {
+	return label + "\x00" + threshold.String()
+}
+
+func (a *alerter) check(reading Reading, t time.Time) {
+	// Compare `reading`, averaged at time `t`, with each threshold, alerting
+	// when one is crossed and when it clears
+
+	temp, pressure, humidity := convertEnv(reading.Env, a.output)
+	values := map[string]float64{}
+	units := map[string]string{"temp": string(a.output.TemperatureUnit), "pressure": string(a.output.PressureUnit), "humidity": "pct"}
+	if a.output.Fields.Temperature {
+		values["temp"] = temp
+	}
+	if a.output.Fields.Pressure {
+		values["pressure"] = pressure
+	}
+	if reading.HasHumidity {
+		values["humidity"] = humidity
+	}
+	tags := readingTags(reading, a.output)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, threshold := range a.config.Thresholds {
+		value, ok := values[threshold.Field]
+		if !ok {
+			continue
+		}
+		key := alertKey(reading.Label, threshold)
+		firing := a.firing[key]
+		next := a.evaluate(threshold, value, firing)
+		if next == firing {
+			continue
+		}
+		a.firing[key] = next
+
+		payload := alertPayload{
+			State:     "resolved",
+			Threshold: threshold.String(),
+			Field:     threshold.Field,
+			Limit:     threshold.Limit,
+			Value:     value,
+			Unit:      units[threshold.Field],
+			Time:      t.Format(time.RFC3339),
+			Sensor:    tags["sensor"],
+			Host:      tags["host"],
+			Location:  tags["location"],
+			Device:    tags["device"],
+		}
+		if next {
+			payload.State = "firing"
+			slog.Warn("Alert firing", "threshold", payload.Threshold, "value", value, "sensor", reading.Label)
+		} else {
+			slog.Info("Alert resolved", "threshold", payload.Threshold, "value", value, "sensor", reading.Label)
+		}
+		a.enqueue(payload)
+	}
+}
+
+func (a *alerter) evaluate(threshold AlertThreshold, value float64, firing bool) bool {
+	// Whether `threshold` should be firing for `value`, given whether it was
+	// already. It fires as soon as the value is past the limit, but only
+	// clears once it's back by `a.config.Hysteresis`.
+
+	if threshold.Above {
+		if firing {
+			return value > threshold.Limit-a.config.Hysteresis
+		}
+		return value > threshold.Limit
+	}
+	if firing {
+		return value < threshold.Limit+a.config.Hysteresis
+	}
+	return value < threshold.Limit
+}
+
+func (a *alerter) enqueue(payload alertPayload) {
+	if a.config.Webhook == "" || a.stopped {
+		return
+	}
+	select {
+	case a.queue <- payload:
+	default:
+		slog.Error("Alert queue full, dropping alert", "threshold", payload.Threshold, "state", payload.State)
+	}
+}
+
+func (a *alerter) send() {
+	// POST each queued alert to the webhook until the queue is closed
+
+	defer close(a.sent)
+	for payload := range a.queue {
+		if err := a.post(payload); err != nil {
+			slog.Error("Could not send alert to the webhook", "threshold", payload.Threshold, "state", payload.State, "error", err)
+		}
+	}
+}
+
+func (a *alerter) post(payload alertPayload) error {
+	// Thresholds contain < or >, which are only escaped for embedding in HTML
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.config.Webhook, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestParseAlertThreshold(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    AlertThreshold
+		wantErr bool
+	}{
+		{"temp>30", AlertThreshold{"temp", true, 30}, false},
+		{"humidity<20.5", AlertThreshold{"humidity", false, 20.5}, false},
+		{"pressure < -1", AlertThreshold{"pressure", false, -1}, false},
+		{"temp=30", AlertThreshold{}, true},
+		{"gas>30", AlertThreshold{}, true},
+		{"temp>hot", AlertThreshold{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseAlertThreshold(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAlertThreshold(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseAlertThreshold(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertHysteresis(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string
+		values    []float64
+		// Alert sent after each value, or "" for none
+		want []string
+	}{
+		{
+			"above",
+			"temp>30",
+			[]float64{29, 31, 30.5, 29.5, 29, 31},
+			[]string{"", "firing", "", "", "resolved", "firing"},
+		},
+		{
+			"hovering around the limit",
+			"temp>30",
+			[]float64{30.1, 29.9, 30.1, 29.9},
+			[]string{"firing", "", "", ""},
+		},
+		{
+			"below",
+			"temp<5",
+			[]float64{6, 4, 5.5, 6.1},
+			[]string{"", "firing", "", "resolved"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threshold, err := ParseAlertThreshold(tt.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The queue isn't sent, so the alerts can be read from it
+			a := newAlerter(AlertConfig{Thresholds: []AlertThreshold{threshold}, Hysteresis: 1, Webhook: "http://example.invalid"}, testConfig().Output)
+			for i, value := range tt.values {
+				a.check(testReading(value, 0), testTime)
+				got := ""
+				select {
+				case payload := <-a.queue:
+					got = payload.State
+				default:
+				}
+				if got != tt.want[i] {
+					t.Errorf("after %v, alert = %q, want %q", value, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAlertsPerSensor(t *testing.T) {
+	// Each sensor's alerts fire and clear separately
+	threshold := AlertThreshold{Field: "temp", Above: true, Limit: 30}
+	a := newAlerter(AlertConfig{Thresholds: []AlertThreshold{threshold}, Webhook: "http://example.invalid"}, testConfig().Output)
+	var sensors []string
+	for _, label := range []string{"indoor", "outdoor", "indoor"} {
+		reading := testReading(35, 0)
+		reading.Label = label
+		a.check(reading, testTime)
+	}
+	close(a.queue)
+	for payload := range a.queue {
+		sensors = append(sensors, payload.Sensor)
+	}
+	if want := []string{"indoor", "outdoor"}; !slices.Equal(sensors, want) {
+		t.Errorf("alerts for %v, want %v", sensors, want)
+	}
+}
+
+func TestAlertWebhookPayload(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, strings.TrimSpace(string(body)))
+		mu.Unlock()
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+	}))
+	defer server.Close()
+
+	output := testConfig().Output
+	output.Host, output.Location = "pi", "cellar"
+	thresholds := []AlertThreshold{{Field: "humidity", Above: true, Limit: 70}}
+	a, stop := setupAlerts(AlertConfig{Thresholds: thresholds, Hysteresis: 5, Webhook: server.URL}, output)
+	reading := testReading(20, 0)
+	reading.Label = "north wall"
+	reading.Humidity = 75 * physic.PercentRH
+	a.check(reading, testTime)
+	reading.Humidity = 60 * physic.PercentRH
+	a.check(reading, testTime.Add(time.Hour))
+	stop()
+
+	want := []string{
+		`{"state":"firing","threshold":"humidity>70","field":"humidity","limit":70,"value":75,"unit":"pct","time":"2024-01-01T12:00:00Z","sensor":"north wall","host":"pi","location":"cellar"}`,
+		`{"state":"resolved","threshold":"humidity>70","field":"humidity","limit":70,"value":60,"unit":"pct","time":"2024-01-01T13:00:00Z","sensor":"north wall","host":"pi","location":"cellar"}`,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(bodies, want) {
+		t.Errorf("webhook received\n%s\nwant\n%s", strings.Join(bodies, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,400 @@
+package monitor
+
+import (
+	"log/slog"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// WindowStats describes the spread of the readings in an averaging window
+type WindowStats struct {
+	Min physic.Env
+	Max physic.Env
+	// Population standard deviation of each field. These are differences, so
+	// e.g. `StdDev.Temperature` is relative to 0K rather than an absolute
+	// temperature.
+	StdDev physic.Env
+}
+
+// An Aggregator combines the readings in a window into a single value. It's
+// never given an empty window.
+type Aggregator func(samples []physic.Env) physic.Env
+
+// Aggregations accepted by the `-aggregation` flag
+var Aggregations = map[string]Aggregator{
+	"mean":   mean,
+	"median": median,
+}
+
+func mean(samples []physic.Env) physic.Env {
+	var total physic.Env
+	for _, env := range samples {
+		total.Temperature += env.Temperature
+		total.Pressure += env.Pressure
+		total.Humidity += env.Humidity
+	}
+
+	divisor := int64(len(samples))
+	return physic.Env{
+		Temperature: physic.Temperature(int64(total.Temperature) / divisor),
+		Pressure:    physic.Pressure(int64(total.Pressure) / divisor),
+		Humidity:    physic.RelativeHumidity(int64(total.Humidity) / divisor),
+	}
+}
+
+func median(samples []physic.Env) physic.Env {
+	// The median of each field, which unlike the mean isn't skewed by brief
+	// spikes. For an even number of samples, this is the mean of the middle
+	// two.
+
+	temperatures := make([]int64, len(samples))
+	pressures := make([]int64, len(samples))
+	humidities := make([]int64, len(samples))
+	for i, env := range samples {
+		temperatures[i] = int64(env.Temperature)
+		pressures[i] = int64(env.Pressure)
+		humidities[i] = int64(env.Humidity)
+	}
+
+	return physic.Env{
+		Temperature: physic.Temperature(medianOf(temperatures)),
+		Pressure:    physic.Pressure(medianOf(pressures)),
+		Humidity:    physic.RelativeHumidity(medianOf(humidities)),
+	}
+}
+
+func medianOf(values []int64) int64 {
+	// Sorts `values` in place
+	slices.Sort(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// accumulator collects the readings in an averaging window
+type accumulator struct {
+	// Readings in the window, kept for aggregations that need them all
+	samples []physic.Env
+	// Sum of each field, and the sum of its squares
+	total       physic.Env
+	squares     [3]float64
+	min         physic.Env
+	max         physic.Env
+	count       int
+	hasHumidity bool
+	label       string
+	// Latest voltage read in the window, if any
+	voltage *physic.ElectricPotential
+	// Sum of the gas resistances measured in the window, in Ω, and how many
+	// there were
+	gasTotal float64
+	gasCount int
+	// Times of the first and last readings in the window
+	first, last time.Time
+	// Sequence number of the last reading in the window
+	seq uint64
+}
+
+// Values accepted by the `-window_mode` flag: whether each reading is in one
+// window, or windows overlap so there's an average after every reading
+var WindowModes = []string{"tumbling", "sliding"}
+
+// Values accepted by the `-timestamp_mode` flag: whether a window's average is
+// timestamped with its last reading or the midpoint of its first and last
+var TimestampModes = []string{"end", "mid"}
+
+func (a *accumulator) add(reading Reading) {
+	env := reading.Env
+	if a.count == 0 {
+		a.min, a.max = env, env
+		a.first = reading.Time
+	}
+	a.last = reading.Time
+	a.seq = reading.Seq
+
+	a.samples = append(a.samples, env)
+	a.total.Temperature += env.Temperature
+	a.total.Pressure += env.Pressure
+	a.total.Humidity += env.Humidity
+
+	a.squares[0] += float64(env.Temperature) * float64(env.Temperature)
+	a.squares[1] += float64(env.Pressure) * float64(env.Pressure)
+	a.squares[2] += float64(env.Humidity) * float64(env.Humidity)
+
+	if env.Temperature < a.min.Temperature {
+		a.min.Temperature = env.Temperature
+	}
+	if env.Temperature > a.max.Temperature {
+		a.max.Temperature = env.Temperature
+	}
+	if env.Pressure < a.min.Pressure {
+		a.min.Pressure = env.Pressure
+	}
+	if env.Pressure > a.max.Pressure {
+		a.max.Pressure = env.Pressure
+	}
+	if env.Humidity < a.min.Humidity {
+		a.min.Humidity = env.Humidity
+	}
+	if env.Humidity > a.max.Humidity {
+		a.max.Humidity = env.Humidity
+	}
+
+	a.hasHumidity = reading.HasHumidity
+	a.label = reading.Label
+	if reading.Voltage != nil {
+		a.voltage = reading.Voltage
+	}
+	if reading.Gas != nil {
+		a.gasTotal += ohms(*reading.Gas)
+		a.gasCount++
+	}
+	a.count++
+}
+
+// Number of readings a window needs before later ones can be rejected as
+// outliers, as the spread of fewer says little about the sensor's noise
+const minOutlierSamples = 3
+
+func (a *accumulator) outlier(env physic.Env, sigma float64) string {
+	// The name of the first field of `env` that's more than `sigma` standard
+	// deviations from the window's mean, or "" if none is. Fields that haven't
+	// varied during the window are never outliers, as any change would be
+	// infinitely many standard deviations away.
+
+	if a.count < minOutlierSamples {
+		return ""
+	}
+	fields := []struct {
+		name    string
+		value   int64
+		total   int64
+		squares float64
+	}{
+		{"temperature", int64(env.Temperature), int64(a.total.Temperature), a.squares[0]},
+		{"pressure", int64(env.Pressure), int64(a.total.Pressure), a.squares[1]},
+		{"humidity", int64(env.Humidity), int64(a.total.Humidity), a.squares[2]},
+	}
+	if !a.hasHumidity {
+		fields = fields[:2]
+	}
+	for _, field := range fields {
+		mean := float64(field.total) / float64(a.count)
+		spread := stdDev(field.total, field.squares, a.count)
+		if spread > 0 && math.Abs(float64(field.value)-mean) > sigma*spread {
+			return field.name
+		}
+	}
+	return ""
+}
+
+func stdDev(total int64, squares float64, count int) float64 {
+	// Population standard deviation, from the sum of the values and the sum of
+	// their squares
+
+	mean := float64(total) / float64(count)
+	variance := squares/float64(count) - mean*mean
+	if variance < 0 {
+		// Rounding error when all the values are (nearly) the same
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+func (a *accumulator) average(aggregate Aggregator, timestampMode string) Reading {
+	// The readings added so far combined with `aggregate`, along with their
+	// spread, timestamped as `timestampMode` says. Without any readings,
+	// there's nothing to aggregate, so the average is zero and has no spread.
+
+	average := Reading{HasHumidity: a.hasHumidity, Label: a.label, Voltage: a.voltage, Time: a.last, Seq: a.seq}
+	if timestampMode == "mid" {
+		average.Time = a.first.Add(a.last.Sub(a.first) / 2)
+	}
+	if a.count == 0 {
+		return average
+	}
+	average.Env = aggregate(a.samples)
+	if a.gasCount > 0 {
+		gas := physic.ElectricResistance(a.gasTotal / float64(a.gasCount) * float64(physic.Ohm))
+		average.Gas = &gas
+	}
+
+	average.Stats = &WindowStats{
+		Min: a.min,
+		Max: a.max,
+		StdDev: physic.Env{
+			Temperature: physic.Temperature(stdDev(int64(a.total.Temperature), a.squares[0], a.count)),
+			Pressure:    physic.Pressure(stdDev(int64(a.total.Pressure), a.squares[1], a.count)),
+			Humidity:    physic.RelativeHumidity(stdDev(int64(a.total.Humidity), a.squares[2], a.count)),
+		},
+	}
+	return average
+}
+
+func computeSum(clock Clock, steps *atomic.Int64, duration time.Duration, fields Fields, outlierSigma float64, input <-chan Reading, output chan<- accumulator) {
+	// Read up to `steps` values from `input`, accumulating their sum and spread
+	// Once `steps` inputs have been received, the accumulator is written to the `output` channel
+	// `steps` can be changed while running, taking effect from the current window
+	// If `duration` is set, `steps` is ignored and the accumulator is written
+	// each time `duration` elapses instead, however many inputs were received.
+	// Windows without any inputs are skipped.
+	// Values that aren't in `fields` are zeroed rather than accumulated, so
+	// they never make an input an outlier.
+	// If `outlierSigma` is set, inputs further than that many standard
+	// deviations from the mean of the window so far are logged and dropped.
+	// When `input` is closed, the partial window is written, averaged over the
+	// values it received, before `output` is closed
+
+	defer slog.Info("Averaging stopped")
+	defer close(output)
+
+	// A nil channel never fires, so windows are only timed if `duration` is set
+	var elapsed <-chan time.Time
+	if duration > 0 {
+		ticker := clock.NewTicker(duration)
+		defer ticker.Stop()
+		elapsed = ticker.C()
+	}
+
+	window := accumulator{}
+	flush := func() {
+		if window.count > 0 {
+			output <- window
+		}
+		window = accumulator{}
+	}
+	add := func(reading Reading) {
+		reading.Env = fields.mask(reading.Env)
+		if outlierSigma > 0 {
+			if field := window.outlier(reading.Env, outlierSigma); field != "" {
+				slog.Warn("Dropping outlier", "reading", reading, "field", field, "sigma", outlierSigma)
+				outliersRejected.Inc()
+				return
+			}
+		}
+		window.add(reading)
+		slog.Debug("Added sample to window", "reading", reading, "count", window.count)
+
+		if duration == 0 && window.count >= int(steps.Load()) {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case reading, open := <-input:
+			if !open {
+				// Write the partial window rather than losing its readings
+				flush()
+				return
+			}
+			add(reading)
+		case <-elapsed:
+			flush()
+		}
+	}
+}
+
+func accumulate(readings []Reading) accumulator {
+	// An accumulator of `readings`
+
+	var window accumulator
+	for _, reading := range readings {
+		window.add(reading)
+	}
+	return window
+}
+
+func computeSliding(steps *atomic.Int64, fields Fields, outlierSigma float64, input <-chan Reading, output chan<- accumulator) {
+	// Keep the last `steps` values from `input`, and once there are that
+	// many, write an accumulator of them to `output` after every input, so
+	// each window overlaps the one before by all but one reading.
+	// `steps` can be changed while running: the oldest readings are dropped
+	// to shrink the window, and the next average waits for it to fill.
+	// Values that aren't in `fields` are zeroed rather than accumulated.
+	// If `outlierSigma` is set, inputs further than that many standard
+	// deviations from the mean of the current window are logged and dropped.
+	// If `input` is closed before a window has filled, the readings received
+	// are written as a partial window, so they aren't lost, before `output`
+	// is closed
+
+	defer slog.Info("Averaging stopped")
+	defer close(output)
+
+	var held ring[Reading]
+	written := false
+	for reading := range input {
+		held.resize(int(steps.Load()))
+		reading.Env = fields.mask(reading.Env)
+		if outlierSigma > 0 {
+			window := accumulate(held.ordered())
+			if field := window.outlier(reading.Env, outlierSigma); field != "" {
+				slog.Warn("Dropping outlier", "reading", reading, "field", field, "sigma", outlierSigma)
+				outliersRejected.Inc()
+				continue
+			}
+		}
+		held.push(reading)
+		slog.Debug("Added sample to sliding window", "reading", reading, "count", held.count)
+
+		if held.full() {
+			output <- accumulate(held.ordered())
+			written = true
+		}
+	}
+	if !written && held.count > 0 {
+		output <- accumulate(held.ordered())
+	}
+}
+
+func (s *runState) passthroughStream(fields Fields, logging <-chan Reading, averages chan<- Reading) {
+	// Send each raw reading from the `logging` chan straight to the `averages`
+	// chan, so every reading is written with the time it was taken, for when
+	// averaging is turned off. Values that aren't in `fields` are zeroed.
+	// `averages` is closed once `logging` is closed
+
+	defer close(averages)
+	for reading := range logging {
+		reading.Env = fields.mask(reading.Env)
+		s.recordAverage(reading, reading.Time)
+		averages <- reading
+	}
+}
+
+func (s *runState) averageStream(steps *atomic.Int64, duration time.Duration, windowMode string, fields Fields, aggregate Aggregator, outlierSigma float64, timestampMode string, logging <-chan Reading, averages chan<- Reading) {
+	// Continuously reads from the `logging` chan, passing the values to the `computeSum`
+	// goroutine, or to `computeSliding` if `windowMode` is "sliding". When
+	// that goroutine outputs a window, its values are combined
+	// with `aggregate`, timestamped as `timestampMode` says, and sent to the
+	// `averages` chan.
+	// This function effectively averages values from the `logging` chan with a window of size `steps`,
+	// or of length `duration` if it is set
+	// `averages` is closed once `logging` is closed and `computeSum` has
+	// flushed its final window
+
+	defer close(averages)
+
+	windows := make(chan accumulator)
+	if windowMode == "sliding" {
+		go computeSliding(steps, fields, outlierSigma, logging, windows)
+	} else {
+		go computeSum(s.clock, steps, duration, fields, outlierSigma, logging, windows)
+	}
+	var rates rateTracker
+	var trend pressureTrendTracker
+	var mold moldRiskTracker
+	for window := range windows {
+		average := window.average(aggregate, timestampMode)
+		rates.update(&average, average.Time)
+		trend.update(&average, average.Time, s.pressureTrend)
+		mold.update(&average, average.Time, s.moldRisk)
+		s.recordAverage(average, average.Time)
+		averages <- average
+	}
+}
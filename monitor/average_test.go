@@ -0,0 +1,398 @@
+package monitor
+
+import (
+	"math"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"periph.io/x/conn/v3/physic"
+)
+
+func celsius(c float64) physic.Temperature {
+	return physic.ZeroCelsius + physic.Temperature(c*float64(physic.Kelvin))
+}
+
+func testReading(temp float64, at time.Duration) Reading {
+	return Reading{
+		Env:         physic.Env{Temperature: celsius(temp), Pressure: 1013 * 100 * physic.Pascal, Humidity: 50 * physic.PercentRH},
+		HasHumidity: true,
+		Time:        testTime.Add(at),
+	}
+}
+
+func receive[T any](t *testing.T, c <-chan T) (T, bool) {
+	t.Helper()
+	select {
+	case v, ok := <-c:
+		return v, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a value")
+		panic("unreachable")
+	}
+}
+
+func TestComputeSumDuration(t *testing.T) {
+	// Windows of a length of time close when the clock says so, however
+	// many readings they have, and empty ones are skipped
+
+	clock := NewFakeClock(testTime)
+	steps := new(atomic.Int64)
+	steps.Store(2)
+	input := make(chan Reading)
+	output := make(chan accumulator, 10)
+	go computeSum(clock, steps, time.Minute, Fields{Temperature: true, Pressure: true, Humidity: true}, 0, input, output)
+
+	for i := 0; i < 3; i++ {
+		input <- testReading(20, time.Duration(i)*time.Second)
+	}
+	clock.Advance(time.Minute)
+	window, _ := receive(t, output)
+	if window.count != 3 {
+		t.Errorf("first window has %d readings, want 3", window.count)
+	}
+
+	clock.Advance(time.Minute)
+	input <- testReading(21, 2*time.Minute)
+	close(input)
+	window, _ = receive(t, output)
+	if window.count != 1 {
+		t.Errorf("partial window has %d readings, want 1", window.count)
+	}
+	if _, open := receive(t, output); open {
+		t.Error("output wasn't closed after the partial window")
+	}
+}
+
+func TestComputeSumSkipsDisabledFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+	}{
+		{"all", Fields{Temperature: true, Pressure: true, Humidity: true}},
+		{"temp only", Fields{Temperature: true}},
+		{"pressure only", Fields{Pressure: true}},
+		{"humidity only", Fields{Humidity: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := new(atomic.Int64)
+			steps.Store(2)
+			input := make(chan Reading, 2)
+			output := make(chan accumulator, 1)
+			go computeSum(NewFakeClock(testTime), steps, 0, tt.fields, 0, input, output)
+			input <- testReading(20, 0)
+			input <- testReading(22, time.Second)
+			window, _ := receive(t, output)
+			close(input)
+
+			want := tt.fields.mask(physic.Env{Temperature: 2 * celsius(21), Pressure: 2 * 1013 * 100 * physic.Pascal, Humidity: 100 * physic.PercentRH})
+			if window.total != want {
+				t.Errorf("total = %+v, want %+v", window.total, want)
+			}
+		})
+	}
+}
+
+func TestAccumulatorStats(t *testing.T) {
+	tests := []struct {
+		name                string
+		temps               []float64
+		mean, min, max, std float64
+	}{
+		{"single reading", []float64{20}, 20, 20, 20, 0},
+		{"constant", []float64{15, 15, 15}, 15, 15, 15, 0},
+		{"known dataset", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2, 9, 2},
+		{"below zero", []float64{-10, 0, 10}, 0, -10, 10, math.Sqrt(200.0 / 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var window accumulator
+			for i, temp := range tt.temps {
+				reading := testReading(temp, time.Duration(i)*time.Second)
+				// Humidity goes the other way, to check the fields are kept
+				// apart
+				reading.Humidity = physic.RelativeHumidity((50 - temp) * float64(physic.PercentRH))
+				window.add(reading)
+			}
+			average := window.average(mean, "end")
+
+			near := func(field string, got, want float64) {
+				t.Helper()
+				// Humidities are only held to 1e-5%
+				if math.Abs(got-want) > 1e-4 {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+			percent := func(h physic.RelativeHumidity) float64 {
+				return float64(h) / float64(physic.PercentRH)
+			}
+			near("mean", average.Temperature.Celsius(), tt.mean)
+			near("min", average.Stats.Min.Temperature.Celsius(), tt.min)
+			near("max", average.Stats.Max.Temperature.Celsius(), tt.max)
+			near("std", float64(average.Stats.StdDev.Temperature)/float64(physic.Kelvin), tt.std)
+			near("humidity mean", percent(average.Humidity), 50-tt.mean)
+			near("humidity min", percent(average.Stats.Min.Humidity), 50-tt.max)
+			near("humidity max", percent(average.Stats.Max.Humidity), 50-tt.min)
+			near("humidity std", percent(average.Stats.StdDev.Humidity), tt.std)
+			near("pressure std", float64(average.Stats.StdDev.Pressure), 0)
+
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Pressure: true, Humidity: true}}
+			stats := convertStats(average.Stats, true, output)
+			near("temp_min", stats["temp_min"], tt.min)
+			near("temp_max", stats["temp_max"], tt.max)
+			near("temp_std", stats["temp_std"], tt.std)
+			near("pressure_min", stats["pressure_min"], 1013)
+		})
+	}
+}
+
+func TestAccumulatorEmpty(t *testing.T) {
+	var window accumulator
+	if average := window.average(mean, "end"); average.Stats != nil || average.Env != (physic.Env{}) {
+		t.Errorf("average of no readings = %+v, want zero without stats", average)
+	}
+}
+
+func TestPartialWindowOnClose(t *testing.T) {
+	tests := []struct {
+		name       string
+		windowMode string
+		temps      []float64
+		// Temperatures of the averages written, the last being the partial
+		// window
+		want []float64
+	}{
+		{"empty", "tumbling", nil, nil},
+		{"partial window", "tumbling", []float64{20, 22}, []float64{21}},
+		{"full and partial windows", "tumbling", []float64{20, 21, 22, 30}, []float64{21, 30}},
+		{"full windows only", "tumbling", []float64{20, 21, 22}, []float64{21}},
+		{"sliding partial window", "sliding", []float64{20, 22}, []float64{21}},
+		{"sliding full window", "sliding", []float64{20, 21, 22, 30}, []float64{21, 24.333333}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			steps := new(atomic.Int64)
+			steps.Store(3)
+			logging := make(chan Reading, len(tt.temps))
+			for i, temp := range tt.temps {
+				logging <- testReading(temp, time.Duration(i)*time.Second)
+			}
+			close(logging)
+			averages := make(chan Reading, 10)
+			newRunState(config).averageStream(steps, 0, tt.windowMode, config.Output.Fields, mean, 0, "end", logging, averages)
+
+			var got []float64
+			for average := range averages {
+				got = append(got, average.Temperature.Celsius())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("averages = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-6 {
+					t.Errorf("averages = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	tests := []struct {
+		name         string
+		temps        []float64
+		mean, median float64
+	}{
+		{"single", []float64{20}, 20, 20},
+		{"odd", []float64{20, 35, 21}, 25.333333, 21},
+		{"even", []float64{20, 22, 35, 21}, 24.5, 21.5},
+		{"spike", []float64{20, 20, 20, 20, 80}, 32, 20},
+		{"negative", []float64{-5, -1, -3, -2}, -2.75, -2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := make([]physic.Env, len(tt.temps))
+			for i, temp := range tt.temps {
+				samples[i] = physic.Env{Temperature: celsius(temp), Pressure: physic.Pressure(i+1) * physic.Pascal, Humidity: percentRH(float64(len(tt.temps) - i))}
+			}
+			for name, want := range map[string]float64{"mean": tt.mean, "median": tt.median} {
+				got := Aggregations[name](slices.Clone(samples))
+				if math.Abs(got.Temperature.Celsius()-want) > 1e-6 {
+					t.Errorf("%s temperature = %v, want %v", name, got.Temperature.Celsius(), want)
+				}
+				// The other fields are aggregated on their own: pressures 1Pa
+				// up to n, and humidities n% down to 1
+				middle := float64(len(tt.temps)+1) / 2
+				if p := float64(got.Pressure) / float64(physic.Pascal); math.Abs(p-middle) > 1e-6 {
+					t.Errorf("%s pressure = %vPa, want %vPa", name, p, middle)
+				}
+				if h := float64(got.Humidity) / float64(physic.PercentRH); math.Abs(h-middle) > 1e-6 {
+					t.Errorf("%s humidity = %v%%, want %v%%", name, h, middle)
+				}
+			}
+		})
+	}
+}
+
+func TestOutlierRejection(t *testing.T) {
+	// The mean of the readings other than the outlier is 20°C, so including
+	// it would drag the average up to 23°C
+	temps := []float64{20, 20.2, 19.8, 20.1, 35, 19.9}
+	tests := []struct {
+		name       string
+		windowMode string
+		sigma      float64
+		want       float64
+		rejected   float64
+	}{
+		{"tumbling", "tumbling", 3, 20, 1},
+		{"sliding", "sliding", 3, 20, 1},
+		{"disabled", "tumbling", 0, 23.02, 0},
+		// A loose limit lets the outlier in
+		{"loose", "tumbling", 1000, 23.02, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			steps := new(atomic.Int64)
+			steps.Store(5)
+			logging := make(chan Reading, len(temps))
+			for i, temp := range temps {
+				logging <- testReading(temp, time.Duration(i)*time.Second)
+			}
+			close(logging)
+			averages := make(chan Reading, len(temps))
+			rejectedBefore := testutil.ToFloat64(outliersRejected)
+			newRunState(config).averageStream(steps, 0, tt.windowMode, config.Output.Fields, mean, tt.sigma, "end", logging, averages)
+
+			average, _ := receive(t, averages)
+			if got := average.Temperature.Celsius(); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("first average = %v°C, want %v°C", got, tt.want)
+			}
+			if rejected := testutil.ToFloat64(outliersRejected) - rejectedBefore; rejected != tt.rejected {
+				t.Errorf("rejected %v samples, want %v", rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestPassthroughStream(t *testing.T) {
+	// Every reading is passed on as it was read, with only the fields that
+	// aren't written zeroed
+	tests := []struct {
+		name   string
+		fields Fields
+	}{
+		{"all fields", Fields{Temperature: true, Pressure: true, Humidity: true}},
+		{"temperature only", Fields{Temperature: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			state := newRunState(config)
+			logging := make(chan Reading, 3)
+			averages := make(chan Reading, 3)
+			readings := []Reading{testReading(20, 0), testReading(21.5, time.Second), testReading(19, 2*time.Second)}
+			for _, reading := range readings {
+				logging <- reading
+			}
+			close(logging)
+			state.passthroughStream(tt.fields, logging, averages)
+
+			for i, want := range readings {
+				got, ok := receive(t, averages)
+				if !ok {
+					t.Fatalf("passed on %d readings, want %d", i, len(readings))
+				}
+				want.Env = tt.fields.mask(want.Env)
+				if got.Env != want.Env || !got.Time.Equal(want.Time) {
+					t.Errorf("reading %d = %v at %v, want %v at %v", i+1, got.Env, got.Time, want.Env, want.Time)
+				}
+			}
+			if _, ok := receive(t, averages); ok {
+				t.Error("passed on more readings than were read")
+			}
+		})
+	}
+}
+
+func TestComputeSliding(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps int
+		temps []float64
+		// Mean temperature of each window written
+		want []float64
+	}{
+		{"an average after every reading once full", 3, []float64{20, 21, 22, 23, 24}, []float64{21, 22, 23}},
+		{"window of one", 1, []float64{20, 21}, []float64{20, 21}},
+		{"partial window on close", 3, []float64{20, 22}, []float64{21}},
+		{"no readings", 3, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := new(atomic.Int64)
+			steps.Store(int64(tt.steps))
+			input := make(chan Reading, len(tt.temps))
+			for i, temp := range tt.temps {
+				input <- testReading(temp, time.Duration(i)*time.Second)
+			}
+			close(input)
+			output := make(chan accumulator, len(tt.temps)+1)
+			computeSliding(steps, Fields{Temperature: true, Pressure: true, Humidity: true}, 0, input, output)
+
+			var got []float64
+			for window := range output {
+				got = append(got, math.Round(window.average(mean, "end").Temperature.Celsius()*1e6)/1e6)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("window means = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeSlidingResize(t *testing.T) {
+	// Shrinking the window drops the oldest readings straight away, while
+	// growing it waits for it to fill before the next average
+
+	steps := new(atomic.Int64)
+	steps.Store(3)
+	input := make(chan Reading)
+	output := make(chan accumulator)
+	go computeSliding(steps, Fields{Temperature: true}, 0, input, output)
+
+	send := func(temp float64) { input <- testReading(temp, 0) }
+	for _, temp := range []float64{20, 21, 22} {
+		send(temp)
+	}
+	if window, _ := receive(t, output); window.count != 3 {
+		t.Errorf("window has %d readings, want 3", window.count)
+	}
+
+	steps.Store(2)
+	send(23)
+	if window, _ := receive(t, output); window.count != 2 {
+		t.Errorf("shrunk window has %d readings, want 2", window.count)
+	}
+
+	steps.Store(4)
+	go send(24)
+	select {
+	case window := <-output:
+		t.Errorf("grown window written with %d readings before it filled", window.count)
+	case <-time.After(10 * time.Millisecond):
+	}
+	send(25)
+	if window, _ := receive(t, output); window.count != 4 {
+		t.Errorf("grown window has %d readings, want 4", window.count)
+	}
+	close(input)
+}
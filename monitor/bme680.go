@@ -0,0 +1,326 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/devices/v3/bmxx80"
+)
+
+// Values accepted by the `-sensor_model` flag. "bme280" covers the sensors
+// periph's bmxx80 driver supports, including the BMP280.
+var SensorModels = []string{"bme280", "bme680"}
+
+// Registers of the BME680, from its datasheet
+const (
+	bme680ChipID     = 0xD0
+	bme680Reset      = 0xE0
+	bme680Status     = 0x1D
+	bme680CtrlGas1   = 0x71
+	bme680CtrlHum    = 0x72
+	bme680CtrlMeas   = 0x74
+	bme680Config     = 0x75
+	bme680ResHeat0   = 0x5A
+	bme680GasWait0   = 0x64
+	bme680Coeff1     = 0x89
+	bme680Coeff2     = 0xE1
+	bme680HeatVal    = 0x00
+	bme680HeatRange  = 0x02
+	bme680RangeSwErr = 0x04
+)
+
+// Temperature the gas sensor's hot plate is heated to for each measurement,
+// and for how long, as recommended by Bosch for indoor air quality
+const (
+	bme680HeaterTemp     = 320 // °C
+	bme680HeaterDuration = 150 * time.Millisecond
+)
+
+// How long to wait for a measurement, including heating the hot plate, before
+// giving up
+const bme680MeasureTimeout = time.Second
+
+// Corrections to the gas resistance for each of the sensor's 16 ranges
+var (
+	bme680RangeK1 = [16]float64{0, 0, 0, 0, 0, -1, 0, -0.8, 0, 0, -0.2, -0.5, 0, -1, 0, 0}
+	bme680RangeK2 = [16]float64{0, 0, 0, 0, 0.1, 0.7, 0, -0.8, -0.1, 0, 0, 0, 0, 0, 0, 0}
+)
+
+// Compensation parameters read from a BME680's memory
+type bme680Calibration struct {
+	t1                         float64
+	t2, t3                     float64
+	p1, p2, p3, p4, p5         float64
+	p6, p7, p8, p9, p10        float64
+	h1, h2, h3, h4, h5, h6, h7 float64
+	gh1, gh2, gh3              float64
+	heatRange, heatVal         float64
+	rangeSwErr                 float64
+}
+
+// BME680 reads a Bosch BME680 over I²C, which measures the resistance of a
+// heated metal oxide gas sensor alongside the same values as a BME280. Lower
+// resistances mean more volatile organic compounds in the air.
+type BME680 struct {
+	dev  i2c.Dev
+	opts bmxx80.Opts
+	cal  bme680Calibration
+
+	mu sync.Mutex
+	// Gas resistance measured by the last call to Sense, if it was valid
+	gas      physic.ElectricResistance
+	gasValid bool
+}
+
+func newBME680(bus i2c.Bus, address uint16, opts *bmxx80.Opts) (*BME680, error) {
+	// Open a handle to a BME680 on `bus` at `address`, using the oversampling
+	// settings in `opts`. The BME680's filter coefficients for the codes of
+	// `opts.Filter` are 1, 3, 7 and 15 rather than 2, 4, 8 and 16.
+
+	d := &BME680{dev: i2c.Dev{Bus: bus, Addr: address}, opts: *opts}
+
+	id, err := d.readReg(bme680ChipID, 1)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize a BME680 at I²C address %#02x (%v)", address, err)
+	}
+	if id[0] != 0x61 {
+		return nil, fmt.Errorf("could not initialize a BME680 at I²C address %#02x (unexpected chip id %#02x)", address, id[0])
+	}
+	if err := d.writeReg(bme680Reset, 0xB6); err != nil {
+		return nil, err
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := d.readCalibration(); err != nil {
+		return nil, fmt.Errorf("could not read the BME680's calibration (%v)", err)
+	}
+
+	// The heater settings don't change between measurements
+	if err := d.writeReg(bme680ResHeat0, d.heaterResistance(bme680HeaterTemp, 25)); err != nil {
+		return nil, err
+	}
+	if err := d.writeReg(bme680GasWait0, bme680HeaterWait(bme680HeaterDuration)); err != nil {
+		return nil, err
+	}
+	if err := d.writeReg(bme680CtrlGas1, 0x10); err != nil { // run_gas, heater set point 0
+		return nil, err
+	}
+	if err := d.writeReg(bme680Config, byte(opts.Filter)<<2); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *BME680) String() string {
+	return fmt.Sprintf("BME680{%s}", &d.dev)
+}
+
+func (d *BME680) readReg(reg byte, n int) ([]byte, error) {
+	data := make([]byte, n)
+	if err := d.dev.Tx([]byte{reg}, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (d *BME680) writeReg(reg, value byte) error {
+	return d.dev.Tx([]byte{reg, value}, nil)
+}
+
+func (d *BME680) readCalibration() error {
+	// Read the compensation parameters, laid out as in Bosch's BME680 driver
+
+	c1, err := d.readReg(bme680Coeff1, 25)
+	if err != nil {
+		return err
+	}
+	c2, err := d.readReg(bme680Coeff2, 16)
+	if err != nil {
+		return err
+	}
+	c := append(c1, c2...)
+	heatVal, err := d.readReg(bme680HeatVal, 1)
+	if err != nil {
+		return err
+	}
+	heatRange, err := d.readReg(bme680HeatRange, 1)
+	if err != nil {
+		return err
+	}
+	swErr, err := d.readReg(bme680RangeSwErr, 1)
+	if err != nil {
+		return err
+	}
+
+	u16 := func(lsb, msb int) float64 { return float64(uint16(c[msb])<<8 | uint16(c[lsb])) }
+	s16 := func(lsb, msb int) float64 { return float64(int16(uint16(c[msb])<<8 | uint16(c[lsb]))) }
+	s8 := func(i int) float64 { return float64(int8(c[i])) }
+
+	d.cal = bme680Calibration{
+		t1: u16(33, 34), t2: s16(1, 2), t3: s8(3),
+		p1: u16(5, 6), p2: s16(7, 8), p3: s8(9), p4: s16(11, 12), p5: s16(13, 14),
+		p6: s8(16), p7: s8(15), p8: s16(19, 20), p9: s16(21, 22), p10: float64(c[23]),
+		h1: float64(uint16(c[27])<<4 | uint16(c[26]&0x0F)),
+		h2: float64(uint16(c[25])<<4 | uint16(c[26]>>4)),
+		h3: s8(28), h4: s8(29), h5: s8(30), h6: float64(c[31]), h7: s8(32),
+		gh1: s8(37), gh2: s16(35, 36), gh3: s8(38),
+		heatRange:  float64((heatRange[0] & 0x30) >> 4),
+		heatVal:    float64(int8(heatVal[0])),
+		rangeSwErr: float64(int8(swErr[0]) >> 4),
+	}
+	return nil
+}
+
+func (d *BME680) heaterResistance(target, ambient float64) byte {
+	// The value of the res_heat register that heats the hot plate to `target`
+	// °C, when the air is at `ambient` °C
+
+	c := d.cal
+	var1 := c.gh1/16 + 49
+	var2 := c.gh2/32768*0.0005 + 0.00235
+	var3 := c.gh3 / 1024
+	var4 := var1 * (1 + var2*target)
+	var5 := var4 + var3*ambient
+	return byte(3.4 * (var5*(4/(4+c.heatRange))*(1/(1+c.heatVal*0.002)) - 25))
+}
+
+func bme680HeaterWait(duration time.Duration) byte {
+	// Encode `duration` for the gas_wait register, as a 6-bit number of
+	// milliseconds and a multiplier of 1, 4, 16 or 64
+
+	ms := duration.Milliseconds()
+	if ms >= 0xFC0 {
+		return 0xFF
+	}
+	factor := int64(0)
+	for ms > 0x3F {
+		ms /= 4
+		factor++
+	}
+	return byte(ms + factor*64)
+}
+
+func (d *BME680) Sense(env *physic.Env) error {
+	// Take a single forced-mode measurement, heating the gas sensor's hot
+	// plate for it. The gas resistance is available from `Gas` afterwards.
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gasValid = false
+
+	if err := d.writeReg(bme680CtrlHum, byte(d.opts.Humidity)); err != nil {
+		return err
+	}
+	// Writing ctrl_meas with mode 1 starts the measurement
+	if err := d.writeReg(bme680CtrlMeas, byte(d.opts.Temperature)<<5|byte(d.opts.Pressure)<<2|1); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(bme680MeasureTimeout)
+	var data []byte
+	for {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if data, err = d.readReg(bme680Status, 15); err != nil {
+			return err
+		}
+		if data[0]&0x80 != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the BME680's measurement")
+		}
+	}
+
+	adcPressure := float64(uint32(data[2])<<12 | uint32(data[3])<<4 | uint32(data[4])>>4)
+	adcTemp := float64(uint32(data[5])<<12 | uint32(data[6])<<4 | uint32(data[7])>>4)
+	adcHumidity := float64(uint16(data[8])<<8 | uint16(data[9]))
+	adcGas := float64(uint16(data[13])<<2 | uint16(data[14])>>6)
+	gasRange := data[14] & 0x0F
+
+	tFine, celsius := d.cal.temperature(adcTemp)
+	env.Temperature = physic.ZeroCelsius + physic.Temperature(celsius*float64(physic.Kelvin))
+	env.Pressure = physic.Pressure(d.cal.pressure(adcPressure, tFine) * float64(physic.Pascal))
+	env.Humidity = physic.RelativeHumidity(d.cal.humidity(adcHumidity, tFine) * float64(physic.PercentRH))
+
+	// The gas reading is only valid if the hot plate reached its temperature
+	if data[14]&0x20 != 0 && data[14]&0x10 != 0 {
+		d.gas = physic.ElectricResistance(d.cal.gasResistance(adcGas, gasRange) * float64(physic.Ohm))
+		d.gasValid = true
+	}
+	return nil
+}
+
+// Gas returns the gas resistance measured by the last call to Sense, and
+// whether it was valid
+func (d *BME680) Gas() (physic.ElectricResistance, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.gas, d.gasValid
+}
+
+func (d *BME680) Halt() error {
+	// The sensor sleeps between forced-mode measurements, so there's nothing
+	// to stop
+	return nil
+}
+
+func ohms(r physic.ElectricResistance) float64 {
+	return float64(r) / float64(physic.Ohm)
+}
+
+// gasSensor is implemented by sensors that also measure gas resistance
+type gasSensor interface {
+	Gas() (physic.ElectricResistance, bool)
+}
+
+func (c bme680Calibration) temperature(adc float64) (tFine, celsius float64) {
+	var1 := (adc/16384 - c.t1/1024) * c.t2
+	var2 := (adc/131072 - c.t1/8192) * (adc/131072 - c.t1/8192) * c.t3 * 16
+	tFine = var1 + var2
+	return tFine, tFine / 5120
+}
+
+func (c bme680Calibration) pressure(adc, tFine float64) float64 {
+	// The pressure in Pa
+
+	var1 := tFine/2 - 64000
+	var2 := var1 * var1 * (c.p6 / 131072)
+	var2 += var1 * c.p5 * 2
+	var2 = var2/4 + c.p4*65536
+	var1 = (c.p3*var1*var1/16384 + c.p2*var1) / 524288
+	var1 = (1 + var1/32768) * c.p1
+	if var1 == 0 {
+		return 0
+	}
+	p := (1048576 - adc - var2/4096) * 6250 / var1
+	var1 = c.p9 * p * p / 2147483648
+	var2 = p * (c.p8 / 32768)
+	var3 := (p / 256) * (p / 256) * (p / 256) * (c.p10 / 131072)
+	return p + (var1+var2+var3+c.p7*128)/16
+}
+
+func (c bme680Calibration) humidity(adc, tFine float64) float64 {
+	// The relative humidity in %
+
+	t := tFine / 5120
+	var1 := adc - (c.h1*16 + c.h3/2*t)
+	var2 := var1 * (c.h2 / 262144 * (1 + c.h4/16384*t + c.h5/1048576*t*t))
+	var3 := c.h6 / 16384
+	var4 := c.h7 / 2097152
+	return math.Min(math.Max(var2+(var3+var4*t)*var2*var2, 0), 100)
+}
+
+func (c bme680Calibration) gasResistance(adc float64, gasRange byte) float64 {
+	// The gas resistance in Ω
+
+	var1 := 1340 + 5*c.rangeSwErr
+	var2 := var1 * (1 + bme680RangeK1[gasRange]/100)
+	var3 := 1 + bme680RangeK2[gasRange]/100
+	return 1 / (var3 * 0.000000125 * float64(uint32(1)<<gasRange) * ((adc-512)/var2 + 1))
+}
@@ -0,0 +1,73 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBME680HeaterWait(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     byte
+	}{
+		{0, 0},
+		{63 * time.Millisecond, 63},
+		{64 * time.Millisecond, 64 + 16},
+		{150 * time.Millisecond, 64 + 37},
+		{time.Second, 128 + 62},
+		{4031 * time.Millisecond, 192 + 62},
+		{5 * time.Second, 0xFF},
+	}
+	for _, tt := range tests {
+		if got := bme680HeaterWait(tt.duration); got != tt.want {
+			t.Errorf("bme680HeaterWait(%v) = %#x, want %#x", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestGasIsWrittenWithReading(t *testing.T) {
+	tests := []struct {
+		name string
+		gas  bool
+		// Gas field of the point written, in Ω, or 0 for none
+		want float64
+	}{
+		{"BME280", false, 0},
+		{"BME680", true, 50000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			state := newRunState(config)
+			sensor := newMockSensor(nil, clock)
+			sensor.gas = tt.gas
+
+			// Gas is averaged along with the other values
+			var window accumulator
+			for i := 0; i < 3; i++ {
+				reading, ok, err := state.readSensor(sensor, "", true, nil, uint64(i+1), testBounds, 0)
+				if !ok || err != nil {
+					t.Fatalf("readSensor() = %v, %v", ok, err)
+				}
+				if (reading.Gas != nil) != tt.gas {
+					t.Fatalf("reading gas = %v, want it measured %v", reading.Gas, tt.gas)
+				}
+				window.add(reading)
+			}
+			average := window.average(mean, "end")
+
+			var got float64
+			for _, field := range newInfluxPoint(average, average.Time, "environment", config.Output).FieldList() {
+				if field.Key == "gas" {
+					got = field.Value.(float64)
+				}
+			}
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("gas = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
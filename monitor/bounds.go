@@ -0,0 +1,29 @@
+package monitor
+
+import (
+	"fmt"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// Bounds are the lowest and highest plausible value of each field. Readings
+// outside them are assumed to be corrupt.
+type Bounds struct {
+	Min physic.Env
+	Max physic.Env
+}
+
+func (b Bounds) check(reading Reading) error {
+	// Return an error describing the first field of `reading` that's out of
+	// bounds, if any. The humidity of readings without one isn't checked.
+
+	switch {
+	case reading.Temperature < b.Min.Temperature || reading.Temperature > b.Max.Temperature:
+		return fmt.Errorf("temperature %s is outside %s to %s", reading.Temperature, b.Min.Temperature, b.Max.Temperature)
+	case reading.Pressure < b.Min.Pressure || reading.Pressure > b.Max.Pressure:
+		return fmt.Errorf("pressure %s is outside %s to %s", reading.Pressure, b.Min.Pressure, b.Max.Pressure)
+	case reading.HasHumidity && (reading.Humidity < b.Min.Humidity || reading.Humidity > b.Max.Humidity):
+		return fmt.Errorf("humidity %s is outside %s to %s", reading.Humidity, b.Min.Humidity, b.Max.Humidity)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestBoundsCheck(t *testing.T) {
+	bounds := Bounds{
+		Min: physic.Env{Temperature: celsius(-40), Pressure: 300 * 100 * physic.Pascal, Humidity: 0},
+		Max: physic.Env{Temperature: celsius(85), Pressure: 1100 * 100 * physic.Pascal, Humidity: percentRH(100)},
+	}
+	valid := physic.Env{Temperature: celsius(20), Pressure: 1013 * 100 * physic.Pascal, Humidity: percentRH(50)}
+	tests := []struct {
+		name        string
+		change      func(env *physic.Env)
+		hasHumidity bool
+		wantErr     bool
+	}{
+		{"valid", func(env *physic.Env) {}, true, false},
+		{"at the minimums", func(env *physic.Env) { *env = bounds.Min }, true, false},
+		{"at the maximums", func(env *physic.Env) { *env = bounds.Max }, true, false},
+		{"too cold", func(env *physic.Env) { env.Temperature = celsius(-41) }, true, true},
+		{"too hot", func(env *physic.Env) { env.Temperature = celsius(86) }, true, true},
+		{"pressure too low", func(env *physic.Env) { env.Pressure = 299 * 100 * physic.Pascal }, true, true},
+		{"pressure too high", func(env *physic.Env) { env.Pressure = 1101 * 100 * physic.Pascal }, true, true},
+		{"humidity over 100%", func(env *physic.Env) { env.Humidity = percentRH(101) }, true, true},
+		{"humidity not measured", func(env *physic.Env) { env.Humidity = percentRH(101) }, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := Reading{Env: valid, HasHumidity: tt.hasHumidity}
+			tt.change(&reading.Env)
+			if err := bounds.check(reading); (err != nil) != tt.wantErr {
+				t.Errorf("check() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadSensorDropsOutOfBoundsReadings(t *testing.T) {
+	bounds := Bounds{Max: physic.Env{Temperature: celsius(85), Pressure: 1100 * 100 * physic.Pascal, Humidity: percentRH(100)}}
+	pressure := 1013 * 100 * physic.Pascal
+	replayed := []physic.Env{
+		{Temperature: celsius(20), Pressure: pressure, Humidity: percentRH(50)},
+		{Temperature: celsius(20), Pressure: pressure, Humidity: percentRH(150)},
+		{Temperature: celsius(500), Pressure: pressure, Humidity: percentRH(50)},
+		{Temperature: celsius(21), Pressure: pressure, Humidity: percentRH(60)},
+	}
+	wantOK := []bool{true, false, false, true}
+
+	config := testConfig()
+	clock := NewFakeClock(testTime)
+	config.Clock = clock
+	state := newRunState(config)
+	sensor := newMockSensor(replayed, clock)
+	for i, want := range wantOK {
+		_, ok, err := state.readSensor(sensor, "", true, nil, uint64(i+1), bounds, 0)
+		if err != nil {
+			t.Fatalf("read %d: readSensor() error = %v", i+1, err)
+		}
+		if ok != want {
+			t.Errorf("read %d: readSensor() ok = %v, want %v", i+1, ok, want)
+		}
+	}
+}
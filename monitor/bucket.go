@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// bucketAdmin is the part of the InfluxDB 2.x API used to create the bucket
+// on startup, so the decision of whether to create it doesn't depend on a
+// server
+type bucketAdmin interface {
+	// Whether the bucket called `name` exists
+	bucketExists(ctx context.Context, name string) (bool, error)
+	// Create the bucket called `name` in `org`, keeping points for
+	// `retention`, or forever if it's 0. Returns `errBucketExists` if another
+	// client created it first.
+	createBucket(ctx context.Context, org, name string, retention time.Duration) error
+}
+
+var errBucketExists = errors.New("bucket already exists")
+
+func ensureBucket(ctx context.Context, admin bucketAdmin, config InfluxConfig) error {
+	// Create `config.Bucket` with `config.Retention` unless it already exists.
+	// An existing bucket is left as it is, even if its retention differs.
+
+	exists, err := admin.bucketExists(ctx, config.Bucket)
+	if err != nil {
+		return fmt.Errorf("could not look up the InfluxDB bucket %s: %w", config.Bucket, err)
+	}
+	if exists {
+		slog.Debug("InfluxDB bucket exists", "bucket", config.Bucket)
+		return nil
+	}
+
+	err = admin.createBucket(ctx, config.Org, config.Bucket, config.Retention)
+	if errors.Is(err, errBucketExists) {
+		// Another monitor sharing the bucket created it in the meantime
+		slog.Debug("InfluxDB bucket was created by another client", "bucket", config.Bucket)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not create the InfluxDB bucket %s: %w", config.Bucket, err)
+	}
+	slog.Info("Created InfluxDB bucket", "bucket", config.Bucket, "org", config.Org, "retention", config.Retention)
+	return nil
+}
+
+func createInfluxBucket(client influxdb2.Client, config InfluxConfig) error {
+	// Make sure the bucket written to by `client` exists, if
+	// `config.CreateBucket` is set. Each request is bounded by
+	// `config.Timeout` through the client.
+
+	if !config.CreateBucket {
+		return nil
+	}
+	if err := ensureBucket(context.Background(), influxBucketAdmin{client}, config); err != nil {
+		client.Close()
+		return err
+	}
+	return nil
+}
+
+// influxBucketAdmin manages buckets through an InfluxDB client
+type influxBucketAdmin struct {
+	client influxdb2.Client
+}
+
+func (a influxBucketAdmin) bucketExists(ctx context.Context, name string) (bool, error) {
+	_, err := a.client.BucketsAPI().FindBucketByName(ctx, name)
+	if err == nil {
+		return true, nil
+	}
+	// The client reports a missing bucket with a plain error, and a failed
+	// request with an `http.Error`
+	var httpErr *http.Error
+	if errors.As(err, &httpErr) {
+		return false, err
+	}
+	return false, nil
+}
+
+func (a influxBucketAdmin) createBucket(ctx context.Context, org, name string, retention time.Duration) error {
+	organization, err := a.client.OrganizationsAPI().FindOrganizationByName(ctx, org)
+	if err != nil {
+		return fmt.Errorf("could not find the organization %q: %w", org, err)
+	}
+	rule := domain.RetentionRule{Type: domain.RetentionRuleTypeExpire, EverySeconds: int(retention / time.Second)}
+	_, err = a.client.BucketsAPI().CreateBucketWithName(ctx, organization, name, rule)
+	var httpErr *http.Error
+	if errors.As(err, &httpErr) && httpErr.Code == string(domain.ErrorCodeConflict) {
+		return errBucketExists
+	}
+	return err
+}
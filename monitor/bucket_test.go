@@ -0,0 +1,137 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// fakeBucketAdmin answers bucket lookups with `exists` and `lookupErr`, and
+// creations with `createErr`, recording the buckets it's asked to create
+type fakeBucketAdmin struct {
+	exists    bool
+	lookupErr error
+	createErr error
+	created   []string
+	retention time.Duration
+}
+
+func (a *fakeBucketAdmin) bucketExists(ctx context.Context, name string) (bool, error) {
+	return a.exists, a.lookupErr
+}
+
+func (a *fakeBucketAdmin) createBucket(ctx context.Context, org, name string, retention time.Duration) error {
+	a.created = append(a.created, org+"/"+name)
+	a.retention = retention
+	return a.createErr
+}
+
+func TestEnsureBucket(t *testing.T) {
+	errRequestFailed := errors.New("request failed")
+	tests := []struct {
+		name        string
+		admin       fakeBucketAdmin
+		wantCreated bool
+		wantErr     bool
+	}{
+		{"exists", fakeBucketAdmin{exists: true}, false, false},
+		{"missing", fakeBucketAdmin{}, true, false},
+		{"created by another client first", fakeBucketAdmin{createErr: errBucketExists}, true, false},
+		{"lookup fails", fakeBucketAdmin{lookupErr: errRequestFailed}, false, true},
+		{"creation fails", fakeBucketAdmin{createErr: errRequestFailed}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := tt.admin
+			config := InfluxConfig{Org: "home", Bucket: "environment", Retention: 30 * 24 * time.Hour}
+			err := ensureBucket(context.Background(), &admin, config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ensureBucket() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errRequestFailed) {
+				t.Errorf("ensureBucket() = %v, want it to wrap %v", err, errRequestFailed)
+			}
+			if got := len(admin.created) > 0; got != tt.wantCreated {
+				t.Errorf("created %v, want a bucket created %v", admin.created, tt.wantCreated)
+			}
+			if tt.wantCreated && (admin.created[0] != "home/environment" || admin.retention != config.Retention) {
+				t.Errorf("created %v keeping points for %v, want home/environment for %v", admin.created, admin.retention, config.Retention)
+			}
+		})
+	}
+}
+
+func TestInfluxBucketAdmin(t *testing.T) {
+	tests := []struct {
+		name string
+		// Buckets the server has, and the status and error code it answers
+		// creations with
+		buckets      []string
+		createStatus int
+		createCode   string
+		wantCreated  bool
+		wantErr      bool
+	}{
+		{"exists", []string{"environment"}, http.StatusCreated, "", false, false},
+		{"missing", nil, http.StatusCreated, "", true, false},
+		{"created by another client first", nil, http.StatusUnprocessableEntity, "conflict", true, false},
+		{"not allowed", nil, http.StatusForbidden, "forbidden", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/v2/buckets":
+					buckets := []map[string]any{}
+					for _, name := range tt.buckets {
+						if name == r.URL.Query().Get("name") {
+							buckets = append(buckets, map[string]any{"id": "b1", "name": name, "retentionRules": []any{}})
+						}
+					}
+					json.NewEncoder(w).Encode(map[string]any{"buckets": buckets})
+				case r.Method == http.MethodGet && r.URL.Path == "/api/v2/orgs":
+					json.NewEncoder(w).Encode(map[string]any{"orgs": []any{map[string]any{"id": "o1", "name": r.URL.Query().Get("org")}}})
+				case r.Method == http.MethodPost && r.URL.Path == "/api/v2/buckets":
+					var bucket map[string]any
+					json.NewDecoder(r.Body).Decode(&bucket)
+					created = append(created, bucket)
+					w.WriteHeader(tt.createStatus)
+					if tt.createCode != "" {
+						json.NewEncoder(w).Encode(map[string]any{"code": tt.createCode, "message": tt.createCode})
+						return
+					}
+					json.NewEncoder(w).Encode(bucket)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			client := influxdb2.NewClient(server.URL, "token")
+			config := InfluxConfig{Org: "home", Bucket: "environment", Retention: 24 * time.Hour}
+			err := ensureBucket(context.Background(), influxBucketAdmin{client}, config)
+			client.Close()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ensureBucket() = %v, want error %v", err, tt.wantErr)
+			}
+			if got := len(created) > 0; got != tt.wantCreated {
+				t.Fatalf("created %v, want a bucket created %v", created, tt.wantCreated)
+			}
+			if tt.wantCreated {
+				bucket := created[0]
+				rules, _ := bucket["retentionRules"].([]any)
+				if bucket["name"] != "environment" || bucket["orgID"] != "o1" || len(rules) != 1 || rules[0].(map[string]any)["everySeconds"] != 86400.0 {
+					t.Errorf("created %v, want environment in o1 keeping points for 86400s", bucket)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and schedules ticks and delays. Polling, averaging
+// over a length of time, retrying writes and the health checks all go
+// through it, so a `FakeClock` can drive them in tests without waiting.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// A Ticker sends the time on `C` every period, like `time.Ticker`
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// A Timer sends the time on `C` once its delay has passed, like `time.Timer`
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the system clock
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) Sleep(d time.Duration)            { time.Sleep(d) }
+
+type realTicker struct{ ticker *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.ticker.C }
+func (t realTicker) Reset(d time.Duration) { t.ticker.Reset(d) }
+func (t realTicker) Stop()                 { t.ticker.Stop() }
+
+type realTimer struct{ timer *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.timer.C }
+func (t realTimer) Stop() bool          { return t.timer.Stop() }
+
+// FakeClock is a `Clock` whose time only moves when `Advance` is called,
+// firing any tickers and timers that fall due on the way, for testing
+// time-based behaviour deterministically
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+	// Tickers and timers that haven't been stopped or, for timers, fired
+	waiters []*fakeWaiter
+	// Closed and replaced whenever a waiter is added, for `BlockUntil`
+	added chan struct{}
+}
+
+// A ticker or timer of a `FakeClock`
+type fakeWaiter struct {
+	clock *FakeClock
+	c     chan time.Time
+	// When it next fires
+	at time.Time
+	// Time between ticks, or 0 for a timer
+	period time.Duration
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, added: make(chan struct{})}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	return fakeTicker{c.add(d, d)}
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return fakeTimer{c.add(d, 0)}
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Like the channels of `time.Ticker`, a tick is dropped if the last one
+	// hasn't been received yet
+	w := &fakeWaiter{clock: c, c: make(chan time.Time, 1), at: c.now.Add(d), period: period}
+	if d <= 0 && period == 0 {
+		w.c <- c.now
+		return w
+	}
+	c.schedule(w)
+	return w
+}
+
+func (c *FakeClock) schedule(w *fakeWaiter) {
+	// Add `w` to the waiters. `c.mu` must be held.
+	c.waiters = append(c.waiters, w)
+	close(c.added)
+	c.added = make(chan struct{})
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	// Move the time forward by `d`, firing each ticker and timer that falls
+	// due in order, with the time it was due
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = end
+}
+
+func (c *FakeClock) BlockUntil(n int) {
+	// Wait until at least `n` tickers and timers are waiting on the clock,
+	// so a test can advance it once the code under test is ready
+
+	for {
+		c.mu.Lock()
+		waiting, added := len(c.waiters), c.added
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-added
+	}
+}
+
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.clock.remove(t.fakeWaiter) }
+
+func (t fakeTicker) Reset(d time.Duration) {
+	// Tick every `d` from now. Stopped tickers start ticking again, as with
+	// `time.Ticker`.
+
+	c := t.clock
+	c.remove(t.fakeWaiter)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.at, t.period = c.now.Add(d), d
+	c.schedule(t.fakeWaiter)
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) Stop() bool {
+	// Whether the timer was stopped before it fired
+	return t.clock.remove(t.fakeWaiter)
+}
@@ -0,0 +1,137 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		advance time.Duration
+		fires   bool
+	}{
+		{"before the delay", time.Second, 999 * time.Millisecond, false},
+		{"at the delay", time.Second, time.Second, true},
+		{"after the delay", time.Second, time.Hour, true},
+		{"no delay", 0, 0, true},
+		{"negative delay", -time.Second, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			timer := clock.NewTimer(tt.delay)
+			clock.Advance(tt.advance)
+
+			at, ok := fired(timer.C())
+			if ok != tt.fires {
+				t.Fatalf("fired = %v, want %v", ok, tt.fires)
+			}
+			if want := testTime.Add(max(tt.delay, 0)); ok && !at.Equal(want) {
+				t.Errorf("fired with %v, want %v", at, want)
+			}
+			if got, want := clock.Now(), testTime.Add(tt.advance); !got.Equal(want) {
+				t.Errorf("Now() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFakeClockTimerStop(t *testing.T) {
+	clock := NewFakeClock(testTime)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("Stop() before firing = false, want true")
+	}
+	clock.Advance(time.Second)
+	if _, ok := fired(stopped.C()); ok {
+		t.Error("stopped timer fired")
+	}
+
+	expired := clock.NewTimer(time.Second)
+	clock.Advance(time.Second)
+	if expired.Stop() {
+		t.Error("Stop() after firing = true, want false")
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	tests := []struct {
+		name     string
+		advances []time.Duration
+		// Time of the tick received after each advance, or zero for none
+		ticks []time.Duration
+	}{
+		{"every period", []time.Duration{time.Second, time.Second}, []time.Duration{time.Second, 2 * time.Second}},
+		{"not before the period", []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, []time.Duration{0, time.Second}},
+		// Like `time.Ticker`, ticks are dropped while the last is unreceived
+		{"missed ticks are dropped", []time.Duration{3 * time.Second, time.Second}, []time.Duration{time.Second, 4 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			ticker := clock.NewTicker(time.Second)
+			defer ticker.Stop()
+			for i, advance := range tt.advances {
+				clock.Advance(advance)
+				at, ok := fired(ticker.C())
+				if want := tt.ticks[i]; ok != (want != 0) || ok && !at.Equal(testTime.Add(want)) {
+					t.Errorf("advance %d: tick = %v, %v, want %v", i+1, at, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeClockTickerResetAndStop(t *testing.T) {
+	clock := NewFakeClock(testTime)
+	ticker := clock.NewTicker(time.Second)
+
+	ticker.Reset(5 * time.Second)
+	clock.Advance(4 * time.Second)
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("ticker reset to 5s ticked after 4s")
+	}
+	clock.Advance(time.Second)
+	if at, ok := fired(ticker.C()); !ok || !at.Equal(testTime.Add(5*time.Second)) {
+		t.Errorf("tick = %v, %v, want %v", at, ok, testTime.Add(5*time.Second))
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("stopped ticker ticked")
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	clock := NewFakeClock(testTime)
+	woke := make(chan time.Time)
+	go func() {
+		clock.Sleep(time.Minute)
+		woke <- clock.Now()
+	}()
+
+	// Wait for the sleeper before advancing, so its timer isn't created
+	// after the time has already moved on
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	select {
+	case at := <-woke:
+		if want := testTime.Add(time.Minute); !at.Equal(want) {
+			t.Errorf("woke at %v, want %v", at, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep() didn't return after the clock was advanced")
+	}
+}
@@ -0,0 +1,181 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/devices/v3/bmxx80"
+)
+
+type SensorConfig struct {
+	Address uint16
+	// Label written with each of the sensor's readings
+	Label string
+	// Name of the I²C bus the sensor is on, or "" for `Config.BusName`.
+	// Sensors on different buses are read concurrently.
+	Bus string
+	// Time between reads of the sensor, and the number of readings in each
+	// of its averaging windows, or 0 for `Config.ReadInterval` and
+	// `Config.WindowSize`
+	ReadInterval time.Duration
+	WindowSize   int
+}
+
+// Config describes the sensors to read, how their readings are averaged,
+// and where the averages are written
+type Config struct {
+	// How readings are averaged: "window" to combine each `WindowSize`
+	// readings (or the readings in each `WindowDuration`, if set) using
+	// `Aggregation`, which is "mean" or "median", "ema" for an exponential
+	// moving average with a smoothing factor of `EMAAlpha`, or "none" to
+	// write every raw reading
+	AverageMode string
+	Aggregation string
+	// Number of standard deviations from the mean of the window so far beyond
+	// which a reading is dropped as an outlier, or 0 to keep them all
+	OutlierSigma   float64
+	WindowSize     int
+	WindowDuration time.Duration
+	// "tumbling" for each reading to be in one window, or "sliding" for an
+	// average of the last `WindowSize` readings after every reading
+	WindowMode string
+	EMAAlpha   float64
+	// How window averages are timestamped: "end" for the time of the last
+	// reading in the window, or "mid" for the midpoint of the first and last
+	TimestampMode string
+	ReadInterval  time.Duration
+	// Maximum random delay added to each read, to spread the load of many
+	// monitors with the same `ReadInterval`
+	Jitter time.Duration
+	// Read each sensor once, write the readings without averaging, and exit
+	Once bool
+	// Exit if the reading taken on startup can't be read or written, rather
+	// than carrying on polling
+	FailFast bool
+	// Number of samples read from each sensor and discarded before averaging
+	WarmupSamples int
+	// Number of readings to average and write, across all the sensors,
+	// before shutting down, or 0 to carry on until `ctx` is cancelled. The
+	// readings discarded while warming up and those taken by the self-test
+	// aren't counted.
+	MaxSamples int
+	// Number of readings and averages each stage of the pipeline can queue
+	// while the next stage is busy, such as when writing to a slow sink
+	ChannelBuffer int
+	// Which reading to drop when a sensor's queue is full: "oldest" to keep
+	// the latest readings, or "newest" to keep those already queued
+	DropPolicy string
+	// Time allowed on shutdown for the readings still queued to be averaged
+	// and written, or 0 to wait for as long as it takes
+	ShutdownTimeout time.Duration
+	// Number of consecutive failed reads of a sensor before giving up, or 0 to
+	// keep trying
+	MaxReadFailures int
+	// How long to wait for a sensor to respond before abandoning the read as
+	// failed, or 0 to wait indefinitely
+	ReadTimeout time.Duration
+	// Plausible range of raw readings. Readings outside it are dropped.
+	Bounds Bounds
+	// Number of identical readings in a row after which a sensor is reported
+	// as stuck, or 0 to never report it
+	StuckReads int
+	// Number of times to retry loading the drivers if it fails, and the delay
+	// before the first retry. The delay doubles with each retry.
+	InitRetries    int
+	InitRetryDelay time.Duration
+	// How long to keep retrying loading the drivers, opening the bus and
+	// opening the sensors together until every sensor opens, or 0 to try once
+	StartupWait time.Duration
+	// How the sensors are connected: "i2c", or "spi" for a single sensor on
+	// the SPI port called `BusName`
+	Interface  string
+	BusName    string
+	I2CAddress uint16
+	// Model of the sensors: "bme280" for those periph's bmxx80 driver
+	// supports, or "bme680", which also measures gas resistance
+	SensorModel string
+	// Oversampling and IIR filter settings of the sensors
+	SensorOpts bmxx80.Opts
+	// Name of an ADC pin to read the supply voltage from with each reading,
+	// or empty to not measure it
+	VoltagePin string
+	// Sensors to read, each with a label. When empty, the single sensor at
+	// `I2CAddress` is read and its readings aren't labelled.
+	Sensors []SensorConfig
+	// Use mock sensors instead of real hardware. They replay `MockReadings`,
+	// or generate sine waves if it's empty.
+	Mock         bool
+	MockReadings []physic.Env
+	// Names of the sinks to write averaged readings to
+	Sinks []string
+	// InfluxDB measurement to also write every raw reading to, or "" to only
+	// write averages
+	RawMeasurement string
+	// How the stdout sink prints readings: "json" or "table"
+	Format string
+	// Read and average as usual, but log averages instead of writing them
+	// to `Sinks`
+	DryRun      bool
+	MetricsAddr string
+	// Address to serve the runtime profiles from net/http/pprof on, if any
+	PprofAddr string
+	// OTLP/HTTP endpoint to export traces and metrics to, if any
+	OTelEndpoint string
+	// Address to serve the health endpoints and latest readings on, and how
+	// recently a sensor must have been read to be healthy
+	HTTPAddr     string
+	HealthMaxAge time.Duration
+	// Number of recent averages kept in memory for /history and Grafana
+	HistorySize int
+	Output      OutputConfig
+	Write       WriteConfig
+	// Time allowed for each write to each sink
+	WriteTimeouts WriteTimeouts
+	Influx        InfluxConfig
+	CSV           CSVConfig
+	MQTT          MQTTConfig
+	Postgres      PostgresConfig
+	// File for the lineprotocol sink
+	LineProtocol LineProtocolConfig
+	Dedup        DedupConfig
+	// Time without a new average for a sensor after which its last average
+	// is written again with the current time, or 0 to only write new ones
+	HeartbeatInterval time.Duration
+	// Number of distinct combinations of tags written, beyond which points
+	// that would start another series are dropped, or 0 for no limit
+	MaxSeries int
+	// Thresholds to alert on, if any, and where to send the alerts
+	Alerts AlertConfig
+	// When to report the pressure as falling quickly
+	PressureTrend PressureTrendConfig
+	// When to report a risk of mold from sustained high humidity
+	MoldRisk MoldRiskConfig
+	// Recorded readings to replay in place of the sensors, if any
+	Replay ReplayConfig
+	// Clock used for reading, averaging and writing on time, or nil for the
+	// system clock. Tests can pass a `FakeClock` to control time.
+	Clock Clock
+	// New values of the settings that can change while the monitor is
+	// running, applied as they're received, such as from re-reading the
+	// config file on SIGHUP. Nil if they never change.
+	Reloads <-chan LiveSettings
+}
+
+// Settings that can be changed while the monitor is running. A new window
+// size applies to the window being filled.
+type LiveSettings struct {
+	ReadInterval time.Duration
+	WindowSize   int
+}
+
+func ValidateAddress(address uint) error {
+	// Only 7-bit addresses outside the reserved ranges are valid
+	if address < 0x03 || address > 0x77 {
+		return fmt.Errorf("invalid I²C address %#02x: must be between 0x03 and 0x77", address)
+	}
+	return nil
+}
+
+// Values accepted by the `-interface` flag
+var BusInterfaces = []string{"i2c", "spi"}
@@ -0,0 +1,359 @@
+package monitor
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CSVConfig struct {
+	Path string
+	// Size in bytes at which the file is rotated, or 0 to never rotate
+	MaxBytes int64
+	// How often buffered rows are flushed and synced to disk
+	SyncInterval time.Duration
+	// Compress the file with gzip, adding .gz to `Path` if it's missing
+	Compress bool
+}
+
+// CSVSink appends readings to a CSV file, rotating it once it grows past a
+// size limit
+type CSVSink struct {
+	config CSVConfig
+	output OutputConfig
+	clock  Clock
+
+	mu sync.Mutex
+	// The open file, or nil if it couldn't be opened again after rotating
+	file *os.File
+	// Compresses rows before they're written to `file`, or nil if the file
+	// isn't compressed
+	gzip   *gzip.Writer
+	buffer *bufio.Writer
+	writer *csv.Writer
+	// Size of the file's rows, before compression for compressed files
+	size int64
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func csvHeader(output OutputConfig) []string {
+	header := []string{"time", "temperature_" + string(output.TemperatureUnit), "pressure_" + string(output.PressureUnit), "humidity_pct"}
+	if output.Fields.Voltage {
+		header = append(header, "voltage_v")
+	}
+	if output.Fields.Gas {
+		header = append(header, "gas_ohms")
+	}
+	if output.Fields.Seq {
+		header = append(header, "seq")
+	}
+	header = append(header, tagNames...)
+	header = append(header, statsFieldNames...)
+	return append(header, derivedFieldNames...)
+}
+
+func newCSVSink(config CSVConfig, output OutputConfig, clock Clock) (*CSVSink, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("the csv sink needs a file, set with -csv_path")
+	}
+	if config.SyncInterval <= 0 {
+		return nil, fmt.Errorf("invalid CSV sync interval %s: must be positive", config.SyncInterval)
+	}
+	if config.Compress && !strings.HasSuffix(config.Path, ".gz") {
+		config.Path += ".gz"
+	}
+
+	s := &CSVSink{
+		config:  config,
+		output:  output,
+		clock:   clock,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	go s.syncPeriodically()
+	return s, nil
+}
+
+// countingWriter passes writes through to `w`, adding the number of bytes
+// written to `count`. For compressed files, this is the size before
+// compression.
+type countingWriter struct {
+	w     *bufio.Writer
+	count *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.count += int64(n)
+	return n, err
+}
+
+func (s *CSVSink) open() error {
+	// Open the file at `s.config.Path` for appending, writing the header row
+	// if the file is new. A compressed file that already exists gets a new
+	// gzip stream appended to it, which is read as a continuation of the
+	// file.
+
+	file, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// Rotation goes by the size of the rows, so for a compressed file, that's
+	// the size once it's decompressed
+	size := info.Size()
+	if s.config.Compress && size > 0 {
+		if size, err = uncompressedSize(s.config.Path); err != nil {
+			file.Close()
+			return fmt.Errorf("could not read the compressed file %s: %w", s.config.Path, err)
+		}
+	}
+
+	s.file = file
+	s.size = size
+	var w io.Writer = file
+	s.gzip = nil
+	if s.config.Compress {
+		s.gzip = gzip.NewWriter(file)
+		w = s.gzip
+	}
+	s.buffer = bufio.NewWriter(w)
+	s.writer = csv.NewWriter(countingWriter{s.buffer, &s.size})
+
+	if s.size == 0 {
+		return s.writeRow(csvHeader(s.output))
+	}
+	return nil
+}
+
+func uncompressedSize(path string) (int64, error) {
+	// The size of the gzip-compressed file at `path` once decompressed. A
+	// stream cut short by a crash is counted up to where it ends.
+
+	reader, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	decompressed, err := gzip.NewReader(bufio.NewReader(reader))
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(io.Discard, decompressed)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	return size, err
+}
+
+func (s *CSVSink) writeRow(row []string) error {
+	s.writer.Write(row)
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *CSVSink) sync() error {
+	// Flush buffered rows and make sure they reach the disk. Compressed rows
+	// are flushed without ending the gzip stream, so they can be read back
+	// if the monitor stops without closing the file.
+
+	if s.file == nil {
+		return nil
+	}
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+	if s.gzip != nil {
+		if err := s.gzip.Flush(); err != nil {
+			return err
+		}
+	}
+	return s.file.Sync()
+}
+
+func (s *CSVSink) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.sync()
+	if s.gzip != nil {
+		// Finish the gzip stream, so the file is complete
+		if gzipErr := s.gzip.Close(); err == nil {
+			err = gzipErr
+		}
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+func (s *CSVSink) rotate(t time.Time) error {
+	// Rename the current file with a timestamp suffix, then close it and start
+	// a new one, e.g. readings.csv becomes readings-20210605T140300.123.csv,
+	// and readings.csv.gz becomes readings-20210605T140300.123.csv.gz. If it
+	// can't be renamed, it's left open and written to as before.
+
+	rotated := s.rotatedPath(t)
+	if err := os.Rename(s.config.Path, rotated); err != nil {
+		return err
+	}
+	slog.Info("Rotated CSV file", "path", s.config.Path, "rotated", rotated)
+
+	// Rows still buffered go to the renamed file as it's closed
+	if err := s.close(); err != nil {
+		slog.Error("Failed to close the rotated CSV file", "path", rotated, "error", err)
+	}
+	return s.open()
+}
+
+func (s *CSVSink) rotatedPath(t time.Time) string {
+	// A name for the file rotated at `t` that isn't taken yet. Files rotated
+	// within the same millisecond are numbered.
+
+	path, compressed := s.config.Path, ""
+	if s.config.Compress {
+		path, compressed = strings.TrimSuffix(path, ".gz"), ".gz"
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + t.Format("20060102T150405.000")
+	rotated := base + ext + compressed
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(rotated); errors.Is(err, fs.ErrNotExist) {
+			return rotated
+		}
+		rotated = fmt.Sprintf("%s-%d%s%s", base, i, ext, compressed)
+	}
+}
+
+func (s *CSVSink) syncPeriodically() {
+	defer close(s.stopped)
+
+	ticker := s.clock.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			s.mu.Lock()
+			if err := s.sync(); err != nil {
+				slog.Error("Failed to sync CSV file", "path", s.config.Path, "error", err)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *CSVSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		// The file couldn't be opened again after the last rotation
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.config.MaxBytes > 0 && s.size >= s.config.MaxBytes {
+		if err := s.rotate(t); err != nil {
+			return err
+		}
+	}
+
+	// Values that aren't written, and the humidity of sensors without one,
+	// are left empty
+	temp, pressure, humidity := convertEnv(reading.Env, s.output)
+	row := []string{t.Format(time.RFC3339), "", "", ""}
+	if s.output.Fields.Temperature {
+		row[1] = strconv.FormatFloat(temp, 'f', -1, 64)
+	}
+	if s.output.Fields.Pressure {
+		row[2] = strconv.FormatFloat(pressure, 'f', -1, 64)
+	}
+	if reading.HasHumidity {
+		row[3] = strconv.FormatFloat(humidity, 'f', -1, 64)
+	}
+	// The voltage and gas resistance only have columns when they're measured,
+	// and are left empty when they couldn't be read
+	if s.output.Fields.Voltage {
+		voltage := ""
+		if reading.Voltage != nil {
+			voltage = strconv.FormatFloat(volts(*reading.Voltage), 'f', -1, 64)
+		}
+		row = append(row, voltage)
+	}
+	if s.output.Fields.Gas {
+		gas := ""
+		if reading.Gas != nil {
+			gas = strconv.FormatFloat(ohms(*reading.Gas), 'f', -1, 64)
+		}
+		row = append(row, gas)
+	}
+	if s.output.Fields.Seq {
+		row = append(row, strconv.FormatUint(reading.Seq, 10))
+	}
+
+	tags := readingTags(reading, s.output)
+	for _, name := range tagNames {
+		row = append(row, tags[name])
+	}
+
+	// Statistics are left empty for moving averages
+	stats := map[string]float64{}
+	if reading.Stats != nil {
+		stats = convertStats(reading.Stats, reading.HasHumidity, s.output)
+	}
+	row = appendFields(row, statsFieldNames, stats)
+	row = appendFields(row, derivedFieldNames, derivedFields(reading, s.output))
+	return s.writeRow(row)
+}
+
+func appendFields(row []string, names []string, fields map[string]float64) []string {
+	// Append the value of each of `names` from `fields` to `row`, leaving an
+	// empty column for any that are missing
+
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	return row
+}
+
+func (s *CSVSink) Close() error {
+	close(s.stop)
+	<-s.stopped
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
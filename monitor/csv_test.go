@@ -0,0 +1,291 @@
+package monitor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCSVSink(t *testing.T, config CSVConfig) *CSVSink {
+	t.Helper()
+	if config.SyncInterval == 0 {
+		config.SyncInterval = time.Second
+	}
+	sink, err := newCSVSink(config, testConfig().Output, NewFakeClock(testTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func csvFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCSVSinkHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the file exists beforehand, and what's in it
+		exists   bool
+		existing string
+		// Rows expected after writing one reading, including any header
+		rows       int
+		wantHeader bool
+	}{
+		{"new file", false, "", 2, true},
+		{"empty file", true, "", 2, true},
+		{"existing file", true, "time,temperature_c\n2024-01-01T11:00:00Z,19\n", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readings.csv")
+			if tt.exists {
+				os.WriteFile(path, []byte(tt.existing), 0644)
+			}
+			sink := newTestCSVSink(t, CSVConfig{Path: path})
+			if err := sink.Write(context.Background(), testReading(20, 0), testTime); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			rows := readCSV(t, path)
+			if len(rows) != tt.rows {
+				t.Fatalf("file has %d rows, want %d: %v", len(rows), tt.rows, rows)
+			}
+			if got := strings.Join(rows[0], ",") == strings.Join(csvHeader(testConfig().Output), ","); got != tt.wantHeader {
+				t.Errorf("first row is the header: %v, want %v", got, tt.wantHeader)
+			}
+			if last := rows[len(rows)-1]; last[0] != testTime.Format(time.RFC3339) || last[1] != "20" {
+				t.Errorf("last row = %v, want the reading", last)
+			}
+		})
+	}
+}
+
+func TestCSVSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "readings.csv")
+	// Just big enough for the header and one row
+	header := strings.Join(csvHeader(testConfig().Output), ",") + "\n"
+	sink := newTestCSVSink(t, CSVConfig{Path: path, MaxBytes: int64(len(header)) + 1})
+
+	// Three rows at the same time: the file is rotated before the second and
+	// third, within the same millisecond
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(context.Background(), testReading(float64(20+i), 0), testTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"readings-20240101T120000.000-1.csv", "readings-20240101T120000.000.csv", "readings.csv"}
+	if got := csvFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	for i, name := range []string{"readings-20240101T120000.000.csv", "readings-20240101T120000.000-1.csv", "readings.csv"} {
+		rows := readCSV(t, filepath.Join(dir, name))
+		if len(rows) != 2 || rows[0][0] != "time" || rows[1][1] != []string{"20", "21", "22"}[i] {
+			t.Errorf("%s = %v, want the header and reading %d", name, rows, i+1)
+		}
+	}
+}
+
+func TestCSVSinkFailedRotation(t *testing.T) {
+	// A file that can't be renamed is kept open, and closed once
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "readings.csv")
+	sink := newTestCSVSink(t, CSVConfig{Path: path, MaxBytes: 1})
+	if err := sink.Write(context.Background(), testReading(20, 0), testTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming fails once the file has gone
+	os.Remove(path)
+	if err := sink.Write(context.Background(), testReading(21, 0), testTime); err == nil {
+		t.Error("Write() = nil, want the rename error")
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("Close() = %v, want nil", err)
+	}
+}
+
+func TestCSVSinkSync(t *testing.T) {
+	// Buffered rows reach the disk each sync interval
+
+	path := filepath.Join(t.TempDir(), "readings.csv")
+	clock := NewFakeClock(testTime)
+	sink, err := newCSVSink(CSVConfig{Path: path, SyncInterval: 5 * time.Second}, testConfig().Output, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.Write(context.Background(), testReading(20, 0), testTime)
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Fatalf("file has %d bytes before syncing, want 0", info.Size())
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, _ := os.Stat(path); info.Size() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rows weren't synced after the interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func readGzipCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(decompressed)
+	reader.FieldsPerRecord = -1
+	var rows [][]string
+	for {
+		row, err := reader.Read()
+		if err != nil {
+			// A stream that wasn't finished ends early, after its last flush
+			if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatal(err)
+			}
+			return rows
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestCSVSinkCompressed(t *testing.T) {
+	tests := []struct {
+		name string
+		// Readings written by each run of the monitor, appending to the file
+		runs []int
+		// Whether the last run stops without closing the file, after a sync
+		crash bool
+	}{
+		{"one run", []int{3}, false},
+		{"appended after a restart", []int{2, 2}, false},
+		{"crash after syncing", []int{2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := CSVConfig{Path: filepath.Join(dir, "readings.csv"), Compress: true, SyncInterval: time.Second}
+			var want []string
+			for run, readings := range tt.runs {
+				sink := newTestCSVSink(t, config)
+				for i := 0; i < readings; i++ {
+					temp := float64(20 + len(want))
+					if err := sink.Write(context.Background(), testReading(temp, 0), testTime); err != nil {
+						t.Fatal(err)
+					}
+					want = append(want, strconv.FormatFloat(temp, 'f', -1, 64))
+				}
+				if tt.crash && run == len(tt.runs)-1 {
+					sink.mu.Lock()
+					sink.sync()
+					sink.mu.Unlock()
+					continue
+				}
+				if err := sink.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := csvFiles(t, dir); len(got) != 1 || got[0] != "readings.csv.gz" {
+				t.Fatalf("files = %v, want readings.csv.gz", got)
+			}
+			rows := readGzipCSV(t, filepath.Join(dir, "readings.csv.gz"))
+			if len(rows) != len(want)+1 || rows[0][0] != "time" {
+				t.Fatalf("file = %v, want the header and %d readings", rows, len(want))
+			}
+			for i, temp := range want {
+				if rows[i+1][1] != temp {
+					t.Errorf("row %d = %v, want %s°C", i+1, rows[i+1], temp)
+				}
+			}
+		})
+	}
+}
+
+func TestCSVSinkCompressedSize(t *testing.T) {
+	// The size rotation goes by is the size before compression, whether the
+	// rows were written by this run or one before it
+
+	dir := t.TempDir()
+	config := CSVConfig{Path: filepath.Join(dir, "readings.csv"), Compress: true, SyncInterval: time.Second}
+	sink := newTestCSVSink(t, config)
+	for i := 0; i < 3; i++ {
+		sink.Write(context.Background(), testReading(20, 0), testTime)
+	}
+	written := sink.size
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows strings.Builder
+	w := csv.NewWriter(&rows)
+	for _, row := range readGzipCSV(t, config.Path+".gz") {
+		w.Write(row)
+	}
+	w.Flush()
+	if written != int64(rows.Len()) {
+		t.Errorf("size after writing = %d, want the %d bytes of rows", written, rows.Len())
+	}
+
+	reopened := newTestCSVSink(t, config)
+	defer reopened.Close()
+	if reopened.size != written {
+		t.Errorf("size after reopening = %d, want %d", reopened.size, written)
+	}
+}
@@ -0,0 +1,89 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+)
+
+type DedupConfig struct {
+	Enabled bool
+	// Largest change in a value, in the units it's written in, that still
+	// counts as unchanged
+	Epsilon float64
+	// Longest time between written points of a sensor, after which a point is
+	// written even if it's unchanged
+	MaxGap time.Duration
+}
+
+// DedupSink passes readings on to another sink, skipping those whose values
+// haven't changed since the last one written for the same sensor, so stable
+// conditions don't fill the database with identical points
+type DedupSink struct {
+	sink   Sink
+	config DedupConfig
+	output OutputConfig
+	// Values and time of the last point written for each sensor label
+	last map[string]writtenPoint
+}
+
+type writtenPoint struct {
+	values []float64
+	t      time.Time
+}
+
+func newDedupSink(sink Sink, config DedupConfig, output OutputConfig) *DedupSink {
+	return &DedupSink{sink: sink, config: config, output: output, last: map[string]writtenPoint{}}
+}
+
+func (s *DedupSink) values(reading Reading) []float64 {
+	// The values of `reading` that are written, in the units they're written
+	// in
+
+	temp, pressure, humidity := convertEnv(reading.Env, s.output)
+	values := []float64{}
+	if s.output.Fields.Temperature {
+		values = append(values, temp)
+	}
+	if s.output.Fields.Pressure {
+		values = append(values, pressure)
+	}
+	if reading.HasHumidity {
+		values = append(values, humidity)
+	}
+	return values
+}
+
+func (s *DedupSink) unchanged(values []float64, t time.Time, last writtenPoint) bool {
+	// Whether `values` at time `t` are within the epsilon of the `last` point
+	// written, and recently enough after it that they can be skipped
+
+	if t.Sub(last.t) >= s.config.MaxGap || len(values) != len(last.values) {
+		return false
+	}
+	for i, value := range values {
+		if math.Abs(value-last.values[i]) > s.config.Epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *DedupSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	values := s.values(reading)
+	if last, ok := s.last[reading.Label]; ok && s.unchanged(values, t, last) {
+		slog.Debug("Skipping unchanged point", "reading", reading, "last_written", last.t)
+		return nil
+	}
+
+	if err := s.sink.Write(ctx, reading, t); err != nil {
+		return err
+	}
+	s.last[reading.Label] = writtenPoint{values: values, t: t}
+	return nil
+}
+
+func (s *DedupSink) Close() error {
+	return s.sink.Close()
+}
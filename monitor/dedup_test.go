@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDedupSink(t *testing.T) {
+	type write struct {
+		temp float64
+		at   time.Duration
+		// Label of the sensor, or "" for the default one
+		label   string
+		written bool
+	}
+	tests := []struct {
+		name   string
+		writes []write
+	}{
+		{"first is written", []write{{20, 0, "", true}}},
+		{"unchanged is skipped", []write{{20, 0, "", true}, {20, time.Minute, "", false}}},
+		{"within epsilon is skipped", []write{{20, 0, "", true}, {20.09, time.Minute, "", false}, {19.91, 2 * time.Minute, "", false}}},
+		{"just over epsilon is written", []write{{20, 0, "", true}, {20.11, time.Minute, "", true}}},
+		{"compared with the last written", []write{{20, 0, "", true}, {20.08, time.Minute, "", false}, {20.16, 2 * time.Minute, "", true}}},
+		{"just before the max gap is skipped", []write{{20, 0, "", true}, {20, 10*time.Minute - time.Second, "", false}}},
+		{"written at the max gap", []write{{20, 0, "", true}, {20, time.Minute, "", false}, {20, 10 * time.Minute, "", true}, {20, 11 * time.Minute, "", false}}},
+		{"sensors are compared separately", []write{{20, 0, "a", true}, {20, 0, "b", true}, {20, time.Minute, "a", false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recording := &recordingSink{}
+			config := DedupConfig{Enabled: true, Epsilon: 0.1, MaxGap: 10 * time.Minute}
+			sink := newDedupSink(recording, config, testConfig().Output)
+			for i, w := range tt.writes {
+				before := recording.count()
+				reading := testReading(w.temp, w.at)
+				reading.Label = w.label
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatal(err)
+				}
+				if written := recording.count() > before; written != w.written {
+					t.Errorf("write %d: written = %v, want %v", i+1, written, w.written)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupSinkRetriesFailedWrites(t *testing.T) {
+	// A point that failed to be written isn't the last written, so the same
+	// values are written when they're retried
+	recording := &recordingSink{err: errWriteFailed}
+	sink := newDedupSink(recording, DedupConfig{Enabled: true, Epsilon: 0.1, MaxGap: time.Hour}, testConfig().Output)
+	reading := testReading(20, 0)
+	if err := sink.Write(context.Background(), reading, reading.Time); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Write() = %v, want %v", err, errWriteFailed)
+	}
+	recording.setErr(nil)
+	if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+		t.Fatal(err)
+	}
+	if got := recording.count(); got != 1 {
+		t.Errorf("wrote %d points, want 1", got)
+	}
+}
@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"math"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// Names of the fields written by `derivedFields`, in the order they're written
+// to CSV files
+var derivedFieldNames = []string{
+	"dew_point", "heat_index", "absolute_humidity", "sea_level_pressure",
+	"temp_rate", "pressure_rate", "humidity_rate",
+}
+
+func derivedFields(reading Reading, output OutputConfig) map[string]float64 {
+	// Values calculated from `reading`, such as the dew point. Values that
+	// need the humidity are omitted for readings without one, values that
+	// need a value that isn't written are omitted, and rates of change are
+	// omitted for readings without a previous average.
+
+	fields := map[string]float64{}
+	temp := output.Fields.Temperature
+	if temp && reading.HasHumidity && reading.Humidity > 0 {
+		fields["dew_point"] = convertCelsius(dewPoint(reading.Temperature, reading.Humidity), output.TemperatureUnit)
+	}
+	if temp && reading.HasHumidity {
+		fields["heat_index"] = convertCelsius(heatIndex(reading.Temperature, reading.Humidity), output.TemperatureUnit)
+	}
+	if temp && reading.HasHumidity {
+		fields["absolute_humidity"] = absoluteHumidity(reading.Temperature, reading.Humidity)
+	}
+	if temp && output.Fields.Pressure && output.Altitude != 0 {
+		pascals := seaLevelPressure(reading.Pressure, output.Altitude, reading.Temperature)
+		fields["sea_level_pressure"] = convertPressure(physic.Pressure(pascals*float64(physic.Pascal)), output.PressureUnit)
+	}
+	if reading.Rate != nil {
+		for name, value := range rateFields(reading.Rate, reading.HasHumidity, output) {
+			fields[name] = value
+		}
+	}
+	return output.roundFields(fields)
+}
+
+// Coefficients for the Magnus formula, from Sonntag (1990), valid between
+// -45°C and 60°C
+const (
+	magnusB = 17.62
+	magnusC = 243.12
+)
+
+func dewPoint(temp physic.Temperature, humidity physic.RelativeHumidity) float64 {
+	// The temperature in °C to which air at `temp` and `humidity` must be
+	// cooled for water to condense, using the Magnus formula. `humidity` must
+	// be greater than 0.
+
+	t := temp.Celsius()
+	rh := float64(humidity) / float64(physic.PercentRH)
+
+	gamma := math.Log(rh/100) + magnusB*t/(magnusC+t)
+	return magnusC * gamma / (magnusB - gamma)
+}
+
+// Saturation vapour pressure of water at 0°C in Pa, for the Magnus formula
+const magnusA = 611.2
+
+// Specific gas constant of water vapour, in J/(kg·K)
+const waterVapourGasConstant = 461.5
+
+func absoluteHumidity(temp physic.Temperature, humidity physic.RelativeHumidity) float64 {
+	// The mass of water vapour in g/m³ in air at `temp` and `humidity`. The
+	// saturation vapour pressure comes from the Magnus formula, as for the
+	// dew point, and the vapour is treated as an ideal gas.
+
+	t := temp.Celsius()
+	rh := float64(humidity) / float64(physic.PercentRH)
+
+	vapourPressure := magnusA * math.Exp(magnusB*t/(magnusC+t)) * rh / 100
+	return 1000 * vapourPressure / (waterVapourGasConstant * (t + 273.15))
+}
+
+// Temperature in °F below which the heat index is just the air temperature
+const heatIndexThresholdF = 80
+
+func heatIndex(temp physic.Temperature, humidity physic.RelativeHumidity) float64 {
+	// The apparent temperature in °C of air at `temp` and `humidity`, using
+	// the US National Weather Service's Rothfusz regression and adjustments.
+	// The regression only applies from 80°F (26.7°C) upwards; below that,
+	// humidity makes little difference to how warm it feels, so the air
+	// temperature is returned unchanged.
+
+	t := temp.Fahrenheit()
+	rh := float64(humidity) / float64(physic.PercentRH)
+	if t < heatIndexThresholdF {
+		return temp.Celsius()
+	}
+
+	hi := -42.379 + 2.04901523*t + 10.14333127*rh -
+		0.22475541*t*rh - 0.00683783*t*t - 0.05481717*rh*rh +
+		0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+
+	if rh < 13 && t <= 112 {
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	} else if rh > 85 && t <= 87 {
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+
+	return (hi - 32) * 5 / 9
+}
+
+// Temperature lapse rate of the standard atmosphere, in K/m
+const lapseRate = 0.0065
+
+func seaLevelPressure(p physic.Pressure, altitudeM float64, temp physic.Temperature) float64 {
+	// The pressure in Pa that `p`, measured at `altitudeM` metres above sea
+	// level with an air temperature of `temp`, corresponds to at sea level.
+	// This is what weather services report, so it can be compared with them.
+
+	pascals := float64(p) / float64(physic.Pascal)
+	t := temp.Celsius()
+
+	return pascals * math.Pow(1-lapseRate*altitudeM/(t+lapseRate*altitudeM+273.15), -5.257)
+}
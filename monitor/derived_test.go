@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func percentRH(rh float64) physic.RelativeHumidity {
+	return physic.RelativeHumidity(rh * float64(physic.PercentRH))
+}
+
+func TestDewPoint(t *testing.T) {
+	// Reference values from the NOAA dew point calculator
+	tests := []struct {
+		temp, humidity, want float64
+	}{
+		{20, 50, 9.3},
+		{25, 60, 16.7},
+		{30, 80, 26.2},
+		{35, 20, 8.7},
+		{-10, 70, -14.4},
+		{15, 100, 15},
+	}
+	for _, tt := range tests {
+		if got := dewPoint(celsius(tt.temp), percentRH(tt.humidity)); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("dewPoint(%v°C, %v%%) = %.2f, want %v", tt.temp, tt.humidity, got, tt.want)
+		}
+	}
+}
+
+func TestDewPointOmittedWithoutHumidity(t *testing.T) {
+	output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Pressure: true, Humidity: true}}
+	tests := []struct {
+		name        string
+		hasHumidity bool
+		humidity    float64
+		want        bool
+	}{
+		{"with humidity", true, 50, true},
+		{"zero humidity", true, 0, false},
+		{"no humidity", false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := testReading(20, 0)
+			reading.HasHumidity, reading.Humidity = tt.hasHumidity, percentRH(tt.humidity)
+			if _, got := derivedFields(reading, output)["dew_point"]; got != tt.want {
+				t.Errorf("dew_point written = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbsoluteHumidity(t *testing.T) {
+	// Reference values from tables of the saturation vapour density of
+	// water, scaled by the relative humidity
+	tests := []struct {
+		temp, humidity, want float64
+	}{
+		{20, 50, 8.65},
+		{25, 60, 13.8},
+		{30, 80, 24.3},
+		{0, 100, 4.85},
+		{-10, 70, 1.65},
+		{20, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := absoluteHumidity(celsius(tt.temp), percentRH(tt.humidity)); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("absoluteHumidity(%v°C, %v%%) = %.2f g/m³, want %v", tt.temp, tt.humidity, got, tt.want)
+		}
+	}
+}
+
+func TestAbsoluteHumidityOmittedWithoutHumidity(t *testing.T) {
+	tests := []struct {
+		name        string
+		hasHumidity bool
+		// Whether the temperature is written, which it's worked out from
+		temperature bool
+		want        bool
+	}{
+		{"with humidity", true, true, true},
+		{"temperature not written", true, false, false},
+		{"no humidity", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Fahrenheit, PressureUnit: Hectopascal, Fields: Fields{Temperature: tt.temperature, Pressure: true, Humidity: true}}
+			reading := testReading(20, 0)
+			reading.HasHumidity = tt.hasHumidity
+			value, got := derivedFields(reading, output)["absolute_humidity"]
+			if got != tt.want {
+				t.Fatalf("absolute_humidity written = %v, want %v", got, tt.want)
+			}
+			// It's in g/m³ whatever the temperature unit
+			if got && math.Abs(value-8.65) > 0.1 {
+				t.Errorf("absolute_humidity = %.2f, want 8.65", value)
+			}
+		})
+	}
+}
+
+func fahrenheit(f float64) physic.Temperature {
+	return celsius((f - 32) * 5 / 9)
+}
+
+func TestHeatIndex(t *testing.T) {
+	// Reference values from the US National Weather Service heat index table,
+	// in °F
+	tests := []struct {
+		name                 string
+		temp, humidity, want float64
+	}{
+		{"table 90°F 50%", 90, 50, 95},
+		{"table 100°F 40%", 100, 40, 109},
+		{"table 86°F 90%", 86, 90, 105},
+		{"at the threshold", 80, 90, 86},
+		{"just below the threshold", 79.9, 90, 79.9},
+		{"cool", 50, 90, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := heatIndex(fahrenheit(tt.temp), percentRH(tt.humidity))*9/5 + 32
+			if math.Abs(got-tt.want) > 1 {
+				t.Errorf("heatIndex(%v°F, %v%%) = %.1f°F, want %v°F", tt.temp, tt.humidity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeatIndexBelowThresholdIsAirTemperature(t *testing.T) {
+	for _, temp := range []float64{-20, 0, 20, 26.6} {
+		if got := heatIndex(celsius(temp), percentRH(95)); math.Abs(got-temp) > 1e-6 {
+			t.Errorf("heatIndex(%v°C, 95%%) = %v, want the air temperature", temp, got)
+		}
+	}
+}
+
+func TestSeaLevelPressure(t *testing.T) {
+	// In the standard atmosphere, the pressure at each altitude corrects to
+	// 1013.25hPa at sea level
+	tests := []struct {
+		name     string
+		pressure float64
+		altitude float64
+		temp     float64
+		want     float64
+	}{
+		{"sea level", 1013.25, 0, 15, 1013.25},
+		{"500m", 954.61, 500, 11.75, 1013.25},
+		{"1000m", 898.75, 1000, 8.5, 1013.25},
+		{"low pressure at 300m", 960, 300, 10, 995.3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := physic.Pressure(tt.pressure * 100 * float64(physic.Pascal))
+			if got := seaLevelPressure(p, tt.altitude, celsius(tt.temp)) / 100; math.Abs(got-tt.want) > 0.1 {
+				t.Errorf("seaLevelPressure(%vhPa, %vm, %v°C) = %.2fhPa, want %v", tt.pressure, tt.altitude, tt.temp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeaLevelPressureSkippedWithoutAltitude(t *testing.T) {
+	for _, altitude := range []float64{0, 250} {
+		output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Pressure: true}, Altitude: altitude}
+		if _, got := derivedFields(testReading(20, 0), output)["sea_level_pressure"]; got != (altitude != 0) {
+			t.Errorf("altitude %vm: sea_level_pressure written = %v, want %v", altitude, got, altitude != 0)
+		}
+	}
+}
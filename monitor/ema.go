@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// Values accepted by the `-average_mode` flag
+var AverageModes = []string{"window", "ema", "none"}
+
+// The running exponential moving average of each field of a reading
+type movingAverage struct {
+	temperature float64
+	pressure    float64
+	humidity    float64
+	seeded      bool
+}
+
+func (m *movingAverage) add(alpha float64, env physic.Env) {
+	// Fold `env` into the average, giving it a weight of `alpha`. The first
+	// value seeds the average.
+
+	if !m.seeded {
+		m.temperature = float64(env.Temperature)
+		m.pressure = float64(env.Pressure)
+		m.humidity = float64(env.Humidity)
+		m.seeded = true
+		return
+	}
+
+	m.temperature = alpha*float64(env.Temperature) + (1-alpha)*m.temperature
+	m.pressure = alpha*float64(env.Pressure) + (1-alpha)*m.pressure
+	m.humidity = alpha*float64(env.Humidity) + (1-alpha)*m.humidity
+}
+
+func (m *movingAverage) env() physic.Env {
+	return physic.Env{
+		Temperature: physic.Temperature(math.Round(m.temperature)),
+		Pressure:    physic.Pressure(math.Round(m.pressure)),
+		Humidity:    physic.RelativeHumidity(math.Round(m.humidity)),
+	}
+}
+
+func ValidateEMAAlpha(alpha float64) error {
+	if alpha <= 0 || alpha > 1 {
+		return fmt.Errorf("invalid EMA alpha %v: must be greater than 0 and at most 1", alpha)
+	}
+	return nil
+}
+
+func (s *runState) emaStream(alpha float64, fields Fields, logging <-chan Reading, averages chan<- Reading) {
+	// Continuously reads from the `logging` chan, sending the exponential moving
+	// average of the values received so far to the `averages` chan after each
+	// one. Larger values of `alpha` follow changes more closely, but smooth out
+	// less noise. Values that aren't in `fields` are zeroed rather than
+	// averaged.
+	// `averages` is closed once `logging` is closed
+
+	defer slog.Info("Averaging stopped")
+	defer close(averages)
+
+	average := movingAverage{}
+	var rates rateTracker
+	var trend pressureTrendTracker
+	var mold moldRiskTracker
+	add := func(reading Reading) {
+		slog.Debug("Added sample to moving average", "reading", reading)
+		average.add(alpha, fields.mask(reading.Env))
+		averaged := Reading{Env: average.env(), HasHumidity: reading.HasHumidity, Label: reading.Label, Voltage: reading.Voltage, Gas: reading.Gas, Time: reading.Time, Seq: reading.Seq}
+		rates.update(&averaged, averaged.Time)
+		trend.update(&averaged, averaged.Time, s.pressureTrend)
+		mold.update(&averaged, averaged.Time, s.moldRisk)
+		s.recordAverage(averaged, averaged.Time)
+		averages <- averaged
+	}
+
+	for reading := range logging {
+		add(reading)
+	}
+}
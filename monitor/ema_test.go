@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestEMAStream(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpha float64
+		temps []float64
+		// Hand-computed averages after each reading
+		want []float64
+	}{
+		{"seeded with the first reading", 0.5, []float64{20}, []float64{20}},
+		{"half weight", 0.5, []float64{20, 22, 26, 18}, []float64{20, 21, 23.5, 20.75}},
+		{"quarter weight", 0.25, []float64{10, 14, 14, 6}, []float64{10, 11, 11.75, 10.3125}},
+		{"follows exactly", 1, []float64{10, 14, 6}, []float64{10, 14, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			logging := make(chan Reading, len(tt.temps))
+			averages := make(chan Reading, len(tt.temps))
+			for i, temp := range tt.temps {
+				reading := testReading(temp, 0)
+				// Each field is averaged on its own
+				reading.Humidity = physic.RelativeHumidity(temp * float64(physic.PercentRH))
+				reading.Seq = uint64(i + 1)
+				logging <- reading
+			}
+			close(logging)
+			newRunState(config).emaStream(tt.alpha, config.Output.Fields, logging, averages)
+
+			for i, want := range tt.want {
+				average, ok := receive(t, averages)
+				if !ok {
+					t.Fatalf("got %d averages, want %d", i, len(tt.want))
+				}
+				if got := average.Temperature.Celsius(); math.Abs(got-want) > 1e-6 {
+					t.Errorf("average %d temperature = %v, want %v", i+1, got, want)
+				}
+				if got := float64(average.Humidity) / float64(physic.PercentRH); math.Abs(got-want) > 1e-6 {
+					t.Errorf("average %d humidity = %v, want %v", i+1, got, want)
+				}
+				if got := average.Pressure; got != 1013*100*physic.Pascal {
+					t.Errorf("average %d pressure = %v, want 1013hPa", i+1, got)
+				}
+				if average.Seq != uint64(i+1) {
+					t.Errorf("average %d seq = %d, want %d", i+1, average.Seq, i+1)
+				}
+			}
+			if _, open := receive(t, averages); open {
+				t.Error("averages wasn't closed after the last reading")
+			}
+		})
+	}
+}
+
+func TestValidateEMAAlpha(t *testing.T) {
+	tests := []struct {
+		alpha   float64
+		wantErr bool
+	}{
+		{-0.1, true},
+		{0, true},
+		{0.1, false},
+		{1, false},
+		{1.5, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateEMAAlpha(tt.alpha); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateEMAAlpha(%v) = %v, want error %v", tt.alpha, err, tt.wantErr)
+		}
+	}
+}
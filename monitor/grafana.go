@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+func (h *readingHistory) targets(output OutputConfig) []string {
+	// Names of the series that can be queried: each field written for each
+	// sensor, named as in InfluxDB and prefixed with the sensor's label if it
+	// has one, e.g. outdoor.temp
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, r := range h.readings.ordered() {
+		for name := range historyFields(r.reading, output) {
+			seen[name] = true
+		}
+	}
+	targets := make([]string, 0, len(seen))
+	for name := range seen {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func historyFields(reading Reading, output OutputConfig) map[string]float64 {
+	// Values of `reading` served to Grafana, keyed by their series name
+
+	prefix := ""
+	if reading.Label != "" {
+		prefix = reading.Label + "."
+	}
+
+	temp, pressure, humidity := convertEnv(reading.Env, output)
+	fields := map[string]float64{}
+	if output.Fields.Temperature {
+		fields[prefix+"temp"] = temp
+	}
+	if output.Fields.Pressure {
+		fields[prefix+"pressure"] = pressure
+	}
+	if reading.HasHumidity {
+		fields[prefix+"humidity"] = humidity
+	}
+	if reading.Voltage != nil {
+		fields[prefix+"voltage"] = volts(*reading.Voltage)
+	}
+	if reading.Gas != nil {
+		fields[prefix+"gas"] = ohms(*reading.Gas)
+	}
+	return fields
+}
+
+// Body of a query from Grafana's JSON datasources. Only the fields used here
+// are decoded.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// A series in the response to a query. Each of `Datapoints` is a value and
+// its time, in milliseconds since the epoch.
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+func grafanaQueryHandler(history *readingHistory, clock Clock, output OutputConfig) http.HandlerFunc {
+	// Respond to a query with each of the requested series over the requested
+	// time range, from the averages kept. Without a range, all of them are
+	// returned.
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var query grafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to := query.Range.To
+		if to.IsZero() {
+			to = clock.Now()
+		}
+
+		readings := history.between(query.Range.From, to)
+		series := make([]grafanaSeries, 0, len(query.Targets))
+		for _, target := range query.Targets {
+			s := grafanaSeries{Target: target.Target, Datapoints: [][2]float64{}}
+			for _, r := range readings {
+				if value, ok := historyFields(r.reading, output)[target.Target]; ok {
+					s.Datapoints = append(s.Datapoints, [2]float64{value, float64(r.t.UnixMilli())})
+				}
+			}
+			series = append(series, s)
+		}
+		writeJSON(w, http.StatusOK, series)
+	}
+}
+
+func grafanaSearchHandler(history *readingHistory, output OutputConfig) http.HandlerFunc {
+	// Respond with the names of the series that can be queried
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, history.targets(output))
+	}
+}
+
+func grafanaTestHandler(w http.ResponseWriter, r *http.Request) {
+	// Respond with 200, which Grafana checks for when the datasource is saved
+
+	if r.URL.Path != "/grafana/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaQueryRange(t *testing.T) {
+	// Readings at 0, 1 and 2 hours, queried with the clock at 90 minutes
+	tests := []struct {
+		name  string
+		query string
+		times []time.Duration
+	}{
+		{"without a range, up to now", `{"targets":[{"target":"temp"}]}`, []time.Duration{0, time.Hour}},
+		{"from a time, up to now", `{"range":{"from":"2024-01-01T12:30:00Z"},"targets":[{"target":"temp"}]}`, []time.Duration{time.Hour}},
+		{"given range", `{"range":{"from":"2024-01-01T12:00:00Z","to":"2024-01-01T14:00:00Z"},"targets":[{"target":"temp"}]}`, []time.Duration{0, time.Hour, 2 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			clock.Advance(90 * time.Minute)
+			output := testConfig().Output
+			var history readingHistory
+			history.setSize(10)
+			for _, at := range []time.Duration{0, time.Hour, 2 * time.Hour} {
+				history.record(testReading(20, at), testTime.Add(at))
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/grafana/query", strings.NewReader(tt.query))
+			grafanaQueryHandler(&history, clock, output)(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var series []grafanaSeries
+			if err := json.Unmarshal(w.Body.Bytes(), &series); err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != 1 || len(series[0].Datapoints) != len(tt.times) {
+				t.Fatalf("response = %+v, want %d points", series, len(tt.times))
+			}
+			for i, at := range tt.times {
+				if got, want := int64(series[0].Datapoints[i][1]), testTime.Add(at).UnixMilli(); got != want {
+					t.Errorf("point %d at %d, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGrafanaQueryResponse(t *testing.T) {
+	history := &readingHistory{}
+	history.setSize(10)
+	indoor := testReading(20, 0)
+	outdoor := testReading(5, 0)
+	outdoor.Label = "outdoor"
+	history.record(indoor, testTime)
+	history.record(outdoor, testTime)
+	clock := NewFakeClock(testTime.Add(time.Minute))
+	handler := grafanaQueryHandler(history, clock, testConfig().Output)
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		status int
+		// Raw JSON expected for a successful query
+		want string
+	}{
+		{
+			"series of each target",
+			http.MethodPost,
+			`{"targets":[{"target":"temp"},{"target":"outdoor.humidity"}]}`,
+			http.StatusOK,
+			`[{"target":"temp","datapoints":[[20,1704110400000]]},{"target":"outdoor.humidity","datapoints":[[50,1704110400000]]}]`,
+		},
+		{
+			"unknown target",
+			http.MethodPost,
+			`{"targets":[{"target":"indoor.temp"}]}`,
+			http.StatusOK,
+			`[{"target":"indoor.temp","datapoints":[]}]`,
+		},
+		{"no targets", http.MethodPost, `{"targets":[]}`, http.StatusOK, `[]`},
+		{"invalid query", http.MethodPost, `{"targets":`, http.StatusBadRequest, ""},
+		{"not a POST", http.MethodGet, "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, "/grafana/query", strings.NewReader(tt.query)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := strings.TrimSpace(w.Body.String()); tt.want != "" && got != tt.want {
+				t.Errorf("response =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrafanaSearch(t *testing.T) {
+	history := &readingHistory{}
+	history.setSize(10)
+	w := httptest.NewRecorder()
+	grafanaSearchHandler(history, testConfig().Output)(w, httptest.NewRequest(http.MethodPost, "/grafana/search", nil))
+	if got := strings.TrimSpace(w.Body.String()); got != "[]" {
+		t.Errorf("targets before any readings = %s, want []", got)
+	}
+
+	outdoor := testReading(5, 0)
+	outdoor.Label = "outdoor"
+	outdoor.HasHumidity = false
+	history.record(testReading(20, 0), testTime)
+	history.record(outdoor, testTime)
+	w = httptest.NewRecorder()
+	grafanaSearchHandler(history, testConfig().Output)(w, httptest.NewRequest(http.MethodPost, "/grafana/search", nil))
+	want := `["humidity","outdoor.pressure","outdoor.temp","pressure","temp"]`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("targets = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// HeartbeatSink passes readings on to another sink, and when no reading has
+// been written for a sensor for `interval`, writes its last reading again
+// with the current time, so dashboards can tell the monitor is alive while
+// a long averaging window fills
+type HeartbeatSink struct {
+	sink     Sink
+	interval time.Duration
+	clock    Clock
+
+	// Held while writing to `sink`, so heartbeats and readings aren't
+	// written at the same time
+	mu sync.Mutex
+	// Last reading written for each sensor label, and when
+	last map[string]heartbeatPoint
+	// Signalled when the first reading is written, so the heartbeats start
+	written chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type heartbeatPoint struct {
+	reading Reading
+	at      time.Time
+}
+
+func newHeartbeatSink(sink Sink, interval time.Duration, clock Clock) *HeartbeatSink {
+	s := &HeartbeatSink{
+		sink:     sink,
+		interval: interval,
+		clock:    clock,
+		last:     map[string]heartbeatPoint{},
+		written:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *HeartbeatSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sink.Write(ctx, reading, t); err != nil {
+		return err
+	}
+	s.last[reading.Label] = heartbeatPoint{reading, s.clock.Now()}
+	select {
+	case s.written <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *HeartbeatSink) run() {
+	// Write a heartbeat for each sensor whose last reading was written at
+	// least `s.interval` ago, until the sink is closed. Readings only push
+	// the next heartbeat back, so waiting until the earliest one is due
+	// never misses one.
+
+	defer close(s.stopped)
+	for {
+		wait, ok := s.untilNext()
+		if !ok {
+			select {
+			case <-s.stop:
+				return
+			case <-s.written:
+				continue
+			}
+		}
+
+		timer := s.clock.NewTimer(wait)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+			s.beat()
+		}
+	}
+}
+
+func (s *HeartbeatSink) untilNext() (time.Duration, bool) {
+	// How long until the next heartbeat is due, or false if no reading has
+	// been written yet
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.last) == 0 {
+		return 0, false
+	}
+	var next time.Time
+	for _, point := range s.last {
+		if due := point.at.Add(s.interval); next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return max(next.Sub(s.clock.Now()), 0), true
+}
+
+func (s *HeartbeatSink) beat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for label, point := range s.last {
+		if now.Sub(point.at) < s.interval {
+			continue
+		}
+		reading := point.reading
+		reading.Time = now
+		slog.Debug("Writing heartbeat", "sensor", label, "last_written", point.at)
+		if err := s.sink.Write(context.Background(), reading, now); err != nil {
+			slog.Warn("Failed to write heartbeat", "sensor", label, "error", err)
+		}
+		// A failed heartbeat isn't retried until the next one is due, so a
+		// sink that's down isn't hammered
+		s.last[label] = heartbeatPoint{point.reading, now}
+	}
+}
+
+func (s *HeartbeatSink) Close() error {
+	close(s.stop)
+	<-s.stopped
+	return s.sink.Close()
+}
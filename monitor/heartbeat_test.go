@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatSink(t *testing.T) {
+	// Every sensor is written at the start, then the fresh ones every half
+	// interval, for three intervals
+	const interval = time.Minute
+	tests := []struct {
+		name  string
+		fresh []string
+		stale []string
+		// Heartbeats written for each sensor
+		want map[string]int
+	}{
+		{"heartbeats during a gap", nil, []string{""}, map[string]int{"": 3}},
+		{"suppressed while fresh data flows", []string{""}, nil, map[string]int{}},
+		{"only for the quiet sensor", []string{"indoor"}, []string{"outdoor"}, map[string]int{"outdoor": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			inner := &recordingSink{}
+			sink := newHeartbeatSink(inner, interval, clock)
+			write := func(label string) {
+				reading := testReading(20, clock.Now().Sub(testTime))
+				reading.Label = label
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, label := range append(tt.fresh, tt.stale...) {
+				write(label)
+			}
+			written := map[string]int{}
+			for _, label := range append(tt.fresh, tt.stale...) {
+				written[label]++
+			}
+			for i := 0; i < 6; i++ {
+				clock.BlockUntil(1)
+				clock.Advance(interval / 2)
+				for _, label := range tt.fresh {
+					write(label)
+					written[label]++
+				}
+			}
+			// Wait for the heartbeat due at the end to be written, and the next
+			// to be scheduled
+			clock.BlockUntil(1)
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]int{}
+			for i, reading := range inner.written {
+				if written[reading.Label] > 0 {
+					written[reading.Label]--
+					continue
+				}
+				got[reading.Label]++
+				if !reading.Time.Equal(inner.times[i]) {
+					t.Errorf("heartbeat for %q at %v written with time %v", reading.Label, reading.Time, inner.times[i])
+				}
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("heartbeats = %v, want %v", got, tt.want)
+			}
+			if !inner.closed {
+				t.Error("the sink wasn't closed")
+			}
+		})
+	}
+}
+
+func TestHeartbeatSinkTimes(t *testing.T) {
+	// Heartbeats repeat the last reading with the time they're written
+	clock := NewFakeClock(testTime)
+	inner := &recordingSink{}
+	sink := newHeartbeatSink(inner, time.Minute, clock)
+	defer sink.Close()
+	if err := sink.Write(context.Background(), testReading(21, 0), testTime); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+	}
+	clock.BlockUntil(1)
+
+	if inner.count() != 3 {
+		t.Fatalf("wrote %d points, want a reading and 2 heartbeats", inner.count())
+	}
+	for i, want := range []time.Time{testTime, testTime.Add(time.Minute), testTime.Add(2 * time.Minute)} {
+		inner.mu.Lock()
+		reading, at := inner.written[i], inner.times[i]
+		inner.mu.Unlock()
+		if !at.Equal(want) || !reading.Time.Equal(want) || reading.Temperature != celsius(21) {
+			t.Errorf("point %d = %v°C at %v, time %v, want 21°C at %v", i, reading.Temperature.Celsius(), reading.Time, at, want)
+		}
+	}
+}
+
+func TestNewSinkHeartbeat(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     bool
+	}{
+		{0, false},
+		{time.Minute, true},
+	}
+	for _, tt := range tests {
+		config := testConfig()
+		config.Clock = NewFakeClock(testTime)
+		config.HeartbeatInterval = tt.interval
+		sink, err := newSink(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, got := sink.(*HeartbeatSink); got != tt.want {
+			t.Errorf("newSink() with a heartbeat interval of %v = %T, want heartbeats %v", tt.interval, sink, tt.want)
+		}
+		sink.Close()
+	}
+}
@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// readingHistory keeps the most recent averages in a ring buffer, served at
+// /history and to Grafana at /grafana
+type readingHistory struct {
+	mu       sync.Mutex
+	readings ring[timedReading]
+}
+
+func (h *readingHistory) setSize(size int) {
+	// Keep the last `size` averages, or none if it's 0, discarding any
+	// already kept
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readings = ring[timedReading]{}
+	h.readings.resize(size)
+}
+
+func (h *readingHistory) capacity() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.readings.size()
+}
+
+func (h *readingHistory) record(reading Reading, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readings.push(timedReading{reading, t})
+}
+
+func (h *readingHistory) last(n int) []timedReading {
+	// The last `n` averages kept, or all of them if there are fewer, oldest
+	// first
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	readings := h.readings.ordered()
+	return readings[len(readings)-min(n, len(readings)):]
+}
+
+func (h *readingHistory) between(from, to time.Time) []timedReading {
+	// The averages kept from `from` to `to` inclusive, oldest first
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	readings := []timedReading{}
+	for _, r := range h.readings.ordered() {
+		if !r.t.Before(from) && !r.t.After(to) {
+			readings = append(readings, r)
+		}
+	}
+	return readings
+}
+
+func historyHandler(history *readingHistory, output OutputConfig) http.HandlerFunc {
+	// Respond with the last `n` averages kept, oldest first, as the same JSON
+	// objects as the stdout sink, or all of them if `n` isn't given
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := history.capacity()
+		if value := r.URL.Query().Get("n"); value != "" {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil || n < 0 {
+				http.Error(w, "Invalid n: must be a number of readings", http.StatusBadRequest)
+				return
+			}
+		}
+
+		readings := history.last(n)
+		records := make([]jsonRecord, 0, len(readings))
+		for _, r := range readings {
+			records = append(records, newJSONRecord(r.reading, r.t, output))
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
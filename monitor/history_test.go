@@ -0,0 +1,126 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadingHistoryLast(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		recorded int
+		n        int
+		// Minutes past `testTime` of the readings returned
+		want []int
+	}{
+		{"empty", 5, 0, 3, []int{}},
+		{"not yet full", 5, 3, 2, []int{1, 2}},
+		{"full", 5, 5, 5, []int{0, 1, 2, 3, 4}},
+		{"wrapped around", 5, 7, 5, []int{2, 3, 4, 5, 6}},
+		{"wrapped around, fewer", 5, 7, 2, []int{5, 6}},
+		{"wrapped around several times", 3, 10, 3, []int{7, 8, 9}},
+		{"n more than kept", 5, 3, 10, []int{0, 1, 2}},
+		{"n more than the size", 5, 8, 100, []int{3, 4, 5, 6, 7}},
+		{"none", 5, 3, 0, []int{}},
+		{"nothing kept", 0, 3, 3, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var history readingHistory
+			history.setSize(tt.size)
+			for i := 0; i < tt.recorded; i++ {
+				at := time.Duration(i) * time.Minute
+				history.record(testReading(20, at), testTime.Add(at))
+			}
+			got := []int{}
+			for _, r := range history.last(tt.n) {
+				got = append(got, int(r.t.Sub(testTime)/time.Minute))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("last(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadingHistoryConcurrentUse(t *testing.T) {
+	// Run with -race to check the buffer is safe to use from several
+	// goroutines
+	var history readingHistory
+	history.setSize(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				history.record(testReading(20, 0), testTime.Add(time.Duration(j)*time.Second))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if n := len(history.last(5)); n > 5 {
+					t.Errorf("last(5) returned %d readings", n)
+				}
+				history.between(testTime, testTime.Add(time.Minute))
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(history.last(100)); n != 10 {
+		t.Errorf("kept %d readings, want 10", n)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		count  int
+	}{
+		{"", http.StatusOK, 5},
+		{"?n=2", http.StatusOK, 2},
+		{"?n=100", http.StatusOK, 5},
+		{"?n=0", http.StatusOK, 0},
+		{"?n=-1", http.StatusBadRequest, 0},
+		{"?n=all", http.StatusBadRequest, 0},
+	}
+	var history readingHistory
+	history.setSize(5)
+	for i := 0; i < 8; i++ {
+		at := time.Duration(i) * time.Minute
+		history.record(testReading(float64(i), at), testTime.Add(at))
+	}
+	for _, tt := range tests {
+		t.Run("/history"+tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			historyHandler(&history, testConfig().Output)(w, httptest.NewRequest(http.MethodGet, "/history"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var records []jsonRecord
+			if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != tt.count {
+				t.Fatalf("returned %d readings, want %d", len(records), tt.count)
+			}
+			// Oldest first, ending with the latest
+			for i, record := range records {
+				if want := float64(8 - tt.count + i); record.TemperatureC == nil || *record.TemperatureC != want {
+					t.Errorf("reading %d temperature = %v, want %v", i, record.TemperatureC, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+type InfluxConfig struct {
+	// Major version of the server: 2 for the token, org and bucket, or 1 for
+	// the username, password and database
+	Version  int
+	URL      string
+	Token    string
+	Org      string
+	Bucket   string
+	Username string
+	Password string
+	Database string
+	// Measurement that points are written to
+	Measurement string
+	// Precision of the timestamps written, e.g. time.Second
+	Precision time.Duration
+	// Number of points to send in each request, or 1 to send each point as
+	// soon as it's written. Partial batches are sent every `FlushInterval`.
+	BatchSize     uint
+	FlushInterval time.Duration
+	// How long to wait for the server to respond to each request, or 0 to
+	// wait indefinitely
+	Timeout time.Duration
+	// PEM file of CA certificates to trust as well as the system's, for
+	// servers with a certificate from an internal CA, or "" for only the
+	// system's
+	CAFile string
+	// Skip verifying the server's certificate, e.g. for a self-signed one
+	Insecure bool
+	// Create `Bucket` in `Org` on startup if it doesn't exist, keeping points
+	// for `Retention`, or forever if it's 0
+	CreateBucket bool
+	Retention    time.Duration
+}
+
+// InfluxSink writes readings as points in an InfluxDB bucket
+type InfluxSink struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPIBlocking
+	// Set instead of `writeAPI` when writes are batched
+	batchAPI    api.WriteAPI
+	measurement string
+	precision   time.Duration
+	output      OutputConfig
+}
+
+// Timestamp precisions accepted by the `-influx_precision` flag
+var influxPrecisions = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+func ParseInfluxPrecision(value string) (time.Duration, error) {
+	precision, ok := influxPrecisions[value]
+	if !ok {
+		return 0, fmt.Errorf("invalid InfluxDB precision %q: must be s, ms, us or ns", value)
+	}
+	return precision, nil
+}
+
+func newInfluxHTTPClient(config InfluxConfig) (*http.Client, error) {
+	// The HTTP client used to talk to either version of InfluxDB, with the
+	// timeout and TLS settings in `config`
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the InfluxDB CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the InfluxDB CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: config.Timeout, Transport: transport}, nil
+}
+
+func newInfluxSink(config InfluxConfig, output OutputConfig) (*InfluxSink, error) {
+	httpClient, err := newInfluxHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BatchSize <= 1 {
+		options := influxdb2.DefaultOptions().SetPrecision(config.Precision).SetHTTPClient(httpClient)
+		client := influxdb2.NewClientWithOptions(config.URL, config.Token, options)
+		if err := createInfluxBucket(client, config); err != nil {
+			return nil, err
+		}
+		return &InfluxSink{
+			client:      client,
+			writeAPI:    client.WriteAPIBlocking(config.Org, config.Bucket),
+			measurement: config.Measurement,
+			precision:   config.Precision,
+			output:      output,
+		}, nil
+	}
+
+	// The batching client sends points in the background, retrying failed
+	// requests itself, so errors can only be logged as they're reported
+	options := influxdb2.DefaultOptions().
+		SetHTTPClient(httpClient).
+		SetPrecision(config.Precision).
+		SetBatchSize(config.BatchSize).
+		SetFlushInterval(uint(config.FlushInterval / time.Millisecond))
+	client := influxdb2.NewClientWithOptions(config.URL, config.Token, options)
+	if err := createInfluxBucket(client, config); err != nil {
+		return nil, err
+	}
+	batchAPI := client.WriteAPI(config.Org, config.Bucket)
+	// The client creates the error channel on first use, so it's fetched
+	// here rather than racing with `Close`
+	errs := batchAPI.Errors()
+	go func() {
+		for err := range errs {
+			sinkWriteFailures.Inc()
+			slog.Error("Failed to write batch to InfluxDB", "error", err)
+		}
+	}()
+
+	return &InfluxSink{
+		client:      client,
+		batchAPI:    batchAPI,
+		measurement: config.Measurement,
+		precision:   config.Precision,
+		output:      output,
+	}, nil
+}
+
+func ValidateMeasurement(name string) error {
+	// Check that `name` can be used as a measurement. Spaces and commas are
+	// escaped in line protocol, but line breaks and other control characters
+	// can't be, and names starting with _ are reserved by InfluxDB.
+
+	if name == "" {
+		return fmt.Errorf("the InfluxDB measurement can't be empty")
+	}
+	if strings.HasPrefix(name, "_") {
+		return fmt.Errorf("invalid InfluxDB measurement %q: names starting with _ are reserved", name)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid InfluxDB measurement %q: must not contain control characters", name)
+	}
+	return nil
+}
+
+func newInfluxPoint(reading Reading, t time.Time, measurement string, output OutputConfig) *write.Point {
+	// Build the point written for `reading` to `measurement` by both versions
+	// of the InfluxDB sink
+
+	temp, pressure, humidity := convertEnv(reading.Env, output)
+	fields := map[string]interface{}{}
+	if output.Fields.Temperature {
+		fields[output.fieldName("temp")] = temp
+	}
+	if output.Fields.Pressure {
+		fields[output.fieldName("pressure")] = pressure
+	}
+	if reading.HasHumidity {
+		fields[output.fieldName("humidity")] = humidity
+	}
+	if reading.Voltage != nil {
+		fields["voltage"] = volts(*reading.Voltage)
+	}
+	if reading.Gas != nil {
+		fields["gas"] = ohms(*reading.Gas)
+	}
+	if output.Fields.Pressure && reading.PressureFalling != nil {
+		fields["pressure_falling"] = *reading.PressureFalling
+	}
+	if output.Fields.Humidity && reading.MoldRisk != nil {
+		fields["mold_risk"] = *reading.MoldRisk
+	}
+	if output.Fields.Seq {
+		fields["seq"] = int64(reading.Seq)
+	}
+	if reading.Stats != nil {
+		for name, value := range convertStats(reading.Stats, reading.HasHumidity, output) {
+			fields[name] = value
+		}
+	}
+	for name, value := range derivedFields(reading, output) {
+		fields[name] = value
+	}
+
+	// Tag readings with where they were taken, so each sensor gets its own
+	// series and monitors sharing a bucket can be told apart
+	tags := readingTags(reading, output)
+	tags["temp_unit"] = string(output.TemperatureUnit)
+	tags["pressure_unit"] = string(output.PressureUnit)
+
+	// Create point using full params constructor
+	return influxdb2.NewPoint(measurement,
+		tags,
+		fields,
+		t)
+}
+
+func (s *InfluxSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	// Truncate rather than leaving the client to, so the time written doesn't
+	// depend on how the point is sent
+	p := newInfluxPoint(reading, t.Truncate(s.precision), s.measurement, s.output)
+	if s.batchAPI != nil {
+		// add point to the current batch
+		s.batchAPI.WritePoint(p)
+		return nil
+	}
+	// write point immediately
+	return s.writeAPI.WritePoint(ctx, p)
+}
+
+func (s *InfluxSink) Close() error {
+	// Closing the client also sends any partial batch
+	s.client.Close()
+	return nil
+}
@@ -0,0 +1,464 @@
+package monitor
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// fakeWriteAPI records the points written through it, failing the writes
+// whose index is in `fail`
+type fakeWriteAPI struct {
+	api.WriteAPIBlocking
+	fail    map[int]bool
+	writes  int
+	written []*write.Point
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *fakeWriteAPI) WritePoint(ctx context.Context, points ...*write.Point) error {
+	defer func() { w.writes++ }()
+	if w.fail[w.writes] {
+		return errWriteFailed
+	}
+	w.written = append(w.written, points...)
+	return nil
+}
+
+func newFakeInfluxSink(writeAPI *fakeWriteAPI) *InfluxSink {
+	return &InfluxSink{
+		writeAPI:    writeAPI,
+		measurement: "environment",
+		precision:   time.Second,
+		output:      OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Pressure: true, Humidity: true}},
+	}
+}
+
+func sendReadings(count int) <-chan Reading {
+	readings := make(chan Reading, count)
+	for i := 0; i < count; i++ {
+		readings <- testReading(20, time.Duration(i)*time.Second)
+	}
+	close(readings)
+	return readings
+}
+
+func TestLogToSinkGivesUpAfterConsecutiveFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		readings    int
+		fail        map[int]bool
+		maxFailures int
+		wantErr     bool
+		wantWrites  int
+	}{
+		{"all written", 3, nil, 2, false, 3},
+		{"gives up", 5, map[int]bool{1: true, 2: true}, 2, true, 3},
+		{"successes reset the count", 5, map[int]bool{0: true, 2: true, 4: true}, 2, false, 5},
+		{"never gives up without a limit", 4, map[int]bool{0: true, 1: true, 2: true, 3: true}, 0, false, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			writeAPI := &fakeWriteAPI{fail: tt.fail}
+
+			err := newRunState(config).logToSink(newFakeInfluxSink(writeAPI), WriteConfig{MaxWriteFailures: tt.maxFailures}, sendReadings(tt.readings))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logToSink() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errWriteFailed) {
+				t.Errorf("logToSink() = %v, want it to wrap the write error", err)
+			}
+			if writeAPI.writes != tt.wantWrites {
+				t.Errorf("made %d writes, want %d", writeAPI.writes, tt.wantWrites)
+			}
+		})
+	}
+}
+
+func TestInfluxPointLeavesOutDisabledFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+		want   []string
+	}{
+		{
+			"all",
+			Fields{Temperature: true, Pressure: true, Humidity: true},
+			[]string{"absolute_humidity", "dew_point", "heat_index", "humidity", "humidity_max", "humidity_min", "humidity_rate", "humidity_std", "pressure", "pressure_max", "pressure_min", "pressure_rate", "pressure_std", "temp", "temp_max", "temp_min", "temp_rate", "temp_std"},
+		},
+		{
+			"temp only",
+			Fields{Temperature: true},
+			[]string{"temp", "temp_max", "temp_min", "temp_rate", "temp_std"},
+		},
+		{
+			"no humidity",
+			Fields{Temperature: true, Pressure: true},
+			[]string{"pressure", "pressure_max", "pressure_min", "pressure_rate", "pressure_std", "temp", "temp_max", "temp_min", "temp_rate", "temp_std"},
+		},
+		{
+			"humidity only",
+			Fields{Humidity: true},
+			[]string{"humidity", "humidity_max", "humidity_min", "humidity_rate", "humidity_std"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: tt.fields}
+
+			// Average readings the way `Run` does, humidity being treated as
+			// not measured when it isn't written
+			var window accumulator
+			var rates rateTracker
+			for i, temp := range []float64{20, 21, 22} {
+				reading := testReading(temp, 0)
+				reading.HasHumidity = tt.fields.Humidity
+				reading.Env = tt.fields.mask(reading.Env)
+				window.add(reading)
+				average := window.average(mean, "end")
+				average.Time = testTime.Add(time.Duration(i) * time.Minute)
+				rates.update(&average, average.Time)
+				if i == 2 {
+					point := newInfluxPoint(average, average.Time, "environment", output)
+					var got []string
+					for _, field := range point.FieldList() {
+						got = append(got, field.Key)
+					}
+					sort.Strings(got)
+					if !slices.Equal(got, tt.want) {
+						t.Errorf("fields = %v, want %v", got, tt.want)
+					}
+				}
+			}
+
+			if !tt.fields.Temperature && window.total.Temperature != 0 {
+				t.Errorf("disabled temperature was accumulated: %v", window.total.Temperature)
+			}
+			if !tt.fields.Pressure && window.total.Pressure != 0 {
+				t.Errorf("disabled pressure was accumulated: %v", window.total.Pressure)
+			}
+			if !tt.fields.Humidity && window.total.Humidity != 0 {
+				t.Errorf("disabled humidity was accumulated: %v", window.total.Humidity)
+			}
+		})
+	}
+}
+
+// influxServer is an InfluxDB 2.x server that accepts writes, keeping the
+// lines and timestamp precision of each request
+type influxServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	requests   [][]string
+	precisions []string
+}
+
+func newInfluxServer(t *testing.T) *influxServer {
+	s := &influxServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, strings.Split(strings.TrimSpace(string(body)), "\n"))
+		s.precisions = append(s.precisions, r.URL.Query().Get("precision"))
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *influxServer) lines() (requests, lines int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, request := range s.requests {
+		lines += len(request)
+	}
+	return len(s.requests), lines
+}
+
+func TestInfluxSinkWritesAllPoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize uint
+		points    int
+		// Requests sent, including the partial batch sent on closing
+		requests int
+	}{
+		{"unbatched", 1, 5, 5},
+		{"full batches", 5, 10, 2},
+		{"partial batch flushed on close", 4, 10, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newInfluxServer(t)
+			config := InfluxConfig{
+				Version:       2,
+				URL:           server.URL,
+				Org:           "org",
+				Bucket:        "bucket",
+				Measurement:   "environment",
+				Precision:     time.Second,
+				BatchSize:     tt.batchSize,
+				FlushInterval: time.Hour,
+			}
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}}
+			sink, err := newInfluxSink(config, output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.points; i++ {
+				reading := testReading(20, time.Duration(i)*time.Second)
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatalf("Write() = %v", err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+
+			requests, lines := server.lines()
+			if lines != tt.points {
+				t.Errorf("server received %d points, want %d", lines, tt.points)
+			}
+			if requests != tt.requests {
+				t.Errorf("server received %d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestValidateMeasurement(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"env", false},
+		{"living room", false},
+		{"a,b=c", false},
+		{"températures", false},
+		{"", true},
+		{"_internal", true},
+		{"env\nother", true},
+		{"env\x00", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateMeasurement(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateMeasurement(%q) = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestInfluxSinkWritesToMeasurement(t *testing.T) {
+	tests := []struct {
+		measurement string
+		// Start of the line written, with the measurement escaped
+		wantLine string
+	}{
+		{"env", "env,"},
+		{"living room", `living\ room,`},
+		{"a,b=c", `a\,b=c,`},
+	}
+	for _, tt := range tests {
+		measurement := tt.measurement
+		t.Run(measurement, func(t *testing.T) {
+			writeAPI := &fakeWriteAPI{}
+			sink := newFakeInfluxSink(writeAPI)
+			sink.measurement = measurement
+			reading := testReading(20, 0)
+			if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+				t.Fatal(err)
+			}
+			if len(writeAPI.written) != 1 {
+				t.Fatalf("wrote %d points, want 1", len(writeAPI.written))
+			}
+			if got := writeAPI.written[0].Name(); got != measurement {
+				t.Errorf("point measurement = %q, want %q", got, measurement)
+			}
+			if line := write.PointToLineProtocol(writeAPI.written[0], time.Second); !strings.HasPrefix(line, tt.wantLine) {
+				t.Errorf("line = %q, want it to start with %q", line, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestInfluxPrecision(t *testing.T) {
+	tests := []struct {
+		precision string
+		// Timestamp written for a reading at 1.234567891s past `testTime`
+		want string
+	}{
+		{"s", "1704110401"},
+		{"ms", "1704110401234"},
+		{"us", "1704110401234567"},
+		{"ns", "1704110401234567891"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.precision, func(t *testing.T) {
+			precision, err := ParseInfluxPrecision(tt.precision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := newInfluxServer(t)
+			config := InfluxConfig{
+				Version:     2,
+				URL:         server.URL,
+				Org:         "org",
+				Bucket:      "bucket",
+				Measurement: "environment",
+				Precision:   precision,
+			}
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}}
+			sink, err := newInfluxSink(config, output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reading := testReading(20, 1234567891*time.Nanosecond)
+			if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+				t.Fatal(err)
+			}
+			sink.Close()
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if len(server.requests) != 1 {
+				t.Fatalf("server received %d requests, want 1", len(server.requests))
+			}
+			if got := server.precisions[0]; got != tt.precision {
+				t.Errorf("precision = %q, want %q", got, tt.precision)
+			}
+			if line := server.requests[0][0]; !strings.HasSuffix(line, " "+tt.want) {
+				t.Errorf("line = %q, want timestamp %s", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInfluxPrecision(t *testing.T) {
+	for _, value := range []string{"", "m", "seconds", "NS"} {
+		if _, err := ParseInfluxPrecision(value); err == nil {
+			t.Errorf("ParseInfluxPrecision(%q) = nil error, want one", value)
+		}
+	}
+}
+
+func TestRawSinkWritesToRawMeasurement(t *testing.T) {
+	server := newInfluxServer(t)
+	config := testConfig()
+	config.DryRun = false
+	config.Influx = InfluxConfig{Version: 2, URL: server.URL, Org: "org", Bucket: "bucket", Measurement: "environment", Precision: time.Second}
+	config.RawMeasurement = "environment_raw"
+	sink, err := newRawSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading := testReading(20, 0)
+	if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.requests) != 1 {
+		t.Fatalf("server received %d requests, want 1", len(server.requests))
+	}
+	if line := server.requests[0][0]; !strings.HasPrefix(line, "environment_raw,") {
+		t.Errorf("line = %q, want one in environment_raw", line)
+	}
+}
+
+func TestRawSinkDryRun(t *testing.T) {
+	config := testConfig()
+	config.RawMeasurement = "environment_raw"
+	sink, err := newRawSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if _, ok := sink.(*NoopSink); !ok {
+		t.Errorf("raw sink for a dry run is %T, want *NoopSink", sink)
+	}
+}
+
+func TestInfluxHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(emptyFile, []byte("not a certificate\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		config    InfluxConfig
+		path      string
+		clientErr bool
+		requestOK bool
+	}{
+		{"untrusted certificate", InfluxConfig{}, "/", false, false},
+		{"insecure", InfluxConfig{Insecure: true}, "/", false, true},
+		{"trusted by the CA file", InfluxConfig{CAFile: caFile}, "/", false, true},
+		{"missing CA file", InfluxConfig{CAFile: filepath.Join(dir, "missing.pem")}, "/", true, false},
+		{"CA file without certificates", InfluxConfig{CAFile: emptyFile}, "/", true, false},
+		{"within the timeout", InfluxConfig{Insecure: true, Timeout: 5 * time.Second}, "/", false, true},
+		{"timed out", InfluxConfig{Insecure: true, Timeout: 50 * time.Millisecond}, "/slow", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newInfluxHTTPClient(tt.config)
+			if (err != nil) != tt.clientErr {
+				t.Fatalf("newInfluxHTTPClient() error = %v, want error %v", err, tt.clientErr)
+			}
+			if err != nil {
+				return
+			}
+			response, err := client.Get(server.URL + tt.path)
+			if err == nil {
+				response.Body.Close()
+			}
+			if (err == nil) != tt.requestOK {
+				t.Errorf("request error = %v, want success %v", err, tt.requestOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// InfluxV1Sink writes readings as points in an InfluxDB 1.x database, using
+// the same points as `InfluxSink`
+type InfluxV1Sink struct {
+	client      *http.Client
+	writeURL    string
+	username    string
+	password    string
+	measurement string
+	precision   time.Duration
+	output      OutputConfig
+}
+
+// Names of the timestamp precisions in the InfluxDB 1.x API
+var influxV1Precisions = map[time.Duration]string{
+	time.Second:      "s",
+	time.Millisecond: "ms",
+	time.Microsecond: "u",
+	time.Nanosecond:  "ns",
+}
+
+func newInfluxV1Sink(config InfluxConfig, output OutputConfig) (*InfluxV1Sink, error) {
+	if config.Database == "" {
+		return nil, fmt.Errorf("the influx sink needs a database for InfluxDB 1.x, set with -influx_database")
+	}
+
+	writeURL, err := url.Parse(strings.TrimSuffix(config.URL, "/") + "/write")
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q: %v", config.URL, err)
+	}
+	writeURL.RawQuery = url.Values{"db": {config.Database}, "precision": {influxV1Precisions[config.Precision]}}.Encode()
+	client, err := newInfluxHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InfluxV1Sink{
+		client:      client,
+		writeURL:    writeURL.String(),
+		username:    config.Username,
+		password:    config.Password,
+		measurement: config.Measurement,
+		precision:   config.Precision,
+		output:      output,
+	}, nil
+}
+
+func (s *InfluxV1Sink) newRequest(ctx context.Context, reading Reading, t time.Time) (*http.Request, error) {
+	// Build the request to the server's /write endpoint that writes `reading`
+	// as line protocol, with the time truncated to the precision as the
+	// InfluxDB 2.x sink does
+
+	line := write.PointToLineProtocol(newInfluxPoint(reading, t.Truncate(s.precision), s.measurement, s.output), s.precision)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, strings.NewReader(line))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return req, nil
+}
+
+func (s *InfluxV1Sink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	req, err := s.newRequest(ctx, reading, t)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The server responds with 204 No Content once the point is written
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("InfluxDB responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *InfluxV1Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
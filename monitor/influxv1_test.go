@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxV1Request(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    InfluxConfig
+		wantURL   string
+		wantAuth  bool
+		wantLine  string
+		wantError bool
+	}{
+		{
+			"with credentials",
+			InfluxConfig{URL: "http://influx:8086", Database: "env", Username: "monitor", Password: "secret", Measurement: "environment", Precision: time.Second},
+			"http://influx:8086/write?db=env&precision=s",
+			true,
+			"environment,pressure_unit=hpa,temp_unit=c temp=20 1704110401",
+			false,
+		},
+		{
+			"without credentials",
+			InfluxConfig{URL: "http://influx:8086/", Database: "env", Measurement: "env", Precision: time.Millisecond},
+			"http://influx:8086/write?db=env&precision=ms",
+			false,
+			"env,pressure_unit=hpa,temp_unit=c temp=20 1704110401500",
+			false,
+		},
+		{
+			"no database",
+			InfluxConfig{URL: "http://influx:8086", Measurement: "environment", Precision: time.Second},
+			"", false, "", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Version = 1
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}}
+			sink, err := newInfluxV1Sink(tt.config, output)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("newInfluxV1Sink() error = %v, want error %v", err, tt.wantError)
+			}
+			if err != nil {
+				return
+			}
+
+			reading := testReading(20, 1500*time.Millisecond)
+			reading.HasHumidity = false
+			req, err := sink.newRequest(context.Background(), reading, reading.Time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != "POST" {
+				t.Errorf("method = %s, want POST", req.Method)
+			}
+			want, _ := url.Parse(tt.wantURL)
+			if req.URL.Scheme+"://"+req.URL.Host+req.URL.Path != want.Scheme+"://"+want.Host+want.Path || req.URL.Query().Encode() != want.Query().Encode() {
+				t.Errorf("URL = %s, want %s", req.URL, tt.wantURL)
+			}
+			username, password, ok := req.BasicAuth()
+			if ok != tt.wantAuth || ok && (username != tt.config.Username || password != tt.config.Password) {
+				t.Errorf("basic auth = %q, %q, %v, want %q, %q, %v", username, password, ok, tt.config.Username, tt.config.Password, tt.wantAuth)
+			}
+			body, _ := io.ReadAll(req.Body)
+			if got := strings.TrimSpace(string(body)); got != tt.wantLine {
+				t.Errorf("body = %q, want %q", got, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestInfluxV1Write(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{"written", http.StatusNoContent, ""},
+		{"unauthorized", http.StatusUnauthorized, "authorization failed"},
+		{"missing database", http.StatusNotFound, "database not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.wantErr)
+			}))
+			defer server.Close()
+			config := InfluxConfig{Version: 1, URL: server.URL, Database: "env", Measurement: "environment", Precision: time.Second}
+			sink, err := newInfluxV1Sink(config, OutputConfig{Fields: Fields{Temperature: true}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+
+			reading := testReading(20, 0)
+			err = sink.Write(context.Background(), reading, reading.Time)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Write() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Write() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
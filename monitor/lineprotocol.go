@@ -0,0 +1,58 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+type LineProtocolConfig struct {
+	Path string
+}
+
+// LineProtocolSink appends readings to a file as InfluxDB line protocol, the
+// same points the influx sink would write, so they can be imported later
+// with `influx write`
+type LineProtocolSink struct {
+	file        *os.File
+	measurement string
+	precision   time.Duration
+	output      OutputConfig
+}
+
+func newLineProtocolSink(config LineProtocolConfig, influx InfluxConfig, output OutputConfig) (*LineProtocolSink, error) {
+	// Open the file at `config.Path` for appending. Points are written to
+	// `influx.Measurement` with timestamps in `influx.Precision`, as by the
+	// influx sink.
+
+	if config.Path == "" {
+		return nil, fmt.Errorf("the lineprotocol sink needs a file, set with -lineprotocol_path")
+	}
+	file, err := os.OpenFile(config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &LineProtocolSink{
+		file:        file,
+		measurement: influx.Measurement,
+		precision:   influx.Precision,
+		output:      output,
+	}, nil
+}
+
+func (s *LineProtocolSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	line := write.PointToLineProtocol(newInfluxPoint(reading, t.Truncate(s.precision), s.measurement, s.output), s.precision)
+	_, err := s.file.WriteString(line)
+	return err
+}
+
+func (s *LineProtocolSink) Close() error {
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
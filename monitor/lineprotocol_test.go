@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lineprotocol "github.com/influxdata/line-protocol"
+)
+
+func TestLineProtocolSink(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement string
+		fields      Fields
+		label       string
+	}{
+		{"all fields", "env", Fields{Temperature: true, Pressure: true, Humidity: true}, ""},
+		{"temperature only", "env", Fields{Temperature: true}, ""},
+		{"escaped names", "living room", Fields{Temperature: true, Pressure: true}, "north, wall"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readings.lp")
+			output := OutputConfig{TemperatureUnit: Fahrenheit, PressureUnit: Hectopascal, Fields: tt.fields, Host: "pi", Location: "kitchen"}
+			influx := InfluxConfig{Measurement: tt.measurement, Precision: time.Second}
+			sink, err := newLineProtocolSink(LineProtocolConfig{Path: path}, influx, output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var readings []Reading
+			for i := 0; i < 3; i++ {
+				reading := testReading(float64(20+i), time.Duration(i)*time.Minute+500*time.Millisecond)
+				reading.HasHumidity = tt.fields.Humidity
+				reading.Label = tt.label
+				readings = append(readings, reading)
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The lines parse back to the points the influx sink would send
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			parser := lineprotocol.NewStreamParser(file)
+			parser.SetTimePrecision(time.Second)
+			for i, reading := range readings {
+				got, err := parser.Next()
+				if err != nil {
+					t.Fatalf("line %d: %v", i+1, err)
+				}
+				want := newInfluxPoint(reading, reading.Time.Truncate(time.Second), tt.measurement, output)
+				if got.Name() != want.Name() {
+					t.Errorf("line %d measurement = %q, want %q", i+1, got.Name(), want.Name())
+				}
+				if !got.Time().Equal(want.Time()) {
+					t.Errorf("line %d time = %v, want %v", i+1, got.Time(), want.Time())
+				}
+				gotTags := map[string]string{}
+				for _, tag := range got.TagList() {
+					gotTags[tag.Key] = tag.Value
+				}
+				for _, tag := range want.TagList() {
+					if gotTags[tag.Key] != tag.Value {
+						t.Errorf("line %d tag %s = %q, want %q", i+1, tag.Key, gotTags[tag.Key], tag.Value)
+					}
+				}
+				if len(gotTags) != len(want.TagList()) {
+					t.Errorf("line %d tags = %v, want %d of them", i+1, gotTags, len(want.TagList()))
+				}
+				gotFields := map[string]interface{}{}
+				for _, field := range got.FieldList() {
+					gotFields[field.Key] = field.Value
+				}
+				for _, field := range want.FieldList() {
+					if gotFields[field.Key] != field.Value {
+						t.Errorf("line %d field %s = %v, want %v", i+1, field.Key, gotFields[field.Key], field.Value)
+					}
+				}
+				if len(gotFields) != len(want.FieldList()) {
+					t.Errorf("line %d fields = %v, want %d of them", i+1, gotFields, len(want.FieldList()))
+				}
+			}
+			if _, err := parser.Next(); err != lineprotocol.EOF {
+				t.Errorf("after the last line, Next() = %v, want EOF", err)
+			}
+		})
+	}
+}
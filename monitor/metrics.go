@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"periph.io/x/conn/v3/physic"
+)
+
+// Prometheus collectors. These are only exported once `registerMetrics` has
+// been called, but are always safe to update.
+var (
+	// The latest values read, labelled with the sensor's label, which is ""
+	// when only one sensor is in use
+	temperatureGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "environmentmonitor_temperature_celsius",
+		Help: "Latest temperature read from the sensor.",
+	}, []string{"sensor"})
+	pressureGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "environmentmonitor_pressure_pascals",
+		Help: "Latest pressure read from the sensor.",
+	}, []string{"sensor"})
+	humidityGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "environmentmonitor_humidity_percent",
+		Help: "Latest relative humidity read from the sensor.",
+	}, []string{"sensor"})
+	samplesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_samples_read_total",
+		Help: "Number of samples read from the sensor.",
+	})
+	outliersRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_outliers_rejected_total",
+		Help: "Number of samples dropped for being too far from the mean of their averaging window.",
+	})
+	missedReadings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_missed_readings_total",
+		Help: "Number of times a sensor was read more than 1.5 intervals after its previous reading.",
+	})
+	droppedReadings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_dropped_readings_total",
+		Help: "Number of readings dropped because averaging and writing couldn't keep up.",
+	})
+	droppedRawReadings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_dropped_raw_readings_total",
+		Help: "Number of raw readings dropped because the raw sink couldn't keep up.",
+	})
+	sensorReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_sensor_reconnects_total",
+		Help: "Number of attempts to reopen a sensor after a failed read.",
+	})
+	sinkWrites = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_sink_writes_total",
+		Help: "Number of successful writes to the sink.",
+	})
+	seriesRefused = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_series_refused_total",
+		Help: "Number of points dropped because their tags would have exceeded the maximum number of series.",
+	})
+	sinkWriteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_sink_write_failures_total",
+		Help: "Number of failed writes to the sink, including retries.",
+	})
+	sinkWriteTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "environmentmonitor_sink_write_timeouts_total",
+		Help: "Number of writes to a sink given up on after its write timeout.",
+	})
+)
+
+func registerMetrics(registry *prometheus.Registry) {
+	registry.MustRegister(temperatureGauge, pressureGauge, humidityGauge,
+		samplesRead, outliersRejected, missedReadings, droppedReadings, droppedRawReadings, sensorReconnects, sinkWrites, sinkWriteFailures, sinkWriteTimeouts, seriesRefused)
+}
+
+func recordSample(reading Reading) {
+	// Update the sensor metrics with a freshly read sample
+
+	samplesRead.Inc()
+	temperatureGauge.WithLabelValues(reading.Label).Set(reading.Temperature.Celsius())
+	pressureGauge.WithLabelValues(reading.Label).Set(float64(reading.Pressure) / float64(physic.Pascal))
+	if reading.HasHumidity {
+		humidityGauge.WithLabelValues(reading.Label).Set(float64(reading.Humidity) / float64(physic.PercentRH))
+	}
+}
+
+func serveMetrics(ctx context.Context, addr string, registry *prometheus.Registry) {
+	// Serve the metrics in `registry` on `addr` at /metrics until `ctx` is
+	// cancelled
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	slog.Info("Serving metrics", "addr", addr, "path", "/metrics")
+	serveUntilDone(ctx, &http.Server{Addr: addr, Handler: mux}, "Metrics")
+}
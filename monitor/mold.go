@@ -0,0 +1,61 @@
+package monitor
+
+import (
+	"log/slog"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// MoldRiskConfig describes when sustained high humidity is reported as a
+// risk of mold growing
+type MoldRiskConfig struct {
+	// Relative humidity in % at or above which mold can grow, or 0 to not
+	// check
+	Humidity float64
+	// Length of time the humidity has to stay high for before there's a risk
+	Duration time.Duration
+}
+
+// moldRiskTracker works out whether the humidity of successive averages from
+// a sensor has been high for long enough to risk mold. It accumulates the
+// time spent at or above the threshold, less the time spent below it, so a
+// short dry spell delays the risk rather than starting the count again. The
+// risk is raised once the total reaches the duration, and cleared once it
+// has fallen back to 0.
+type moldRiskTracker struct {
+	// Time spent humid, between 0 and the duration
+	humid time.Duration
+	// Time of the previous average, or zero before the first one
+	last time.Time
+	risk bool
+}
+
+func (m *moldRiskTracker) update(reading *Reading, t time.Time, config MoldRiskConfig) {
+	// Mark `reading`, made at time `t`, as a mold risk or not. The humidity
+	// of each average is taken to have held since the one before it. Readings
+	// from sensors without humidity are left unmarked.
+
+	if config.Humidity <= 0 || !reading.HasHumidity {
+		return
+	}
+	if !m.last.IsZero() {
+		elapsed := t.Sub(m.last)
+		if float64(reading.Humidity)/float64(physic.PercentRH) >= config.Humidity {
+			m.humid = min(m.humid+elapsed, config.Duration)
+		} else {
+			m.humid = max(m.humid-elapsed, 0)
+		}
+	}
+	m.last = t
+
+	if !m.risk && m.humid >= config.Duration {
+		slog.Warn("Humidity high for long enough to risk mold", "sensor", reading.Label, "humidity", config.Humidity, "duration", config.Duration)
+		m.risk = true
+	} else if m.risk && m.humid <= 0 {
+		slog.Info("Mold risk cleared", "sensor", reading.Label)
+		m.risk = false
+	}
+	risk := m.risk
+	reading.MoldRisk = &risk
+}
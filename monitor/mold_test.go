@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMoldRiskTracker(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		// Humidity of averages 30 minutes apart, in %
+		humidities  []float64
+		hasHumidity bool
+		// How each is marked: "" for not marked, "risk" or "none"
+		want []string
+	}{
+		{"not checked", 0, []float64{90, 90, 90}, true, []string{"", "", ""}},
+		{"no humidity measured", 70, []float64{90, 90, 90}, false, []string{"", "", ""}},
+		{"stays dry", 70, []float64{50, 60, 69}, true, []string{"none", "none", "none"}},
+		{"humid for the duration", 70, []float64{80, 70, 80, 80}, true, []string{"none", "none", "risk", "risk"}},
+		// A short dry spell takes time off the count instead of restarting it
+		{"dry spell delays the risk", 70, []float64{80, 80, 50, 80, 80}, true, []string{"none", "none", "none", "none", "risk"}},
+		{"cleared once dry for as long", 70, []float64{80, 80, 80, 50, 50, 80}, true, []string{"none", "none", "risk", "risk", "none", "none"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := MoldRiskConfig{Humidity: tt.threshold, Duration: time.Hour}
+			var tracker moldRiskTracker
+			var got []string
+			for i, humidity := range tt.humidities {
+				reading := testReading(20, time.Duration(i)*30*time.Minute)
+				reading.Humidity = percentRH(humidity)
+				reading.HasHumidity = tt.hasHumidity
+				tracker.update(&reading, reading.Time, config)
+				switch {
+				case reading.MoldRisk == nil:
+					got = append(got, "")
+				case *reading.MoldRisk:
+					got = append(got, "risk")
+				default:
+					got = append(got, "none")
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("marked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoldRiskIsWritten(t *testing.T) {
+	risk := true
+	tests := []struct {
+		name     string
+		fields   Fields
+		risk     *bool
+		wantSent bool
+	}{
+		{"marked", Fields{Temperature: true, Humidity: true}, &risk, true},
+		{"not marked", Fields{Temperature: true, Humidity: true}, nil, false},
+		{"humidity not written", Fields{Temperature: true}, &risk, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := testConfig().Output
+			output.Fields = tt.fields
+			reading := testReading(20, 0)
+			reading.MoldRisk = tt.risk
+
+			point := newInfluxPoint(reading, reading.Time, "environment", output)
+			inPoint := false
+			for _, field := range point.FieldList() {
+				if field.Key == "mold_risk" {
+					inPoint = field.Value == true
+				}
+			}
+			if inPoint != tt.wantSent {
+				t.Errorf("point has mold_risk = %v, want %v", inPoint, tt.wantSent)
+			}
+			record := newJSONRecord(reading, reading.Time, output)
+			if inRecord := record.MoldRisk != nil; inRecord != tt.wantSent {
+				t.Errorf("JSON record has mold_risk = %v, want %v", inRecord, tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,1388 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"periph.io/x/conn/v3/analog"
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/conn/v3/spi"
+	"periph.io/x/conn/v3/spi/spireg"
+	"periph.io/x/devices/v3/bmxx80"
+	"periph.io/x/host/v3"
+	"periph.io/x/host/v3/sysfs"
+)
+
+func getBus(name string) (i2c.BusCloser, error) {
+	// Open a handle to the I²C bus called `name`, or the first available bus
+	// if `name` is empty:
+	bus, err := i2creg.Open(name)
+	if err != nil {
+		// Buses are only registered when the drivers are first loaded, so a
+		// device node that appeared since has to be opened directly
+		if bus, sysfsErr := openSysfsBus(name); sysfsErr == nil {
+			return bus, nil
+		}
+		return nil, fmt.Errorf("could not open I²C bus %q (available: %s): %v", name, availableBuses(), err)
+	}
+
+	return bus, nil
+}
+
+func openSysfsBus(name string) (i2c.BusCloser, error) {
+	// Open the I²C bus called `name`, given as its number, e.g. 1, or its
+	// device node, e.g. /dev/i2c-1, or the lowest-numbered bus if `name` is
+	// empty, without going through the registry
+
+	number := -1
+	if name == "" {
+		nodes, _ := filepath.Glob("/dev/i2c-*")
+		for _, node := range nodes {
+			n, err := strconv.Atoi(strings.TrimPrefix(node, "/dev/i2c-"))
+			if err == nil && (number == -1 || n < number) {
+				number = n
+			}
+		}
+	} else if n, err := strconv.Atoi(strings.TrimPrefix(name, "/dev/i2c-")); err == nil {
+		number = n
+	}
+	if number < 0 {
+		return nil, fmt.Errorf("no I²C device node for %q", name)
+	}
+	return sysfs.NewI2C(number)
+}
+
+func availableBuses() string {
+	// Describe each registered I²C bus, along with its aliases, so the user can
+	// pick a valid one for the `-bus` flag
+
+	refs := i2creg.All()
+	if len(refs) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, describeBus(ref))
+	}
+	return strings.Join(names, ", ")
+}
+
+func describeBus(ref *i2creg.Ref) string {
+	// The name of the bus, followed by its aliases if it has any
+	name := ref.Name
+	if len(ref.Aliases) != 0 {
+		name += " (" + strings.Join(ref.Aliases, ", ") + ")"
+	}
+	return name
+}
+
+func getSPIPort(name string) (spi.PortCloser, error) {
+	// Open a handle to the SPI port called `name`, or the first available port
+	// if `name` is empty:
+	port, err := spireg.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not open SPI port %q (available: %s): %v", name, availableSPIPorts(), err)
+	}
+
+	return port, nil
+}
+
+func availableSPIPorts() string {
+	// Describe each registered SPI port, along with its aliases, so the user
+	// can pick a valid one for the `-bus` flag
+
+	refs := spireg.All()
+	if len(refs) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		name := ref.Name
+		if len(ref.Aliases) != 0 {
+			name += " (" + strings.Join(ref.Aliases, ", ") + ")"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func getDevice(bus i2c.BusCloser, address uint16, opts *bmxx80.Opts) (*bmxx80.Dev, error) {
+	// Open a handle to a bme280/bmp280 connected on the I²C bus at `address`
+	// using the oversampling and filter settings in `opts`:
+	dev, err := bmxx80.NewI2C(bus, address, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize a BME280/BMP280 at I²C address %#02x (%v)", address, err)
+	}
+
+	return dev, nil
+}
+
+func getSPIDevice(port spi.Port, opts *bmxx80.Opts) (*bmxx80.Dev, error) {
+	// Open a handle to a bme280/bmp280 connected to the SPI `port` using the
+	// oversampling and filter settings in `opts`:
+	dev, err := bmxx80.NewSPI(port, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize a BME280/BMP280 on SPI port %s (%v), check its wiring and that SPI is enabled", port, err)
+	}
+
+	return dev, nil
+}
+
+func retryInit(clock Clock, init func() error, retries int, delay time.Duration) error {
+	// Call `init`, retrying up to `retries` times if it fails. This happens
+	// when the monitor starts before the kernel's I²C module is loaded during
+	// boot. The delay between attempts starts at `delay` and doubles after
+	// each failure.
+
+	for attempt := 0; ; attempt++ {
+		err := init()
+		if err == nil || attempt >= retries {
+			return err
+		}
+		slog.Warn("Could not load the drivers, retrying", "delay", delay, "error", err)
+		clock.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func sensorOpener(config Config) (open func(bus string, address uint16) (Sensor, error), closeBus func(), err error) {
+	// Choose how sensors are opened: as mocks, on the SPI port given in
+	// `config`, or on the named I²C bus, or `config.BusName` if the name is
+	// empty. Each I²C bus is opened along with its first sensor, so a bus
+	// that can't be opened only affects the sensors on it. `closeBus`
+	// releases the buses once the sensors are halted.
+
+	if config.Mock {
+		open = func(string, uint16) (Sensor, error) {
+			mock := newMockSensor(config.MockReadings, config.Clock)
+			mock.gas = config.SensorModel == "bme680"
+			return mock, nil
+		}
+		return open, func() {}, nil
+	}
+
+	// Load all the drivers:
+	hostInit := func() error {
+		_, err := host.Init()
+		return err
+	}
+	if err := retryInit(config.Clock, hostInit, config.InitRetries, config.InitRetryDelay); err != nil {
+		return nil, nil, fmt.Errorf("could not load the drivers after %d attempts: %w", config.InitRetries+1, err)
+	}
+
+	if config.Interface == "spi" {
+		// An SPI port has a single sensor, so there's no address
+		port, err := getSPIPort(config.BusName)
+		if err != nil {
+			return nil, nil, err
+		}
+		open = func(string, uint16) (Sensor, error) {
+			dev, err := getSPIDevice(port, &config.SensorOpts)
+			if err != nil {
+				return nil, err
+			}
+			return dev, nil
+		}
+		return open, func() { port.Close() }, nil
+	}
+
+	buses := &openBuses{buses: map[string]i2c.BusCloser{}}
+	open = func(name string, address uint16) (Sensor, error) {
+		if name == "" {
+			name = config.BusName
+		}
+		bus, err := buses.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if config.SensorModel == "bme680" {
+			return newBME680(bus, address, &config.SensorOpts)
+		}
+		dev, err := getDevice(bus, address, &config.SensorOpts)
+		if err != nil {
+			return nil, err
+		}
+		return dev, nil
+	}
+	return open, buses.close, nil
+}
+
+// openBuses holds the I²C buses opened so far, keyed by name. Sensors on
+// different buses are reopened concurrently, so it's safe for concurrent use.
+type openBuses struct {
+	mu    sync.Mutex
+	buses map[string]i2c.BusCloser
+}
+
+func (b *openBuses) get(name string) (i2c.BusCloser, error) {
+	// The bus called `name`, opening it if it isn't already
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bus, ok := b.buses[name]; ok {
+		return bus, nil
+	}
+	bus, err := getBus(name)
+	if err != nil {
+		return nil, err
+	}
+	b.buses[name] = bus
+	return bus, nil
+}
+
+func (b *openBuses) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, bus := range b.buses {
+		bus.Close()
+	}
+}
+
+func startSensors(config Config) (sensors []sensorReader, closeBus func(), err error) {
+	// Load the drivers, open the bus and open the sensors. With
+	// `config.StartupWait`, these steps are retried together until every
+	// sensor opens or the wait is over, as on some boards the I²C device node
+	// only appears a few seconds after boot. The sensors that can be opened
+	// are then used as usual.
+
+	start := func(strict bool) error {
+		open, closeOpened, err := sensorOpener(config)
+		if err != nil {
+			return err
+		}
+		opened, err := openSensors(open, config, strict)
+		if err != nil {
+			closeOpened()
+			return err
+		}
+		sensors, closeBus = opened, closeOpened
+		return nil
+	}
+
+	if config.StartupWait <= 0 {
+		err = start(false)
+		return sensors, closeBus, err
+	}
+
+	// The whole chain is retried, so loading the drivers isn't retried on
+	// its own as well
+	config.InitRetries = 0
+	err = waitForStartup(config.Clock, config.StartupWait, startupRetryDelay, func() error { return start(true) })
+	if err == nil {
+		return sensors, closeBus, nil
+	}
+	slog.Warn("Not every sensor was ready in time", "wait", config.StartupWait, "error", err)
+	err = start(false)
+	return sensors, closeBus, err
+}
+
+// Delay between attempts to start the sensors within `-startup_wait`
+const startupRetryDelay = time.Second
+
+func waitForStartup(clock Clock, wait, delay time.Duration, start func() error) error {
+	// Call `start` until it succeeds, waiting `delay` between attempts, or
+	// until the next attempt would begin more than `wait` after the first.
+
+	deadline := clock.Now().Add(wait)
+	for attempt := 1; ; attempt++ {
+		err := start()
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("Sensors ready", "attempts", attempt)
+			}
+			return nil
+		}
+		if clock.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("Sensors not ready, retrying", "attempt", attempt, "delay", delay, "error", err)
+		clock.Sleep(delay)
+	}
+}
+
+// A Reading is a set of values from the sensor, or an average of several
+type Reading struct {
+	physic.Env
+	// False for sensors that don't measure humidity, such as the BMP280, in
+	// which case `Humidity` is meaningless and isn't recorded
+	HasHumidity bool
+	// Label of the sensor the reading came from, empty when only one sensor
+	// is in use
+	Label string
+	// Spread of the raw readings that were averaged, or nil for raw readings
+	// and moving averages
+	Stats *WindowStats
+	// Change of each field per minute since the sensor's previous average,
+	// or nil for raw readings and the first average
+	Rate *physic.Env
+	// Whether the pressure is falling quickly, or nil for raw readings and
+	// when it isn't checked
+	PressureFalling *bool
+	// Whether the humidity has been high for long enough to risk mold, or
+	// nil for raw readings, sensors without humidity and when it isn't
+	// checked
+	MoldRisk *bool
+	// Supply voltage read at the time of the reading, or for averages the
+	// latest one read, or nil if it isn't measured or couldn't be read
+	Voltage *physic.ElectricPotential
+	// Gas resistance measured by a BME680, or for window averages the mean
+	// of those measured, or nil for other sensors or if it wasn't valid
+	Gas *physic.ElectricResistance
+	// When the sensor was read. For window averages, the time of the window's
+	// last reading, or the midpoint of its first and last, depending on the
+	// timestamp mode. For moving averages, the time of the latest reading.
+	Time time.Time
+	// Position of the reading among those taken from its sensor, counting
+	// from 1, so missing readings show up as gaps. For averages, that of the
+	// latest reading.
+	Seq uint64
+}
+
+func (r Reading) String() string {
+	values := fmt.Sprintf("%8s %10s", r.Temperature, r.Pressure)
+	if r.HasHumidity {
+		values += fmt.Sprintf(" %9s", r.Humidity)
+	}
+	if r.Label != "" {
+		values = r.Label + ": " + values
+	}
+	return values
+}
+
+func (r Reading) LogValue() slog.Value {
+	// Log readings as a group of their values, e.g.
+	// reading.temperature=21.5°C reading.pressure=101.3kPa
+
+	attrs := []slog.Attr{
+		slog.String("temperature", r.Temperature.String()),
+		slog.String("pressure", r.Pressure.String()),
+	}
+	if r.HasHumidity {
+		attrs = append(attrs, slog.String("humidity", r.Humidity.String()))
+	}
+	if r.Label != "" {
+		attrs = append(attrs, slog.String("sensor", r.Label))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func mergeReadings(inputs []<-chan Reading, buffer int) <-chan Reading {
+	// Forward the values from each of `inputs` to a single channel, which is
+	// closed once all of `inputs` are closed. It can queue `buffer` values
+	// from each input.
+
+	merged := make(chan Reading, len(inputs)*buffer)
+	var wg sync.WaitGroup
+	for _, input := range inputs {
+		wg.Add(1)
+		go func(input <-chan Reading) {
+			defer wg.Done()
+			for reading := range input {
+				merged <- reading
+			}
+		}(input)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
+
+// readTimeoutError is returned when a sensor doesn't respond within the read
+// timeout. The read carries on in the background, and `done` is closed once
+// the driver returns.
+type readTimeoutError struct {
+	timeout time.Duration
+	done    <-chan struct{}
+}
+
+func (e *readTimeoutError) Error() string {
+	return fmt.Sprintf("no response after %s", e.timeout)
+}
+
+func senseWithTimeout(clock Clock, dev Sensor, env *physic.Env, timeout time.Duration) error {
+	// Read `dev` into `env`, giving up with a *readTimeoutError after
+	// `timeout`, unless it's 0. The driver is called from its own goroutine,
+	// which reads into its own copy of the values, so an abandoned read can't
+	// change `env` later, and never blocks, so it exits once the driver
+	// returns.
+
+	if timeout <= 0 {
+		return dev.Sense(env)
+	}
+
+	var result physic.Env
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errs <- dev.Sense(&result)
+	}()
+
+	timer := clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errs:
+		*env = result
+		return err
+	case <-timer.C():
+		return &readTimeoutError{timeout, done}
+	}
+}
+
+func (s *runState) readSensor(dev Sensor, label string, hasHumidity bool, voltage *physic.ElectricPotential, seq uint64, bounds Bounds, timeout time.Duration) (Reading, bool, error) {
+	// Read temperature from the sensor, recording `voltage` and the sequence
+	// number `seq` with it, giving up after `timeout` if it's set. Readings
+	// outside `bounds` are logged and dropped instead of being averaged,
+	// returning false.
+	end := func(error) {}
+	if s.telemetry != nil {
+		_, end = s.telemetry.startRead(context.Background(), label)
+	}
+	reading := Reading{HasHumidity: hasHumidity, Label: label, Voltage: voltage, Time: s.clock.Now(), Seq: seq}
+	err := senseWithTimeout(s.clock, dev, &reading.Env, timeout)
+	end(err)
+	if err != nil {
+		return reading, false, err
+	}
+	if err := bounds.check(reading); err != nil {
+		slog.Warn("Dropping implausible reading", "sensor", dev, "reading", reading, "error", err)
+		return reading, false, nil
+	}
+	if gs, ok := dev.(gasSensor); ok {
+		if gas, valid := gs.Gas(); valid {
+			reading.Gas = &gas
+		}
+	}
+	slog.Debug("Read sample", "reading", reading)
+	recordSample(reading)
+	s.health.recordRead(reading.Time)
+	return reading, true, nil
+}
+
+func pollInterval(ctx context.Context, clock Clock, callable func(), interval *atomic.Int64, jitter time.Duration, reloaded <-chan struct{}, bus string) {
+	// Call `callable` every `interval` until `ctx` is cancelled. Each call is
+	// delayed by a random time of up to `jitter`, so that monitors with the
+	// same interval don't all write at once. When `reloaded` fires, polling
+	// continues at the interval then stored. `bus` names the sensors being
+	// polled in log messages.
+
+	current := time.Duration(interval.Load())
+	ticker := clock.NewTicker(current)
+	defer ticker.Stop()
+	slog.Info("Polling sensors", "bus", bus, "interval", current, "jitter", jitter)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloaded:
+			if next := time.Duration(interval.Load()); next != current {
+				current = next
+				ticker.Reset(current)
+				slog.Info("Polling sensors", "bus", bus, "interval", current, "jitter", jitter)
+			}
+		case t := <-ticker.C():
+			slog.Debug("Tick", "bus", bus, "time", t)
+			if jitter > 0 {
+				delay := clock.NewTimer(jitterDelay(jitter))
+				select {
+				case <-ctx.Done():
+					delay.Stop()
+					return
+				case <-delay.C():
+				}
+			}
+			callable()
+		}
+	}
+}
+
+func busGroups(sensors []sensorReader) [][]*sensorReader {
+	// Group `sensors` by the bus they're on, in the order the buses first
+	// appear, so each bus can be polled separately
+
+	var groups [][]*sensorReader
+	index := map[string]int{}
+	for i := range sensors {
+		sensor := &sensors[i]
+		g, ok := index[sensor.bus]
+		if !ok {
+			g = len(groups)
+			index[sensor.bus] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], sensor)
+	}
+	return groups
+}
+
+func intervalGroups(sensors []*sensorReader) [][]*sensorReader {
+	// Group `sensors` by their read interval, in the order the intervals
+	// first appear, so each interval can be polled on its own ticker
+
+	var groups [][]*sensorReader
+	index := map[time.Duration]int{}
+	for _, sensor := range sensors {
+		g, ok := index[sensor.interval]
+		if !ok {
+			g = len(groups)
+			index[sensor.interval] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], sensor)
+	}
+	return groups
+}
+
+func pollBus(ctx context.Context, sensors []*sensorReader, readVoltage func() *physic.ElectricPotential, interval *atomic.Int64, reloaded <-chan struct{}, config Config) (gaveUp bool) {
+	// Read `sensors`, which share a bus, every `interval`, or their own
+	// interval if they have one, until `ctx` is cancelled, or until one of
+	// them fails `config.MaxReadFailures` times in a row, returning true if
+	// it stopped for that. Sensors on other buses carry on either way.
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failed atomic.Bool
+
+	// Sensors with different intervals are polled separately, but only one
+	// is read at a time so the bus isn't shared mid-read
+	bus := sensors[0].bus
+	var busMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, group := range intervalGroups(sensors) {
+		group := group
+		groupInterval, groupReloaded := interval, reloaded
+		if group[0].interval > 0 {
+			// Intervals set for a sensor aren't changed by reloading
+			groupInterval, groupReloaded = new(atomic.Int64), nil
+			groupInterval.Store(int64(group[0].interval))
+		}
+
+		read := func() {
+			busMu.Lock()
+			defer busMu.Unlock()
+			voltage := readVoltage()
+			maxGap := maxReadGap(time.Duration(groupInterval.Load()), config.Jitter)
+			for _, sensor := range group {
+				if ctx.Err() != nil {
+					// Another interval on this bus gave up
+					return
+				}
+				if !sensor.read(config.MaxReadFailures, config.StuckReads, config.Bounds, voltage, maxGap, config.ReadTimeout) {
+					slog.Error("Giving up on the sensors on this bus after consecutive failed reads", "bus", bus, "sensor", sensor.dev, "failures", config.MaxReadFailures)
+					failed.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pollInterval(ctx, config.Clock, read, groupInterval, config.Jitter, groupReloaded, bus)
+		}()
+	}
+	wg.Wait()
+	return failed.Load()
+}
+
+// ErrSensorsGaveUp is returned by Run when every sensor has failed to be read
+// too many times in a row
+var ErrSensorsGaveUp = errors.New("all sensors gave up after repeated read failures")
+
+func pollBuses(ctx context.Context, groups [][]*sensorReader, readVoltage func() *physic.ElectricPotential, interval *atomic.Int64, reloaded []chan struct{}, config Config) error {
+	// Poll each group of sensors in `groups`, which share a bus, with
+	// `pollBus` until `ctx` is cancelled, or until every bus has given up
+	// after failed reads, returning `ErrSensorsGaveUp` if it's the latter
+
+	var wg sync.WaitGroup
+	var gaveUp atomic.Int64
+	for i, group := range groups {
+		wg.Add(1)
+		go func(group []*sensorReader, reloaded <-chan struct{}) {
+			defer wg.Done()
+			if pollBus(ctx, group, readVoltage, interval, reloaded, config) {
+				gaveUp.Add(1)
+			}
+		}(group, reloaded[i])
+	}
+	wg.Wait()
+	if len(groups) > 0 && gaveUp.Load() == int64(len(groups)) {
+		return ErrSensorsGaveUp
+	}
+	return nil
+}
+
+func maxReadGap(interval, jitter time.Duration) time.Duration {
+	// The longest time expected between reads of a sensor, beyond which a
+	// tick was probably missed. Jitter can delay a read by up to `jitter`
+	// after the previous one was read early, so it's allowed for too.
+	return interval*3/2 + jitter
+}
+
+func jitterDelay(jitter time.Duration) time.Duration {
+	// A random delay, uniformly distributed between 0 and `jitter`
+	return time.Duration(rand.Int63n(int64(jitter) + 1))
+}
+
+type sensorReader struct {
+	dev   Sensor
+	label string
+	// Name of the I²C bus the sensor is on, or "" for the default one
+	bus string
+	// Time between reads and number of readings averaged, overriding the
+	// defaults if they're not 0
+	interval    time.Duration
+	window      int
+	hasHumidity bool
+	// Opens the sensor again after it's halted
+	reopen func() (Sensor, error)
+	// Raw readings from the sensor, to be averaged
+	logging chan Reading
+	// Which reading to drop when `logging` is full: "oldest" or "newest"
+	dropPolicy string
+	// Raw readings to be written unaveraged, or nil if they aren't
+	raw chan<- Reading
+	// Number of samples still to be discarded while the sensor warms up
+	warmup int
+	// Number of reads in a row that have failed
+	failures int
+	// Number of times the sensor has been reopened after a failed read, and
+	// how long to wait before the next attempt
+	reconnects     int
+	reconnectDelay time.Duration
+	nextReconnect  time.Time
+	// The last value read, and the number of reads in a row that have
+	// returned exactly the same value
+	last    physic.Env
+	repeats int
+	// Sequence number of the latest reading, and when it was taken
+	seq      uint64
+	lastRead time.Time
+	// Closed once a read that timed out returns, or nil if none is still
+	// running
+	hung <-chan struct{}
+	// Limit on the readings taken across all the sensors, or nil if there
+	// isn't one
+	samples *sampleLimit
+	// The run the sensor is read for
+	run *runState
+}
+
+// sampleLimit stops reading once `max` readings have been taken across all
+// the sensors. Each read reserves a reading beforehand, so reads on other
+// buses running at the same time can't take the total past `max`.
+type sampleLimit struct {
+	max int
+	// Called once the last reading has been taken
+	reached func()
+
+	mu       sync.Mutex
+	taken    int
+	reserved int
+}
+
+func newSampleLimit(max int, reached func()) *sampleLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &sampleLimit{max: max, reached: reached}
+}
+
+func (l *sampleLimit) reserve() bool {
+	// Reserve a reading for a read that's about to be made, returning false
+	// if the limit would be passed
+
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.taken+l.reserved >= l.max {
+		return false
+	}
+	l.reserved++
+	return true
+}
+
+func (l *sampleLimit) release(taken bool) {
+	// Finish a read made with a reservation, counting it if it took a
+	// reading, or freeing the reservation for another read if not
+
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.reserved--
+	if taken {
+		l.taken++
+	}
+	reached := taken && l.taken == l.max
+	l.mu.Unlock()
+	if reached {
+		l.reached()
+	}
+}
+
+// Bounds of the delay between attempts to reopen a sensor that keeps failing
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+func (s *sensorReader) read(maxFailures, stuckReads int, bounds Bounds, voltage *physic.ElectricPotential, maxGap, timeout time.Duration) bool {
+	// Read the sensor, logging any failure and trying to reconnect to it.
+	// Returns false once `maxFailures` reads in a row have failed, or never if
+	// `maxFailures` is 0.
+	// The sensor is reported as stuck once `stuckReads` reads in a row have
+	// returned the same value, unless `stuckReads` is 0.
+	// A warning is logged if more than `maxGap` has passed since the last
+	// successful read, as a reading was probably missed.
+	// Reads taking longer than `timeout` are abandoned as failed. Until an
+	// abandoned read returns, the sensor isn't touched, and further reads
+	// fail straight away.
+
+	if s.readInProgress() {
+		return s.timedOut(errors.New("an earlier read that timed out still hasn't returned"), maxFailures)
+	}
+	if s.warmup > 0 {
+		return s.discard(maxFailures, timeout)
+	}
+
+	if !s.samples.reserve() {
+		// Enough readings have been taken, and reading is stopping
+		return true
+	}
+	reading, ok, err := s.run.readSensor(s.dev, s.label, s.hasHumidity, voltage, s.seq+1, bounds, timeout)
+	s.samples.release(err == nil && ok)
+	if s.abandoned(err) {
+		return s.timedOut(err, maxFailures)
+	}
+	if err != nil {
+		return s.failed(err, maxFailures)
+	}
+	s.seq++
+	s.checkGap(reading.Time, maxGap)
+
+	s.failures = 0
+	s.reconnectDelay, s.nextReconnect = 0, time.Time{}
+	if !ok {
+		return true
+	}
+	s.queue(reading)
+	s.queueRaw(reading)
+	if stuckReads > 0 {
+		s.checkStuck(reading.Env, stuckReads)
+	}
+	return true
+}
+
+// Values accepted by the `-drop_policy` flag: which reading is dropped when
+// a sensor's readings can't be averaged as fast as they're read
+var DropPolicies = []string{"oldest", "newest"}
+
+func (s *sensorReader) queue(reading Reading) {
+	// Queue `reading` to be averaged without waiting, so a stalled sink can't
+	// hold up reads. When the queue is full, either the oldest reading in it
+	// or `reading` itself is dropped, according to `s.dropPolicy`.
+
+	select {
+	case s.logging <- reading:
+		return
+	default:
+	}
+	if s.dropPolicy == "oldest" {
+		select {
+		case old := <-s.logging:
+			s.dropped(old)
+		default:
+			// Averaging took a reading in the meantime
+		}
+		select {
+		case s.logging <- reading:
+			return
+		default:
+		}
+	}
+	s.dropped(reading)
+}
+
+func (s *sensorReader) queueRaw(reading Reading) {
+	// Queue `reading` for the raw sink, if there is one, dropping it rather
+	// than waiting if the raw sink is behind, so it can't hold up averaging
+
+	if s.raw == nil {
+		return
+	}
+	select {
+	case s.raw <- reading:
+	default:
+		slog.Warn("Raw sink can't keep up, dropping raw reading", "sensor", s.dev, "seq", reading.Seq)
+		droppedRawReadings.Inc()
+	}
+}
+
+func (s *sensorReader) dropped(reading Reading) {
+	slog.Warn("Averaging can't keep up, dropping reading", "sensor", s.dev, "seq", reading.Seq, "policy", s.dropPolicy)
+	droppedReadings.Inc()
+}
+
+func (s *sensorReader) checkGap(t time.Time, maxGap time.Duration) {
+	// Record that the sensor was read at `t`, warning if it's more than
+	// `maxGap` since the previous read, such as when a slow sink has held up
+	// the ticks in between
+
+	if !s.lastRead.IsZero() {
+		if gap := t.Sub(s.lastRead); gap > maxGap {
+			slog.Warn("Missed reading", "sensor", s.dev, "seq", s.seq, "gap", gap, "max_gap", maxGap)
+			missedReadings.Inc()
+		}
+	}
+	s.lastRead = t
+}
+
+func (s *sensorReader) abandoned(err error) bool {
+	// Whether `err` is from a read that timed out, in which case the sensor
+	// is left alone until the read returns
+
+	var timeoutErr *readTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		return false
+	}
+	s.hung = timeoutErr.done
+	return true
+}
+
+func (s *sensorReader) readInProgress() bool {
+	// Whether a read that timed out is still waiting for the driver
+
+	if s.hung == nil {
+		return false
+	}
+	select {
+	case <-s.hung:
+		s.hung = nil
+		slog.Info("Read that timed out has returned", "sensor", s.dev)
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *sensorReader) timedOut(err error, maxFailures int) bool {
+	// Log a read that timed out as failed. Unlike other failures, the sensor
+	// isn't reopened, as halting it would wait on the same bus as the read.
+
+	s.failures++
+	slog.Warn("Sensor read timed out", "sensor", s.dev, "failures", s.failures, "error", err)
+	return maxFailures == 0 || s.failures < maxFailures
+}
+
+func (s *sensorReader) failed(err error, maxFailures int) bool {
+	// Log a failed read and try to reconnect to the sensor. Returns false once
+	// `maxFailures` reads in a row have failed, or never if `maxFailures` is 0.
+
+	s.failures++
+	slog.Warn("Failed to read sensor", "sensor", s.dev, "failures", s.failures, "error", err)
+	s.reconnect()
+	return maxFailures == 0 || s.failures < maxFailures
+}
+
+func (s *sensorReader) discard(maxFailures int, timeout time.Duration) bool {
+	// Read a sample while the sensor warms up, without averaging it, as the
+	// first readings after power-on can be unreliable
+
+	var env physic.Env
+	err := senseWithTimeout(s.run.clock, s.dev, &env, timeout)
+	if s.abandoned(err) {
+		return s.timedOut(err, maxFailures)
+	}
+	if err != nil {
+		return s.failed(err, maxFailures)
+	}
+	s.failures = 0
+
+	s.warmup--
+	slog.Debug("Discarded warm-up sample", "reading", Reading{Env: env, HasHumidity: s.hasHumidity, Label: s.label}, "remaining", s.warmup)
+	if s.warmup == 0 {
+		slog.Info("Sensor warmed up", "sensor", s.dev)
+	}
+	return true
+}
+
+func (s *sensorReader) checkStuck(env physic.Env, stuckReads int) {
+	// Count the reads in a row that return `env`. A common failure of the
+	// BME280 is to return the same value forever, so once there have been
+	// `stuckReads` of them, the sensor is reported as unhealthy until its
+	// value changes.
+
+	if env != s.last {
+		if s.repeats >= stuckReads {
+			slog.Info("Sensor readings are changing again", "sensor", s.dev)
+			s.run.health.setStuck(s.label, false)
+		}
+		s.last, s.repeats = env, 1
+		return
+	}
+
+	s.repeats++
+	if s.repeats == stuckReads {
+		slog.Warn("Sensor readings are stuck", "sensor", s.dev, "reads", s.repeats, "reading", Reading{Env: env, HasHumidity: s.hasHumidity, Label: s.label})
+		s.run.health.setStuck(s.label, true)
+	}
+}
+
+func (s *sensorReader) reconnect() {
+	// Halt the sensor and open it again, in case it was briefly disconnected.
+	// While attempts keep failing, the delay between them doubles, up to
+	// `maxReconnectDelay`.
+
+	now := s.run.clock.Now()
+	if now.Before(s.nextReconnect) {
+		return
+	}
+
+	s.reconnects++
+	sensorReconnects.Inc()
+	if err := s.dev.Halt(); err != nil {
+		slog.Debug("Failed to halt sensor", "sensor", s.dev, "error", err)
+	}
+	dev, err := s.reopen()
+
+	s.reconnectDelay = min(max(2*s.reconnectDelay, minReconnectDelay), maxReconnectDelay)
+	s.nextReconnect = now.Add(s.reconnectDelay)
+	if err != nil {
+		slog.Warn("Failed to reconnect to sensor", "label", s.label, "attempts", s.reconnects, "retry_in", s.reconnectDelay, "error", err)
+		return
+	}
+	s.dev = dev
+	slog.Info("Reconnected to sensor", "sensor", dev, "attempts", s.reconnects)
+}
+
+func openSensors(open func(bus string, address uint16) (Sensor, error), config Config, strict bool) ([]sensorReader, error) {
+	// Open each sensor in `config.Sensors` using `open`, or the single sensor
+	// at `config.I2CAddress` if none are listed. Sensors that fail to
+	// initialize are skipped, unless none can be opened, or with `strict`,
+	// any of them fails, in which case those already opened are halted.
+
+	configs := config.Sensors
+	if len(configs) == 0 {
+		configs = []SensorConfig{{Address: config.I2CAddress}}
+	}
+
+	sensors := []sensorReader{}
+	for _, c := range configs {
+		bus, address := c.Bus, c.Address
+		dev, err := open(bus, address)
+		if err != nil && strict {
+			for _, sensor := range sensors {
+				sensor.dev.Halt()
+			}
+			return nil, err
+		}
+		if err != nil {
+			slog.Error("Skipping sensor", "label", c.Label, "bus", bus, "error", err)
+			continue
+		}
+
+		hasHumidity := sensorHasHumidity(dev)
+		if !hasHumidity {
+			slog.Info("Sensor doesn't measure humidity, only temperature and pressure will be recorded", "sensor", dev)
+		}
+		// Humidity that isn't written is treated as not measured, so it's
+		// neither averaged nor written
+		hasHumidity = hasHumidity && config.Output.Fields.Humidity
+		sensors = append(sensors, sensorReader{
+			dev:         dev,
+			label:       c.Label,
+			bus:         bus,
+			interval:    c.ReadInterval,
+			window:      c.WindowSize,
+			hasHumidity: hasHumidity,
+			reopen:      func() (Sensor, error) { return open(bus, address) },
+			logging:     make(chan Reading, config.ChannelBuffer),
+			dropPolicy:  config.DropPolicy,
+			warmup:      config.WarmupSamples,
+		})
+	}
+
+	if len(sensors) == 0 {
+		return nil, errors.New("no sensors could be initialized")
+	}
+	return sensors, nil
+}
+
+func (s *runState) readOnce(sensors []sensorReader, voltagePin analog.PinADC, sink Sink, config Config) error {
+	// Read each sensor once and write its reading straight to `sink`, without
+	// averaging, for occasional sampling from cron or scripts, and to check
+	// the wiring and the sink on startup. Any warm-up samples are discarded
+	// first, so the sensors are warmed up afterwards.
+
+	voltage := readVoltage(voltagePin)
+	for i := range sensors {
+		sensor := &sensors[i]
+		for ; sensor.warmup > 0; sensor.warmup-- {
+			err := senseWithTimeout(s.clock, sensor.dev, &physic.Env{}, config.ReadTimeout)
+			if sensor.abandoned(err); err != nil {
+				return fmt.Errorf("could not read sensor %s: %w", sensor.dev, err)
+			}
+		}
+
+		reading, ok, err := s.readSensor(sensor.dev, sensor.label, sensor.hasHumidity, voltage, sensor.seq+1, config.Bounds, config.ReadTimeout)
+		if sensor.abandoned(err); err != nil {
+			return fmt.Errorf("could not read sensor %s: %w", sensor.dev, err)
+		}
+		sensor.seq++
+		sensor.lastRead = reading.Time
+		if !ok {
+			return fmt.Errorf("sensor %s returned an implausible reading", sensor.dev)
+		}
+
+		s.recordAverage(reading, reading.Time)
+		slog.Info("Writing point", "reading", reading)
+		if err := s.writeWithRetry(sink, reading, reading.Time, config.Write.WriteRetryMax, config.Write.WriteRetryBase); err != nil {
+			return fmt.Errorf("could not write reading: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *runState) averageReadings(config Config, logging <-chan Reading, windowSize *atomic.Int64) <-chan Reading {
+	// Start averaging the readings from `logging` as `config.AverageMode`
+	// says, returning the channel the averages are sent to. Windows of a
+	// fixed number of readings hold `windowSize` of them.
+
+	averaged := make(chan Reading, config.ChannelBuffer)
+	switch config.AverageMode {
+	case "ema":
+		go s.emaStream(config.EMAAlpha, config.Output.Fields, logging, averaged)
+	case "none":
+		go s.passthroughStream(config.Output.Fields, logging, averaged)
+	default:
+		go s.averageStream(windowSize, config.WindowDuration, config.WindowMode, config.Output.Fields, Aggregations[config.Aggregation], config.OutlierSigma, config.TimestampMode, logging, averaged)
+	}
+	return averaged
+}
+
+// runState is what one call to `Run` keeps while it runs: the clock it
+// reads the time from, what the HTTP endpoints serve, and the alerts and
+// telemetry readings are reported to. Each run has its own, so a program
+// embedding the monitor can run it again, or run several at once, without
+// one run's readings or health showing up in another's. The Prometheus
+// metrics are the exception, as they're registered once for the process.
+type runState struct {
+	clock   Clock
+	health  healthState
+	latest  latestReadings
+	history readingHistory
+	hub     readingHub
+	// Set when any alert thresholds are configured. While nil, averages
+	// aren't checked.
+	alerts *alerter
+	// Set when `-otel_endpoint` is given. While nil, reads and writes aren't
+	// traced or measured.
+	telemetry *otelInstruments
+	// How pressure trends and mold risk are detected. While their thresholds
+	// are 0, averages aren't marked either way.
+	pressureTrend PressureTrendConfig
+	moldRisk      MoldRiskConfig
+}
+
+func newRunState(config Config) *runState {
+	s := &runState{
+		clock:         config.Clock,
+		pressureTrend: config.PressureTrend,
+		moldRisk:      config.MoldRisk,
+	}
+	s.history.setSize(config.HistorySize)
+	return s
+}
+
+func Run(ctx context.Context, config Config) error {
+	// Read the sensors described by `config`, average their readings and
+	// write them to the configured sinks until `ctx` is cancelled, the sink
+	// fails or the sensors give up. With `config.Once`, each sensor is read
+	// and written once instead, and with `config.Replay.Path`, recorded
+	// readings are averaged and written instead of the sensors'. `config` is
+	// expected to be validated already, as the command-line flags are.
+	// Returns nil once the remaining readings are written after `ctx` is
+	// cancelled or `config.MaxSamples` are read. If the sink gives up after
+	// `config.Write.MaxWriteFailures`, its error is returned, wrapped as
+	// "stopped writing", and if every sensor gives up after
+	// `config.MaxReadFailures`, `ErrSensorsGaveUp` is returned.
+
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+	state := newRunState(config)
+	if config.Replay.Path != "" {
+		return state.replay(ctx, config)
+	}
+
+	// Set up bus and devices
+	sensors, closeBus, err := startSensors(config)
+	if err != nil {
+		return err
+	}
+	defer closeBus()
+	for i := range sensors {
+		sensors[i].run = state
+	}
+	haltSensors := func() {
+		// Sensors may have been reopened since they were opened. Those still
+		// stuck in a read are left alone, as halting them would block too.
+		for i := range sensors {
+			if !sensors[i].readInProgress() {
+				sensors[i].dev.Halt()
+			}
+		}
+	}
+	defer haltSensors()
+
+	voltagePin, closeVoltagePin, err := openVoltagePin(config)
+	if err != nil {
+		return fmt.Errorf("could not open the voltage pin %s: %w", config.VoltagePin, err)
+	}
+	defer closeVoltagePin()
+
+	sink, err := newSink(config)
+	if err != nil {
+		return fmt.Errorf("could not create the sink: %w", err)
+	}
+	var rawSink Sink
+	if config.RawMeasurement != "" && !config.Once {
+		if rawSink, err = newRawSink(config); err != nil {
+			sink.Close()
+			return fmt.Errorf("could not create the raw sink: %w", err)
+		}
+	}
+
+	if config.OTelEndpoint != "" {
+		telemetry, shutdown, err := setupOTel(ctx, config.OTelEndpoint, config.Clock)
+		if err != nil {
+			sink.Close()
+			return fmt.Errorf("could not set up OpenTelemetry: %w", err)
+		}
+		state.telemetry = telemetry
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), otelShutdownTimeout)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				slog.Error("Could not export telemetry", "error", err)
+			}
+		}()
+	}
+
+	if len(config.Alerts.Thresholds) > 0 {
+		alerts, stop := setupAlerts(config.Alerts, config.Output)
+		state.alerts = alerts
+		defer stop()
+	}
+
+	if config.Once {
+		err := state.readOnce(sensors, voltagePin, sink, config)
+		if closeErr := sink.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("one-shot read failed: %w", err)
+		}
+		return nil
+	}
+
+	// Check the wiring and the sink straight away, rather than only finding
+	// out after the first interval
+	if err := state.readOnce(sensors, voltagePin, sink, config); err != nil {
+		if config.FailFast {
+			sink.Close()
+			if rawSink != nil {
+				rawSink.Close()
+			}
+			return fmt.Errorf("self-test failed: %w", err)
+		}
+		slog.Error("Self-test failed, continuing anyway", "error", err)
+	} else {
+		slog.Info("Self-test passed")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if config.MetricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		registerMetrics(registry)
+		go serveMetrics(ctx, config.MetricsAddr, registry)
+	}
+	if config.HTTPAddr != "" {
+		go state.serveStatus(ctx, config)
+	}
+	if config.PprofAddr != "" {
+		go servePprof(ctx, config.PprofAddr)
+	}
+
+	// Average each sensor's readings separately, then merge them for the sink
+	windowSize := new(atomic.Int64)
+	windowSize.Store(int64(config.WindowSize))
+	averages := make([]<-chan Reading, 0, len(sensors))
+	for _, sensor := range sensors {
+		sensorWindow := windowSize
+		if sensor.window > 0 {
+			// Windows set for a sensor aren't changed by reloading
+			sensorWindow = new(atomic.Int64)
+			sensorWindow.Store(int64(sensor.window))
+		}
+		averages = append(averages, state.averageReadings(config, sensor.logging, sensorWindow))
+	}
+	averaged := mergeReadings(averages, config.ChannelBuffer)
+
+	// Log values from the channel to the sink. If it gives up, the error is
+	// kept to be returned once the pipeline has shut down.
+	written := make(chan struct{})
+	var writeErr error
+	go func() {
+		defer close(written)
+		defer closeSink(sink, "averages")
+		if err := state.logToSink(sink, config.Write, averaged); err != nil {
+			slog.Error("Stopped writing", "error", err)
+			writeErr = fmt.Errorf("stopped writing: %w", err)
+			// Stop reading, and discard the remaining averages so the
+			// pipeline can shut down
+			cancel()
+			for range averaged {
+			}
+		}
+	}()
+
+	// Write raw readings to their own sink as well, if there is one. A slow
+	// or failing raw sink drops raw readings rather than holding up reads.
+	var rawReadings chan Reading
+	rawWritten := make(chan struct{})
+	if rawSink == nil {
+		close(rawWritten)
+	} else {
+		rawReadings = make(chan Reading, config.ChannelBuffer*len(sensors))
+		for i := range sensors {
+			sensors[i].raw = rawReadings
+		}
+		go func() {
+			defer close(rawWritten)
+			defer closeSink(rawSink, "raw")
+			if err := state.logToSink(rawSink, config.Write, rawReadings); err != nil {
+				slog.Error("Stopped writing raw readings", "error", err)
+				for range rawReadings {
+				}
+			}
+		}()
+	}
+
+	// Stop reading once `config.MaxSamples` readings have been taken, if
+	// that's set, shutting down as if `ctx` had been cancelled
+	samples := newSampleLimit(config.MaxSamples, func() {
+		slog.Info("Read the maximum number of samples, stopping", "max_samples", config.MaxSamples)
+		cancel()
+	})
+	for i := range sensors {
+		sensors[i].samples = samples
+	}
+
+	// Start reading the sensors, polling each bus separately so a slow or
+	// failing bus doesn't hold up the others
+	interval := new(atomic.Int64)
+	interval.Store(int64(config.ReadInterval))
+	groups := busGroups(sensors)
+	reloaded := make([]chan struct{}, len(groups))
+	for i := range reloaded {
+		reloaded[i] = make(chan struct{}, 1)
+	}
+
+	if config.Reloads != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case settings := <-config.Reloads:
+					interval.Store(int64(settings.ReadInterval))
+					windowSize.Store(int64(settings.WindowSize))
+					slog.Info("Reloaded the config", "interval", settings.ReadInterval, "window", settings.WindowSize)
+					for _, r := range reloaded {
+						select {
+						case r <- struct{}{}:
+						default:
+							// The bus hasn't caught up with the last reload
+							// yet, and will pick up this one too
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	// The ADC may be on one of the buses being polled, so it's only read by
+	// one of them at a time
+	var voltageMu sync.Mutex
+	readSupplyVoltage := func() *physic.ElectricPotential {
+		voltageMu.Lock()
+		defer voltageMu.Unlock()
+		return readVoltage(voltagePin)
+	}
+
+	readErr := pollBuses(ctx, groups, readSupplyVoltage, interval, reloaded, config)
+
+	// Polling has stopped, and no read is still being queued. Each stage of
+	// the pipeline closes its output once its input is closed, so closing the
+	// channels the sensors were read into flushes the partial windows through
+	// to the sinks. Wait for them to be written.
+	cancel()
+	slog.Info("Stopped reading, writing the remaining readings")
+	for _, sensor := range sensors {
+		close(sensor.logging)
+	}
+	if rawReadings != nil {
+		close(rawReadings)
+	}
+	if err := waitForDrain(config.Clock, config.ShutdownTimeout, written, rawWritten); err != nil {
+		return err
+	}
+	if writeErr == nil {
+		slog.Info("Wrote the remaining readings")
+	}
+	return errors.Join(writeErr, readErr)
+}
+
+func waitForDrain(clock Clock, timeout time.Duration, done ...<-chan struct{}) error {
+	// Wait for each of `done` to be closed as the sinks finish writing, giving
+	// up after `timeout` unless it's 0
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := clock.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C()
+	}
+	for _, d := range done {
+		select {
+		case <-d:
+		case <-expired:
+			return fmt.Errorf("gave up writing the remaining readings after %s", timeout)
+		}
+	}
+	return nil
+}
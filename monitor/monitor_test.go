@@ -0,0 +1,1138 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the logs of the code under test out of the test output
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// Start of the time of the `FakeClock`s in tests
+var testTime = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func testConfig() Config {
+	// A config for `Run` that reads a mock sensor every second, averaging 3
+	// readings at a time, and discards the averages
+
+	return Config{
+		AverageMode:   "window",
+		Aggregation:   "mean",
+		WindowSize:    3,
+		WindowMode:    "tumbling",
+		TimestampMode: "end",
+		ReadInterval:  time.Second,
+		ChannelBuffer: 8,
+		DropPolicy:    "oldest",
+		Mock:          true,
+		DryRun:        true,
+		Output: OutputConfig{
+			TemperatureUnit: Celsius,
+			PressureUnit:    Hectopascal,
+			Fields:          Fields{Temperature: true, Pressure: true, Humidity: true},
+		},
+	}
+}
+
+func TestRunStopsWhenContextIsCancelled(t *testing.T) {
+	tests := []struct {
+		name        string
+		averageMode string
+	}{
+		{"window", "window"},
+		{"ema", "ema"},
+		{"none", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			config := testConfig()
+			config.AverageMode = tt.averageMode
+			config.EMAAlpha = 0.5
+			config.Clock = clock
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- Run(ctx, config) }()
+
+			// Let polling start and read a few times, then stop it through
+			// the context alone
+			clock.BlockUntil(1)
+			for i := 0; i < 4; i++ {
+				clock.Advance(config.ReadInterval)
+			}
+			cancel()
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("Run() = %v, want nil", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run() didn't return after the context was cancelled")
+			}
+		})
+	}
+}
+
+func TestRunCanBeRunAgain(t *testing.T) {
+	// Runs one after the other and at the same time each start afresh, and
+	// stop when their own context is cancelled
+
+	config := testConfig()
+	config.Clock = NewFakeClock(testTime)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := Run(ctx, config); err != nil {
+			t.Fatalf("run %d: Run() = %v, want nil", i+1, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- Run(ctx, testConfig()) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("concurrent Run() = %v, want nil", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("concurrent Run() didn't return after the context was cancelled")
+		}
+	}
+}
+
+func TestRunStateIsNotShared(t *testing.T) {
+	config := testConfig()
+	config.Clock = NewFakeClock(testTime)
+	config.HistorySize = 10
+	first, second := newRunState(config), newRunState(config)
+
+	reading := Reading{Env: physic.Env{Temperature: physic.ZeroCelsius + 20*physic.Kelvin}, HasHumidity: true, Label: "a", Time: testTime}
+	first.recordAverage(reading, testTime)
+	first.health.recordRead(testTime)
+	first.health.recordWrite(testTime)
+	first.health.setStuck("a", true)
+
+	if got := len(first.history.last(10)); got != 1 {
+		t.Fatalf("first run kept %d averages, want 1", got)
+	}
+	if got := len(second.history.last(10)); got != 0 {
+		t.Errorf("second run kept %d averages, want 0", got)
+	}
+	if got := len(second.latest.records(config.Output)); got != 0 {
+		t.Errorf("second run has %d latest readings, want 0", got)
+	}
+	if lastRead, lastWrite := second.health.times(); !lastRead.IsZero() || !lastWrite.IsZero() {
+		t.Errorf("second run health times = %v, %v, want zero", lastRead, lastWrite)
+	}
+	if got := second.health.stuckSensors(); got != 0 {
+		t.Errorf("second run has %d stuck sensors, want 0", got)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		advances []time.Duration
+		// Number of calls expected after each advance
+		calls []int
+	}{
+		{"every interval", []time.Duration{time.Second, time.Second, time.Second}, []int{1, 1, 1}},
+		{"not before the interval", []time.Duration{500 * time.Millisecond, 400 * time.Millisecond}, []int{0, 0}},
+		{"from the start of polling", []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			interval := new(atomic.Int64)
+			interval.Store(int64(time.Second))
+			calls := make(chan struct{}, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				pollInterval(ctx, clock, func() { calls <- struct{}{} }, interval, 0, nil, "")
+			}()
+
+			clock.BlockUntil(1)
+			for i, advance := range tt.advances {
+				clock.Advance(advance)
+				for j := 0; j < tt.calls[i]; j++ {
+					select {
+					case <-calls:
+					case <-time.After(5 * time.Second):
+						t.Fatalf("advance %d: called %d times, want %d", i+1, j, tt.calls[i])
+					}
+				}
+			}
+			cancel()
+			<-done
+			if extra := len(calls); extra > 0 {
+				t.Errorf("called %d more times than expected", extra)
+			}
+		})
+	}
+}
+
+func TestPollIntervalReload(t *testing.T) {
+	clock := NewFakeClock(testTime)
+	interval := new(atomic.Int64)
+	interval.Store(int64(time.Second))
+	reloaded := make(chan struct{})
+	calls := make(chan time.Time, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pollInterval(ctx, clock, func() { calls <- clock.Now() }, interval, 0, reloaded, "")
+
+	clock.BlockUntil(1)
+	interval.Store(int64(time.Minute))
+	// The second reload is only received once the first has been applied
+	reloaded <- struct{}{}
+	reloaded <- struct{}{}
+
+	clock.Advance(59 * time.Second)
+	if len(calls) > 0 {
+		t.Fatal("called before the reloaded interval")
+	}
+	clock.Advance(time.Second)
+	select {
+	case at := <-calls:
+		if want := testTime.Add(time.Minute); !at.Equal(want) {
+			t.Errorf("called at %v, want %v", at, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not called after the reloaded interval")
+	}
+}
+
+func TestJitterDelay(t *testing.T) {
+	tests := []time.Duration{1, time.Millisecond, time.Second, time.Hour}
+	for _, jitter := range tests {
+		t.Run(jitter.String(), func(t *testing.T) {
+			var longest time.Duration
+			for i := 0; i < 1000; i++ {
+				delay := jitterDelay(jitter)
+				if delay < 0 || delay > jitter {
+					t.Fatalf("jitterDelay(%v) = %v, want between 0 and %v", jitter, delay, jitter)
+				}
+				longest = max(longest, delay)
+			}
+			// The delays are spread across the whole range
+			if longest < jitter/2 {
+				t.Errorf("longest of 1000 delays was %v, want at least %v", longest, jitter/2)
+			}
+		})
+	}
+}
+
+func TestMaxReadGap(t *testing.T) {
+	tests := []struct {
+		interval, jitter, want time.Duration
+	}{
+		{time.Minute, 0, 90 * time.Second},
+		{time.Minute, 10 * time.Second, 100 * time.Second},
+		{time.Second, 999 * time.Millisecond, 2499 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := maxReadGap(tt.interval, tt.jitter); got != tt.want {
+			t.Errorf("maxReadGap(%v, %v) = %v, want %v", tt.interval, tt.jitter, got, tt.want)
+		}
+	}
+}
+
+func TestReadOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		failRead bool
+		bounds   Bounds
+		writeErr error
+		wantErr  bool
+		// Error the one returned wraps, if any
+		cause   error
+		written int
+	}{
+		{"written", false, testBounds, nil, false, nil, 1},
+		{"read fails", true, testBounds, nil, true, errReadFailed, 0},
+		{"implausible reading", false, Bounds{}, nil, true, nil, 0},
+		{"write fails", false, testBounds, errWriteFailed, true, errWriteFailed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			config.Bounds = tt.bounds
+			state := newRunState(config)
+			sensor := &flakySensor{MockSensor: newMockSensor(nil, clock), fail: map[int]bool{0: tt.failRead}}
+			sensors := []sensorReader{*newTestSensorReader(state, sensor)}
+			sink := &recordingSink{err: tt.writeErr}
+
+			err := state.readOnce(sensors, nil, sink, config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readOnce() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.cause != nil && !errors.Is(err, tt.cause) {
+				t.Errorf("readOnce() = %v, want it to wrap %v", err, tt.cause)
+			}
+			if got := sink.count(); got != tt.written {
+				t.Errorf("wrote %d points, want %d", got, tt.written)
+			}
+			if sensor.reads != 1 {
+				t.Errorf("read the sensor %d times, want 1", sensor.reads)
+			}
+		})
+	}
+}
+
+func TestRunOnceWritesOnePoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.csv")
+	config := testConfig()
+	config.Clock = NewFakeClock(testTime)
+	config.Once = true
+	config.DryRun = false
+	config.Sinks = []string{"csv"}
+	config.CSV = CSVConfig{Path: path, SyncInterval: time.Second}
+	config.Bounds = testBounds
+
+	// Nothing is polled, so the run returns without the clock moving
+	if err := Run(context.Background(), config); err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Split(strings.TrimSpace(string(data)), "\n"); len(rows) != 2 {
+		t.Errorf("wrote %d rows, want a header and one point:\n%s", len(rows), data)
+	}
+}
+
+func TestRetryInit(t *testing.T) {
+	errNotLoaded := errors.New("I²C module not loaded")
+	tests := []struct {
+		name     string
+		failures int
+		retries  int
+		wantErr  bool
+		// Time of each attempt since the first
+		attempts []time.Duration
+	}{
+		{"succeeds at once", 0, 3, false, []time.Duration{0}},
+		{"succeeds after retrying", 2, 3, false, []time.Duration{0, time.Second, 3 * time.Second}},
+		{"succeeds on the last retry", 3, 3, false, []time.Duration{0, time.Second, 3 * time.Second, 7 * time.Second}},
+		{"gives up", 5, 2, true, []time.Duration{0, time.Second, 3 * time.Second}},
+		{"no retries", 1, 0, true, []time.Duration{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			var attempts []time.Duration
+			init := func() error {
+				attempts = append(attempts, clock.Now().Sub(testTime))
+				if len(attempts) <= tt.failures {
+					return errNotLoaded
+				}
+				return nil
+			}
+			done := make(chan error, 1)
+			go func() { done <- retryInit(clock, init, tt.retries, time.Second) }()
+
+			// Advance through each backoff delay once it's being slept
+			for i, delay := 1, time.Second; i < len(tt.attempts); i, delay = i+1, delay*2 {
+				clock.BlockUntil(1)
+				clock.Advance(delay)
+			}
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("retryInit() didn't return")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retryInit() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errNotLoaded) {
+				t.Errorf("retryInit() = %v, want %v", err, errNotLoaded)
+			}
+			if !slices.Equal(attempts, tt.attempts) {
+				t.Errorf("attempted at %v, want %v", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		bounds   Bounds
+		failFast bool
+		wantErr  bool
+		// Rows written to the CSV file, including the header
+		rows int
+	}{
+		{"passes", testBounds, true, false, 2},
+		// With no bounds set, every reading is implausible
+		{"fails fast", Bounds{}, true, true, 1},
+		{"fails and carries on", Bounds{}, false, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readings.csv")
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			config.DryRun = false
+			config.Sinks = []string{"csv"}
+			config.CSV = CSVConfig{Path: path, SyncInterval: time.Second}
+			config.Bounds = tt.bounds
+			config.FailFast = tt.failFast
+
+			// The self-test runs even if the run is stopped straight away, and
+			// as the clock never moves, nothing else is written
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			done := make(chan error, 1)
+			go func() { done <- Run(ctx, config) }()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run() didn't return")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() = %v, want error %v", err, tt.wantErr)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows := strings.Split(strings.TrimSpace(string(data)), "\n"); len(rows) != tt.rows {
+				t.Errorf("wrote %d rows, want %d:\n%s", len(rows), tt.rows, data)
+			}
+		})
+	}
+}
+
+func TestPipelineDrainsBuffersOnShutdown(t *testing.T) {
+	tests := []struct {
+		averageMode string
+		buffer      int
+		readings    int
+		written     int
+	}{
+		{"none", 1, 50, 50},
+		{"none", 64, 50, 50},
+		{"window", 1, 50, 17},
+		{"window", 64, 50, 17},
+		{"ema", 64, 50, 50},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s buffer %d", tt.averageMode, tt.buffer), func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			config.AverageMode = tt.averageMode
+			config.EMAAlpha = 0.5
+			config.ChannelBuffer = tt.buffer
+			state := newRunState(config)
+			windowSize := new(atomic.Int64)
+			windowSize.Store(int64(config.WindowSize))
+
+			// The readers have stopped with readings still queued
+			logging := make(chan Reading, tt.readings)
+			for i := 0; i < tt.readings; i++ {
+				logging <- testReading(20, time.Duration(i)*time.Second)
+			}
+			close(logging)
+
+			sink := &recordingSink{}
+			if err := state.logToSink(sink, config.Write, state.averageReadings(config, logging, windowSize)); err != nil {
+				t.Fatal(err)
+			}
+			if got := sink.count(); got != tt.written {
+				t.Errorf("wrote %d points, want %d", got, tt.written)
+			}
+		})
+	}
+}
+
+func TestPipelineStartsAndStopsRepeatedly(t *testing.T) {
+	// Each channel is closed once, by whatever sends on it, however far the
+	// run got before being stopped. Run with -race to also check for races.
+
+	tests := []struct {
+		averageMode string
+		// Reads before the run is stopped
+		reads int
+	}{
+		{"window", 0},
+		{"window", 1},
+		{"window", 4},
+		{"ema", 2},
+		{"none", 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s after %d reads", tt.averageMode, tt.reads), func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				clock := NewFakeClock(testTime)
+				config := testConfig()
+				config.Clock = clock
+				config.AverageMode = tt.averageMode
+				config.EMAAlpha = 0.5
+				config.Bounds = testBounds
+				config.ChannelBuffer = 1
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- Run(ctx, config) }()
+				if tt.reads > 0 {
+					clock.BlockUntil(1)
+					for j := 0; j < tt.reads; j++ {
+						clock.Advance(config.ReadInterval)
+					}
+				}
+				cancel()
+
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatalf("run %d: Run() = %v, want nil", i+1, err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("run %d: Run() didn't return after the context was cancelled", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForStartup(t *testing.T) {
+	errNotReady := errors.New("not ready")
+	tests := []struct {
+		name string
+		// Attempts at which each step of the chain fails
+		initFails, busFails, deviceFails map[int]bool
+		wait                             time.Duration
+		wantErr                          bool
+		attempts                         int
+	}{
+		{"ready at once", nil, nil, nil, 10 * time.Second, false, 1},
+		{"drivers load late", map[int]bool{1: true, 2: true}, nil, nil, 10 * time.Second, false, 3},
+		{"each step fails in turn", map[int]bool{1: true}, map[int]bool{2: true}, map[int]bool{3: true}, 10 * time.Second, false, 4},
+		{"gives up at the deadline", nil, map[int]bool{1: true, 2: true, 3: true, 4: true}, nil, 2500 * time.Millisecond, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			attempt := 0
+			// Each attempt runs the whole chain, starting again from loading
+			// the drivers
+			calls := map[string]int{}
+			step := func(name string, fails map[int]bool) error {
+				calls[name]++
+				if fails[attempt] {
+					return fmt.Errorf("%s: %w", name, errNotReady)
+				}
+				return nil
+			}
+			start := func() error {
+				attempt++
+				for _, s := range []struct {
+					name  string
+					fails map[int]bool
+				}{{"init", tt.initFails}, {"bus", tt.busFails}, {"device", tt.deviceFails}} {
+					if err := step(s.name, s.fails); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			done := make(chan error, 1)
+			go func() { done <- waitForStartup(clock, tt.wait, time.Second, start) }()
+
+			for i := 1; i < tt.attempts; i++ {
+				clock.BlockUntil(1)
+				clock.Advance(time.Second)
+			}
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("waitForStartup() didn't return")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("waitForStartup() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errNotReady) {
+				t.Errorf("waitForStartup() = %v, want it to wrap %v", err, errNotReady)
+			}
+			if attempt != tt.attempts {
+				t.Errorf("made %d attempts, want %d", attempt, tt.attempts)
+			}
+			if calls["init"] != tt.attempts {
+				t.Errorf("loaded the drivers %d times, want once per attempt", calls["init"])
+			}
+		})
+	}
+}
+
+func TestSampleTimesPropagate(t *testing.T) {
+	// Readings are taken a minute apart and only written an hour later, and
+	// are timestamped by when they were taken
+	tests := []struct {
+		averageMode   string
+		timestampMode string
+		// Minutes past `testTime` of the points written
+		want []time.Duration
+	}{
+		{"window", "end", []time.Duration{2, 5}},
+		{"window", "mid", []time.Duration{1, 4}},
+		{"ema", "end", []time.Duration{0, 1, 2, 3, 4, 5}},
+		{"none", "end", []time.Duration{0, 1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.averageMode+" "+tt.timestampMode, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			config.AverageMode = tt.averageMode
+			config.TimestampMode = tt.timestampMode
+			config.EMAAlpha = 0.5
+			state := newRunState(config)
+			reader := newTestSensorReader(state, newMockSensor(nil, clock))
+			for i := 0; i < 6; i++ {
+				if !reader.read(0, 0, testBounds, nil, time.Hour, 0) {
+					t.Fatalf("read %d failed", i+1)
+				}
+				clock.Advance(time.Minute)
+			}
+			close(reader.logging)
+			clock.Advance(time.Hour)
+
+			windowSize := new(atomic.Int64)
+			windowSize.Store(int64(config.WindowSize))
+			sink := &recordingSink{}
+			if err := state.logToSink(sink, config.Write, state.averageReadings(config, reader.logging, windowSize)); err != nil {
+				t.Fatal(err)
+			}
+			if len(sink.times) != len(tt.want) {
+				t.Fatalf("wrote %d points, want %d", len(sink.times), len(tt.want))
+			}
+			for i, minutes := range tt.want {
+				want := testTime.Add(minutes * time.Minute)
+				if !sink.times[i].Equal(want) || !sink.written[i].Time.Equal(want) {
+					t.Errorf("point %d written at %v with time %v, want %v", i+1, sink.times[i], sink.written[i].Time, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBusGroups(t *testing.T) {
+	tests := []struct {
+		name  string
+		buses []string
+		want  [][]string
+	}{
+		{"one bus", []string{"", "", ""}, [][]string{{"", "", ""}}},
+		{"in order of first appearance", []string{"2", "", "2", "1"}, [][]string{{"2", "2"}, {""}, {"1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sensors := make([]sensorReader, len(tt.buses))
+			for i, bus := range tt.buses {
+				sensors[i] = sensorReader{bus: bus, label: fmt.Sprint(i)}
+			}
+			groups := busGroups(sensors)
+			if len(groups) != len(tt.want) {
+				t.Fatalf("got %d groups, want %d", len(groups), len(tt.want))
+			}
+			for i, group := range groups {
+				var buses []string
+				for _, sensor := range group {
+					buses = append(buses, sensor.bus)
+				}
+				if !slices.Equal(buses, tt.want[i]) {
+					t.Errorf("group %d is on buses %q, want %q", i, buses, tt.want[i])
+				}
+			}
+			// The groups point into `sensors`, so reads update their state
+			if groups[0][0] != &sensors[0] {
+				t.Error("groups don't point to the sensors given")
+			}
+		})
+	}
+}
+
+func TestOpenSensorsOnBuses(t *testing.T) {
+	config := testConfig()
+	config.Clock = NewFakeClock(testTime)
+	config.Sensors = []SensorConfig{
+		{Address: 0x76, Label: "a"},
+		{Address: 0x77, Label: "b", Bus: "2"},
+	}
+	var opened []string
+	open := func(bus string, address uint16) (Sensor, error) {
+		opened = append(opened, fmt.Sprintf("%s/%#x", bus, address))
+		return newMockSensor(nil, config.Clock), nil
+	}
+
+	sensors, err := openSensors(open, config, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sensor := range sensors {
+		if _, err := sensor.reopen(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each sensor is reopened on the bus it was first opened on
+	if want := []string{"/0x76", "2/0x77", "/0x76", "2/0x77"}; !slices.Equal(opened, want) {
+		t.Errorf("opened %v, want %v", opened, want)
+	}
+	if sensors[0].bus != "" || sensors[1].bus != "2" {
+		t.Errorf("sensors are on buses %q and %q, want \"\" and \"2\"", sensors[0].bus, sensors[1].bus)
+	}
+}
+
+func TestMergeReadings(t *testing.T) {
+	inputs := make([]chan Reading, 3)
+	outputs := make([]<-chan Reading, len(inputs))
+	for i := range inputs {
+		inputs[i] = make(chan Reading)
+		outputs[i] = inputs[i]
+	}
+	merged := mergeReadings(outputs, 1)
+
+	for i, input := range inputs {
+		input <- Reading{Label: fmt.Sprint(i)}
+	}
+	var labels []string
+	for i := 0; i < len(inputs); i++ {
+		reading, _ := receive(t, merged)
+		labels = append(labels, reading.Label)
+	}
+	slices.Sort(labels)
+	if want := []string{"0", "1", "2"}; !slices.Equal(labels, want) {
+		t.Errorf("merged %v, want %v", labels, want)
+	}
+
+	// The merged channel stays open until every input is closed
+	close(inputs[0])
+	close(inputs[1])
+	inputs[2] <- Reading{Label: "last"}
+	if reading, _ := receive(t, merged); reading.Label != "last" {
+		t.Errorf("merged %q after closing two inputs, want \"last\"", reading.Label)
+	}
+	close(inputs[2])
+	if _, ok := receive(t, merged); ok {
+		t.Error("merged channel is open after every input is closed")
+	}
+}
+
+func TestPollBusGivesUpOnlyOnItsOwnBus(t *testing.T) {
+	// A sensor that keeps failing stops the polling of its bus, while the
+	// sensors on other buses are still read
+
+	clock := NewFakeClock(testTime)
+	config := testConfig()
+	config.Clock = clock
+	config.Bounds = testBounds
+	config.MaxReadFailures = 1
+	state := newRunState(config)
+
+	failing := newTestSensorReader(state, &flakySensor{MockSensor: newMockSensor(nil, clock), fail: map[int]bool{0: true, 1: true}})
+	failing.bus = "1"
+	working := newTestSensorReader(state, newMockSensor(nil, clock))
+	working.bus = "2"
+
+	interval := new(atomic.Int64)
+	interval.Store(int64(time.Second))
+	noVoltage := func() *physic.ElectricPotential { return nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failingDone, workingDone := make(chan bool, 1), make(chan bool, 1)
+	go func() { failingDone <- pollBus(ctx, []*sensorReader{failing}, noVoltage, interval, nil, config) }()
+	go func() { workingDone <- pollBus(ctx, []*sensorReader{working}, noVoltage, interval, nil, config) }()
+
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	if gaveUp, _ := receive(t, failingDone); !gaveUp {
+		t.Error("polling of the failing bus stopped without giving up")
+	}
+	receive(t, working.logging)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	receive(t, working.logging)
+	select {
+	case <-workingDone:
+		t.Fatal("polling of the working bus stopped")
+	default:
+	}
+	cancel()
+	if gaveUp, _ := receive(t, workingDone); gaveUp {
+		t.Error("polling of the working bus gave up when it was stopped")
+	}
+}
+
+func TestPollBusesGiveUp(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the sensor on each bus keeps failing
+		failing []bool
+		wantErr bool
+	}{
+		{"every bus gives up", []bool{true, true}, true},
+		{"one bus gives up", []bool{true, false}, false},
+		{"stopped", []bool{false, false}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			config := testConfig()
+			config.Clock = clock
+			config.Bounds = testBounds
+			config.MaxReadFailures = 1
+			state := newRunState(config)
+
+			var groups [][]*sensorReader
+			var reloaded []chan struct{}
+			working := 0
+			for i, failing := range tt.failing {
+				var dev Sensor = newMockSensor(nil, clock)
+				if failing {
+					dev = &flakySensor{MockSensor: newMockSensor(nil, clock), fail: map[int]bool{0: true, 1: true}}
+				} else {
+					working++
+				}
+				sensor := newTestSensorReader(state, dev)
+				sensor.bus = fmt.Sprint(i)
+				groups = append(groups, []*sensorReader{sensor})
+				reloaded = append(reloaded, make(chan struct{}, 1))
+			}
+			interval := new(atomic.Int64)
+			interval.Store(int64(time.Second))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			noVoltage := func() *physic.ElectricPotential { return nil }
+			go func() { done <- pollBuses(ctx, groups, noVoltage, interval, reloaded, config) }()
+
+			clock.BlockUntil(len(groups))
+			clock.Advance(time.Second)
+			if working > 0 {
+				// The working buses carry on until they're stopped
+				clock.BlockUntil(working)
+				cancel()
+			}
+			err, _ := receive(t, done)
+			if tt.wantErr != errors.Is(err, ErrSensorsGaveUp) || (!tt.wantErr && err != nil) {
+				t.Errorf("pollBuses() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIntervalGroups(t *testing.T) {
+	sensors := []*sensorReader{
+		{label: "a"},
+		{label: "b", interval: time.Minute},
+		{label: "c"},
+		{label: "d", interval: 5 * time.Second},
+		{label: "e", interval: time.Minute},
+	}
+	var got [][]string
+	for _, group := range intervalGroups(sensors) {
+		var labels []string
+		for _, sensor := range group {
+			labels = append(labels, sensor.label)
+		}
+		got = append(got, labels)
+	}
+	want := [][]string{{"a", "c"}, {"b", "e"}, {"d"}}
+	if !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("intervalGroups() = %v, want %v", got, want)
+	}
+}
+
+func TestPollBusMixedIntervals(t *testing.T) {
+	// Sensors on the same bus are each read at their own interval, or the
+	// bus's if they don't have one
+
+	clock := NewFakeClock(testTime)
+	config := testConfig()
+	config.Clock = clock
+	config.Bounds = testBounds
+	state := newRunState(config)
+	fast := newTestSensorReader(state, newMockSensor(nil, clock))
+	slow := newTestSensorReader(state, newMockSensor(nil, clock))
+	slow.interval = 3 * time.Second
+
+	interval := new(atomic.Int64)
+	interval.Store(int64(time.Second))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pollBus(ctx, []*sensorReader{fast, slow}, func() *physic.ElectricPotential { return nil }, interval, nil, config)
+	}()
+
+	clock.BlockUntil(2)
+	var slowReads []time.Time
+	for i := 1; i <= 6; i++ {
+		clock.Advance(time.Second)
+		reading, _ := receive(t, fast.logging)
+		if want := testTime.Add(time.Duration(i) * time.Second); !reading.Time.Equal(want) {
+			t.Errorf("fast sensor read at %v, want %v", reading.Time, want)
+		}
+		if i%3 == 0 {
+			reading, _ := receive(t, slow.logging)
+			slowReads = append(slowReads, reading.Time)
+		}
+	}
+	cancel()
+	<-done
+
+	if extra := len(slow.logging); extra > 0 {
+		t.Errorf("slow sensor read %d more times than expected", extra)
+	}
+	want := []time.Time{testTime.Add(3 * time.Second), testTime.Add(6 * time.Second)}
+	if !slices.EqualFunc(slowReads, want, time.Time.Equal) {
+		t.Errorf("slow sensor read at %v, want %v", slowReads, want)
+	}
+}
+
+func TestWaitForDrain(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// Time after which each stage finishes, or -1 for never
+		finish  []time.Duration
+		wantErr bool
+	}{
+		{"already drained", time.Minute, []time.Duration{0, 0}, false},
+		{"drained within the timeout", time.Minute, []time.Duration{10 * time.Second, 50 * time.Second}, false},
+		{"one stage too slow", time.Minute, []time.Duration{10 * time.Second, -1}, true},
+		{"no timeout", 0, []time.Duration{10 * time.Minute, time.Hour}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			done := make([]chan struct{}, len(tt.finish))
+			receivers := make([]<-chan struct{}, len(tt.finish))
+			for i, after := range tt.finish {
+				done[i] = make(chan struct{})
+				receivers[i] = done[i]
+				if after == 0 {
+					close(done[i])
+				}
+			}
+			result := make(chan error, 1)
+			go func() { result <- waitForDrain(clock, tt.timeout, receivers...) }()
+
+			if tt.wantErr {
+				// The timer has to be running before the clock moves past
+				// it. When every stage finishes, it may already be stopped.
+				clock.BlockUntil(1)
+			}
+			// Finish the stages in turn as the clock moves on, then move
+			// past the timeout
+			elapsed := time.Duration(0)
+			for i, after := range tt.finish {
+				if after <= 0 {
+					continue
+				}
+				clock.Advance(after - elapsed)
+				elapsed = after
+				close(done[i])
+			}
+			clock.Advance(time.Minute + time.Second)
+
+			err, _ := receive(t, result)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForDrain() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSampleLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		// Whether each read takes a reading
+		reads []bool
+		// Whether each read is allowed, and the reads after which the limit
+		// is reached
+		wantAllowed []bool
+		wantReached int
+	}{
+		{"no limit", 0, []bool{true, true, true}, []bool{true, true, true}, 0},
+		{"reached", 2, []bool{true, true, true}, []bool{true, true, false}, 1},
+		{"failed reads aren't counted", 2, []bool{false, true, false, true, true}, []bool{true, true, true, true, false}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := 0
+			limit := newSampleLimit(tt.max, func() { reached++ })
+			for i, taken := range tt.reads {
+				allowed := limit.reserve()
+				if allowed != tt.wantAllowed[i] {
+					t.Errorf("read %d allowed = %v, want %v", i+1, allowed, tt.wantAllowed[i])
+				}
+				if allowed {
+					limit.release(taken)
+				}
+			}
+			if reached != tt.wantReached {
+				t.Errorf("limit reached %d times, want %d", reached, tt.wantReached)
+			}
+		})
+	}
+}
+
+func TestSampleLimitReservesConcurrentReads(t *testing.T) {
+	// Reads running at the same time on different buses can't take more
+	// than the limit between them
+	limit := newSampleLimit(2, func() {})
+	allowed := 0
+	for i := 0; i < 3; i++ {
+		if limit.reserve() {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("allowed %d reads at once, want 2", allowed)
+	}
+
+	// A reservation freed by a failed read can be used by another
+	limit.release(false)
+	if !limit.reserve() {
+		t.Error("freed reservation couldn't be used")
+	}
+}
+
+func TestRunStopsAfterMaxSamples(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSamples int
+		windowSize int
+		// Averages written, not counting the header or the self-test's point
+		want int
+	}{
+		{"one", 1, 1, 1},
+		{"several", 5, 1, 5},
+		{"partial window written", 5, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readings.csv")
+			clock := NewFakeClock(testTime)
+			config := testConfig()
+			config.Clock = clock
+			config.DryRun = false
+			config.Sinks = []string{"csv"}
+			config.CSV = CSVConfig{Path: path, SyncInterval: time.Second}
+			config.Bounds = testBounds
+			config.WindowSize = tt.windowSize
+			config.MaxSamples = tt.maxSamples
+
+			if err := runAdvancing(t, clock, config); err != nil {
+				t.Fatalf("Run() = %v, want nil", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The self-test's reading is written, but isn't counted
+			if rows := strings.Split(strings.TrimSpace(string(data)), "\n"); len(rows)-2 != tt.want {
+				t.Errorf("wrote %d averages, want %d:\n%s", len(rows)-2, tt.want, data)
+			}
+		})
+	}
+}
+
+func runAdvancing(t *testing.T, clock *FakeClock, config Config) error {
+	// Run with `config` until it stops by itself, moving `clock` on by a
+	// second at a time so the sensors are polled
+
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- Run(context.Background(), config) }()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-deadline:
+			t.Fatal("Run() didn't stop by itself")
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Second)
+		}
+	}
+}
+
+func TestRunReturnsWriteError(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxWriteFailures int
+		status           int
+		wantErr          bool
+	}{
+		{"gives up", 2, http.StatusInternalServerError, true},
+		// Stopped by the sample limit, having written every point
+		{"writes", 2, http.StatusNoContent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			clock := NewFakeClock(testTime)
+			config := testConfig()
+			config.Clock = clock
+			config.DryRun = false
+			config.Sinks = []string{"influx"}
+			config.Influx = InfluxConfig{Version: 2, URL: server.URL, Org: "org", Bucket: "bucket", Measurement: "environment", Precision: time.Second, BatchSize: 1}
+			config.Bounds = testBounds
+			config.WindowSize = 1
+			config.Write = WriteConfig{MaxWriteFailures: tt.maxWriteFailures}
+			config.MaxSamples = 5
+
+			err := runAdvancing(t, clock, config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "stopped writing: giving up after 2 consecutive write failures") {
+				t.Errorf("Run() = %v, want the sink's error", err)
+			}
+		})
+	}
+}
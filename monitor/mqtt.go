@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type MQTTConfig struct {
+	Broker   string
+	Topic    string
+	Username string
+	Password string
+	QoS      int
+}
+
+// How long to wait for the broker to acknowledge a publish, after which it's
+// given up on
+const mqttPublishTimeout = 10 * time.Second
+
+// MQTTSink publishes each reading as a JSON message to an MQTT broker
+type MQTTSink struct {
+	client mqtt.Client
+	topic  string
+	qos    byte
+	output OutputConfig
+	clock  Clock
+
+	// Publishes that haven't been acknowledged or given up on yet
+	inflight sync.WaitGroup
+}
+
+func newMQTTSink(config MQTTConfig, output OutputConfig, clock Clock) (*MQTTSink, error) {
+	if config.Broker == "" || config.Topic == "" {
+		return nil, fmt.Errorf("the mqtt sink needs a broker and topic, set with -mqtt_broker and -mqtt_topic")
+	}
+	if config.QoS < 0 || config.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d: must be 0, 1 or 2", config.QoS)
+	}
+
+	hostname, _ := os.Hostname()
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID("environmentmonitor-" + hostname).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		// Keep retrying the initial connection, and reconnect after losing it,
+		// backing off up to a minute between attempts
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("Connected to MQTT broker", "broker", config.Broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker, reconnecting", "broker", config.Broker, "error", err)
+		})
+
+	client := mqtt.NewClient(opts)
+	client.Connect()
+
+	return &MQTTSink{
+		client: client,
+		topic:  config.Topic,
+		qos:    byte(config.QoS),
+		output: output,
+		clock:  clock,
+	}, nil
+}
+
+func (s *MQTTSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	payload, err := json.Marshal(newJSONRecord(reading, t, s.output))
+	if err != nil {
+		return err
+	}
+
+	token := s.client.Publish(s.topic, s.qos, false, payload)
+	s.inflight.Add(1)
+	go s.track(token)
+
+	timer := s.clock.NewTimer(mqttPublishTimeout)
+	defer timer.Stop()
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return fmt.Errorf("timed out publishing to %s", s.topic)
+	}
+}
+
+func (s *MQTTSink) track(token mqtt.Token) {
+	// Wait up to `mqttPublishTimeout` for the broker to acknowledge a
+	// publish, so `Close` knows when it's finished. A publish that isn't
+	// acknowledged by then, including one whose write was cancelled, is
+	// logged as dropped.
+
+	defer s.inflight.Done()
+	timer := s.clock.NewTimer(mqttPublishTimeout)
+	defer timer.Stop()
+	select {
+	case <-token.Done():
+	case <-timer.C():
+		slog.Warn("MQTT publish wasn't acknowledged, dropping it", "topic", s.topic, "timeout", mqttPublishTimeout)
+	}
+}
+
+func (s *MQTTSink) Close() error {
+	// Wait for outstanding publishes before disconnecting. Each is given up
+	// on after `mqttPublishTimeout`, so this never waits longer than that.
+
+	s.inflight.Wait()
+	s.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeToken is a publish the broker acknowledges when `done` is closed
+type fakeToken struct {
+	done chan struct{}
+}
+
+func (t fakeToken) Wait() bool                     { <-t.done; return true }
+func (t fakeToken) WaitTimeout(time.Duration) bool { panic("not used") }
+func (t fakeToken) Done() <-chan struct{}          { return t.done }
+func (t fakeToken) Error() error                   { return nil }
+
+// fakeMQTTClient hands out `token` for every publish
+type fakeMQTTClient struct {
+	mqtt.Client
+	token fakeToken
+}
+
+func (c fakeMQTTClient) Publish(string, byte, bool, interface{}) mqtt.Token { return c.token }
+func (c fakeMQTTClient) Disconnect(uint)                                    {}
+
+func TestMQTTSinkClose(t *testing.T) {
+	tests := []struct {
+		name         string
+		acknowledged bool
+		// Time the clock is advanced by before `Close` should return
+		advance time.Duration
+		wantErr bool
+	}{
+		{"acknowledged", true, 0, false},
+		{"never acknowledged", false, mqttPublishTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			token := fakeToken{make(chan struct{})}
+			if tt.acknowledged {
+				close(token.done)
+			}
+			sink := &MQTTSink{client: fakeMQTTClient{token: token}, topic: "env", output: testConfig().Output, clock: clock}
+
+			written := make(chan error, 1)
+			go func() { written <- sink.Write(context.Background(), testReading(20, 0), testTime) }()
+			if !tt.acknowledged {
+				// The write and the publish it started both wait
+				clock.BlockUntil(2)
+			}
+			clock.Advance(tt.advance)
+			err, _ := receive(t, written)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Write() = %v, want error: %v", err, tt.wantErr)
+			}
+
+			closed := make(chan error, 1)
+			go func() { closed <- sink.Close() }()
+			if _, ok := receive(t, closed); !ok {
+				t.Fatal("Close() didn't return")
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MultiSink writes each reading to several sinks at once. A sink that fails
+// doesn't stop the reading being written to the others, and when the write
+// is retried, only the sinks that failed are written to again. Each sink is
+// only waited for up to its own write timeout, so a slow one can't hold up
+// the others for longer than that.
+type MultiSink struct {
+	names []string
+	sinks []Sink
+	// Time allowed for each sink's writes, or 0 to wait as long as they take
+	timeouts []time.Duration
+	clock    Clock
+
+	mu sync.Mutex
+	// Indexes of the sinks each failed point still has to be written to
+	pending map[pointKey][]int
+	// For each sink, closed once a write that timed out returns, or nil if
+	// none is still running. Until then, the sink isn't written to again, so
+	// it's never written to concurrently.
+	hung []<-chan struct{}
+}
+
+// pointKey identifies a point written to a MultiSink, so retries of it can
+// be recognized
+type pointKey struct {
+	label string
+	t     int64
+}
+
+// Maximum number of failed points whose sinks are remembered. Beyond this,
+// retries of the oldest are written to every sink.
+const maxMultiSinkPending = 10000
+
+func newMultiSink(names []string, sinks []Sink, timeouts WriteTimeouts, clock Clock) *MultiSink {
+	s := &MultiSink{
+		names:    names,
+		sinks:    sinks,
+		timeouts: make([]time.Duration, len(sinks)),
+		clock:    clock,
+		pending:  map[pointKey][]int{},
+		hung:     make([]<-chan struct{}, len(sinks)),
+	}
+	for i, name := range names {
+		s.timeouts[i] = timeouts.For(name)
+	}
+	return s
+}
+
+// The outcome of writing a point to one of the sinks of a MultiSink
+type multiSinkResult struct {
+	// Index in the targets of the write
+	index int
+	err   error
+}
+
+func (s *MultiSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	key := pointKey{label: reading.Label, t: t.UnixNano()}
+
+	s.mu.Lock()
+	targets, retry := s.pending[key]
+	s.mu.Unlock()
+	if !retry {
+		targets = make([]int, len(s.sinks))
+		for i := range s.sinks {
+			targets[i] = i
+		}
+	}
+
+	// Write to each sink concurrently, so a slow sink doesn't hold up the
+	// others. Writes that are given up on are cancelled through `ctx`.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(targets))
+	results := make(chan multiSinkResult, len(targets))
+	writing := map[int]chan struct{}{}
+	for i, target := range targets {
+		if s.stillWriting(target) {
+			errs[i] = fmt.Errorf("%s: an earlier write that timed out still hasn't returned", s.names[target])
+			continue
+		}
+		done := make(chan struct{})
+		writing[i] = done
+		go func(i, target int) {
+			defer close(done)
+			results <- multiSinkResult{i, s.sinks[target].Write(ctx, reading, t)}
+		}(i, target)
+	}
+	s.wait(targets, writing, results, errs)
+
+	var failed []int
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, targets[i])
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(failed) == 0 {
+		delete(s.pending, key)
+		return nil
+	}
+	if retry || len(s.pending) < maxMultiSinkPending {
+		s.pending[key] = failed
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MultiSink) stillWriting(target int) bool {
+	// Whether a write to the sink `target` that timed out is still running
+
+	if s.hung[target] == nil {
+		return false
+	}
+	select {
+	case <-s.hung[target]:
+		s.hung[target] = nil
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *MultiSink) wait(targets []int, writing map[int]chan struct{}, results <-chan multiSinkResult, errs []error) {
+	// Collect the results of the writes in `writing`, keyed by their index in
+	// `targets`, into `errs`. Each sink is waited for until its timeout has
+	// passed since the writes started, after which its write is recorded as
+	// timed out.
+
+	start := s.clock.Now()
+	for len(writing) > 0 {
+		// The next timeout of a write still running, if any
+		next := time.Duration(-1)
+		for i := range writing {
+			if timeout := s.timeouts[targets[i]]; timeout > 0 && (next < 0 || timeout < next) {
+				next = timeout
+			}
+		}
+		var timer Timer
+		var expired <-chan time.Time
+		if next >= 0 {
+			timer = s.clock.NewTimer(next - s.clock.Now().Sub(start))
+			expired = timer.C()
+		}
+
+		select {
+		case result := <-results:
+			// Writes that were given up on keep their timeout error
+			if _, ok := writing[result.index]; ok {
+				delete(writing, result.index)
+				if result.err != nil {
+					errs[result.index] = fmt.Errorf("%s: %w", s.names[targets[result.index]], result.err)
+				}
+			}
+		case <-expired:
+			for i, done := range writing {
+				target := targets[i]
+				timeout := s.timeouts[target]
+				if timeout <= 0 || timeout > next {
+					continue
+				}
+				s.hung[target] = done
+				delete(writing, i)
+				slog.Warn("Write timed out", "sink", s.names[target], "timeout", timeout)
+				sinkWriteTimeouts.Inc()
+				errs[i] = fmt.Errorf("write to %s timed out after %s", s.names[target], timeout)
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *MultiSink) Close() error {
+	// Close every sink, even if some fail to close. A write that timed out
+	// may still be using its sink, so that sink is only closed once the write
+	// returns. Each write is cancelled when it times out, so this is only as
+	// long as the sink takes to notice.
+
+	var errs []error
+	for i, sink := range s.sinks {
+		if s.hung[i] != nil {
+			<-s.hung[i]
+		}
+		if err := sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
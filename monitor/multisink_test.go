@@ -0,0 +1,171 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiSinkFanOut(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing []bool
+		wantErr []string
+	}{
+		{"all succeed", []bool{false, false, false}, nil},
+		{"one fails", []bool{false, true, false}, []string{"mqtt: down"}},
+		{"all fail", []bool{true, true, true}, []string{"stdout: down", "mqtt: down", "csv: down"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := []string{"stdout", "mqtt", "csv"}
+			children := make([]*recordingSink, len(names))
+			sinks := make([]Sink, len(names))
+			for i := range names {
+				children[i] = &recordingSink{}
+				if tt.failing[i] {
+					children[i].err = errors.New("down")
+				}
+				sinks[i] = children[i]
+			}
+			sink := newMultiSink(names, sinks, WriteTimeouts{}, NewFakeClock(testTime))
+
+			err := sink.Write(context.Background(), testReading(20, 0), testTime)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("Write() = %v, want errors %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Write() = %v, want it to contain %q", err, want)
+				}
+			}
+			for i, child := range children {
+				if want := map[bool]int{false: 1, true: 0}[tt.failing[i]]; child.count() != want {
+					t.Errorf("%s has %d readings, want %d", names[i], child.count(), want)
+				}
+			}
+
+			if err := sink.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+			for i, child := range children {
+				if !child.closed {
+					t.Errorf("%s wasn't closed", names[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMultiSinkRetriesOnlyFailedSinks(t *testing.T) {
+	ok, failing := &recordingSink{}, &recordingSink{err: errors.New("down")}
+	sink := newMultiSink([]string{"stdout", "mqtt"}, []Sink{ok, failing}, WriteTimeouts{}, NewFakeClock(testTime))
+
+	reading := testReading(20, 0)
+	if err := sink.Write(context.Background(), reading, testTime); err == nil {
+		t.Fatal("Write() = nil, want the mqtt error")
+	}
+	failing.setErr(nil)
+	if err := sink.Write(context.Background(), reading, testTime); err != nil {
+		t.Fatalf("retried Write() = %v", err)
+	}
+	if ok.count() != 1 || failing.count() != 1 {
+		t.Errorf("written %d and %d times, want once each", ok.count(), failing.count())
+	}
+
+	// A new point goes to every sink again
+	if err := sink.Write(context.Background(), testReading(21, time.Minute), testTime.Add(time.Minute)); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if ok.count() != 2 || failing.count() != 2 {
+		t.Errorf("written %d and %d times, want twice each", ok.count(), failing.count())
+	}
+}
+
+func TestMultiSinkSlowSink(t *testing.T) {
+	// A sink that doesn't return only holds up the others for its timeout,
+	// and isn't written to again until it returns
+
+	clock := NewFakeClock(testTime)
+	fast, slow := &recordingSink{}, newBlockingSink()
+	timeouts := WriteTimeouts{Sinks: map[string]time.Duration{"influx": 10 * time.Second}}
+	sink := newMultiSink([]string{"stdout", "influx"}, []Sink{fast, slow}, timeouts, clock)
+
+	written := make(chan error, 1)
+	go func() { written <- sink.Write(context.Background(), testReading(20, 0), testTime) }()
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	err, _ := receive(t, written)
+	if err == nil || !strings.Contains(err.Error(), "influx timed out") {
+		t.Errorf("Write() = %v, want the influx timeout", err)
+	}
+	if fast.count() != 1 {
+		t.Errorf("fast sink has %d readings, want 1", fast.count())
+	}
+
+	// The slow sink is still writing, so it fails straight away
+	err = sink.Write(context.Background(), testReading(21, time.Minute), testTime.Add(time.Minute))
+	if err == nil || !strings.Contains(err.Error(), "still hasn't returned") {
+		t.Errorf("Write() = %v, want the sink to be skipped", err)
+	}
+	if fast.count() != 2 {
+		t.Errorf("fast sink has %d readings, want 2", fast.count())
+	}
+
+	// Once it returns, retries reach it again
+	close(slow.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for slow.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := sink.Write(context.Background(), testReading(21, time.Minute), testTime.Add(time.Minute)); err != nil {
+		t.Errorf("retried Write() = %v", err)
+	}
+	if fast.count() != 2 || slow.count() != 2 {
+		t.Errorf("sinks have %d and %d readings, want 2 each", fast.count(), slow.count())
+	}
+}
+
+func TestMultiSinkClosesHungSinkAfterWrite(t *testing.T) {
+	// A sink whose write timed out isn't closed while the write is still
+	// using it
+	clock := NewFakeClock(testTime)
+	fast, slow := &recordingSink{}, newBlockingSink()
+	timeouts := WriteTimeouts{Sinks: map[string]time.Duration{"influx": 10 * time.Second}}
+	sink := newMultiSink([]string{"stdout", "influx"}, []Sink{fast, slow}, timeouts, clock)
+
+	written := make(chan error, 1)
+	go func() { written <- sink.Write(context.Background(), testReading(20, 0), testTime) }()
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	if err, _ := receive(t, written); err == nil {
+		t.Fatal("Write() = nil, want the influx timeout")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- sink.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close() = %v while a write was hung, want it to wait", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	slow.mu.Lock()
+	closedEarly := slow.closed
+	slow.mu.Unlock()
+	if closedEarly {
+		t.Error("the hung sink was closed while its write was running")
+	}
+
+	close(slow.release)
+	if err, _ := receive(t, closed); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if !fast.closed || !slow.closed {
+		t.Errorf("sinks closed = %v and %v, want both", fast.closed, slow.closed)
+	}
+	if slow.count() != 1 {
+		t.Errorf("hung sink has %d readings, want its write to have finished", slow.count())
+	}
+}
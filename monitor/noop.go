@@ -0,0 +1,29 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// NoopSink discards readings, which are still logged as they're written, for
+// checking the sensors without touching a database
+type NoopSink struct {
+	// Number of readings that would have been written
+	points int
+}
+
+func newNoopSink() *NoopSink {
+	return &NoopSink{}
+}
+
+func (s *NoopSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	s.points++
+	slog.Debug("Dry run, discarding reading", "points", s.points)
+	return nil
+}
+
+func (s *NoopSink) Close() error {
+	slog.Info("Dry run finished", "points_not_written", s.points)
+	return nil
+}
@@ -0,0 +1,139 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer and meter, and of the service in exported telemetry
+const otelName = "environmentmonitor"
+
+// How long to wait for telemetry to be exported on shutdown
+const otelShutdownTimeout = 5 * time.Second
+
+// Upper bounds in seconds of the histogram buckets of read and write durations
+var otelDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// otelInstruments records reads and writes as spans and metrics. A run
+// without `-otel_endpoint` has none, so there's no overhead when OpenTelemetry
+// isn't used.
+type otelInstruments struct {
+	clock         Clock
+	tracer        trace.Tracer
+	readDuration  metric.Float64Histogram
+	writeDuration metric.Float64Histogram
+	samples       metric.Int64Counter
+}
+
+func newOTelInstruments(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider, clock Clock) (*otelInstruments, error) {
+	meter := meterProvider.Meter(otelName)
+	readDuration, err := meter.Float64Histogram("environmentmonitor.sensor.read.duration",
+		metric.WithUnit("s"), metric.WithDescription("Time taken to read a sample from a sensor."),
+		metric.WithExplicitBucketBoundaries(otelDurationBuckets...))
+	if err != nil {
+		return nil, err
+	}
+	writeDuration, err := meter.Float64Histogram("environmentmonitor.sink.write.duration",
+		metric.WithUnit("s"), metric.WithDescription("Time taken to write a reading to the sink, including failed writes."),
+		metric.WithExplicitBucketBoundaries(otelDurationBuckets...))
+	if err != nil {
+		return nil, err
+	}
+	samples, err := meter.Int64Counter("environmentmonitor.samples",
+		metric.WithUnit("{sample}"), metric.WithDescription("Number of samples read from the sensors."))
+	if err != nil {
+		return nil, err
+	}
+
+	return &otelInstruments{
+		clock:         clock,
+		tracer:        tracerProvider.Tracer(otelName),
+		readDuration:  readDuration,
+		writeDuration: writeDuration,
+		samples:       samples,
+	}, nil
+}
+
+func setupOTel(ctx context.Context, endpoint string, clock Clock) (telemetry *otelInstruments, shutdown func(context.Context) error, err error) {
+	// Export traces and metrics over OTLP/HTTP to the collector at `endpoint`,
+	// e.g. http://localhost:4318, returning the instruments that record reads
+	// and writes. The returned function exports anything outstanding and
+	// stops.
+
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create the trace exporter: %v", err)
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create the metric exporter: %v", err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(otelName))
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+
+	telemetry, err = newOTelInstruments(tracerProvider, meterProvider, clock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create the instruments: %v", err)
+	}
+
+	return telemetry, func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}, nil
+}
+
+func (t *otelInstruments) startRead(ctx context.Context, label string) (context.Context, func(error)) {
+	// Start a span for a read of the sensor labelled `label`, returning its
+	// context and a function to call with the read's error once it's finished
+
+	attrs := []attribute.KeyValue{attribute.String("sensor", label)}
+	ctx, end := t.start(ctx, t.readDuration, "sensor.read", attrs)
+	return ctx, func(err error) {
+		end(err)
+		if err == nil {
+			t.samples.Add(ctx, 1, metric.WithAttributes(attrs...))
+		}
+	}
+}
+
+func (t *otelInstruments) startWrite(ctx context.Context) (context.Context, func(error)) {
+	// Start a span for a write to the sink, returning its context, which the
+	// write should be made with, and a function to call with the write's error
+	// once it's finished
+
+	return t.start(ctx, t.writeDuration, "sink.write", nil)
+}
+
+func (t *otelInstruments) start(ctx context.Context, duration metric.Float64Histogram, name string, attrs []attribute.KeyValue) (context.Context, func(error)) {
+	// Start a span called `name` as a child of any span in `ctx`. The returned
+	// function ends it, marking it as failed if its error isn't nil, and
+	// records its length in `duration`.
+
+	start := t.clock.Now()
+	ctx, span := t.tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		end := t.clock.Now()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End(trace.WithTimestamp(end))
+
+		attrs := append(attrs, attribute.Bool("error", err != nil))
+		duration.Record(ctx, end.Sub(start).Seconds(), metric.WithAttributes(attrs...))
+	}
+}
@@ -0,0 +1,175 @@
+package monitor
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testTelemetry is a set of instruments that keeps what they record in
+// memory
+type testTelemetry struct {
+	*otelInstruments
+	clock  *FakeClock
+	reader *sdkmetric.ManualReader
+	spans  *tracetest.SpanRecorder
+}
+
+func newTestTelemetry(t *testing.T) testTelemetry {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	spans := tracetest.NewSpanRecorder()
+	clock := NewFakeClock(testTime)
+	instruments, err := newOTelInstruments(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testTelemetry{instruments, clock, reader, spans}
+}
+
+func (tt testTelemetry) metric(t *testing.T, name string) (metricdata.Aggregation, bool) {
+	// The data recorded by the instrument called `name`, or false if it
+	// hasn't recorded any
+
+	t.Helper()
+	var data metricdata.ResourceMetrics
+	if err := tt.reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name == name {
+				return m.Data, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func TestOTelInstruments(t *testing.T) {
+	tests := []struct {
+		name      string
+		write     bool
+		err       error
+		histogram string
+		span      string
+		attrs     []attribute.KeyValue
+	}{
+		{"read", false, nil, "environmentmonitor.sensor.read.duration", "sensor.read",
+			[]attribute.KeyValue{attribute.String("sensor", "indoor"), attribute.Bool("error", false)}},
+		{"failed read", false, errReadFailed, "environmentmonitor.sensor.read.duration", "sensor.read",
+			[]attribute.KeyValue{attribute.String("sensor", "indoor"), attribute.Bool("error", true)}},
+		{"write", true, nil, "environmentmonitor.sink.write.duration", "sink.write",
+			[]attribute.KeyValue{attribute.Bool("error", false)}},
+		{"failed write", true, errWriteFailed, "environmentmonitor.sink.write.duration", "sink.write",
+			[]attribute.KeyValue{attribute.Bool("error", true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telemetry := newTestTelemetry(t)
+			var end func(error)
+			if tt.write {
+				_, end = telemetry.startWrite(context.Background())
+			} else {
+				_, end = telemetry.startRead(context.Background(), "indoor")
+			}
+			telemetry.clock.Advance(50 * time.Millisecond)
+			end(tt.err)
+
+			data, ok := telemetry.metric(t, tt.histogram)
+			if !ok {
+				t.Fatalf("nothing recorded in %s", tt.histogram)
+			}
+			histogram := data.(metricdata.Histogram[float64])
+			if len(histogram.DataPoints) != 1 {
+				t.Fatalf("%s has %d data points, want 1", tt.histogram, len(histogram.DataPoints))
+			}
+			point := histogram.DataPoints[0]
+			if point.Count != 1 || math.Abs(point.Sum-0.05) > 1e-9 {
+				t.Errorf("%s recorded %d durations totalling %vs, want one of 0.05s", tt.histogram, point.Count, point.Sum)
+			}
+			if want := attribute.NewSet(tt.attrs...); !point.Attributes.Equals(&want) {
+				t.Errorf("%s attributes = %v, want %v", tt.histogram, point.Attributes.ToSlice(), tt.attrs)
+			}
+
+			// Only successful reads are counted as samples
+			wantSamples := int64(0)
+			if !tt.write && tt.err == nil {
+				wantSamples = 1
+			}
+			gotSamples := int64(0)
+			if data, ok := telemetry.metric(t, "environmentmonitor.samples"); ok {
+				for _, point := range data.(metricdata.Sum[int64]).DataPoints {
+					gotSamples += point.Value
+				}
+			}
+			if gotSamples != wantSamples {
+				t.Errorf("counted %d samples, want %d", gotSamples, wantSamples)
+			}
+
+			spans := telemetry.spans.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("ended %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != tt.span {
+				t.Errorf("span name = %q, want %q", span.Name(), tt.span)
+			}
+			if !span.StartTime().Equal(testTime) || !span.EndTime().Equal(testTime.Add(50*time.Millisecond)) {
+				t.Errorf("span from %v to %v, want the 50ms from %v", span.StartTime(), span.EndTime(), testTime)
+			}
+			wantStatus := codes.Unset
+			if tt.err != nil {
+				wantStatus = codes.Error
+			}
+			if status := span.Status(); status.Code != wantStatus || (tt.err != nil && status.Description != tt.err.Error()) {
+				t.Errorf("span status = %v %q, want %v", status.Code, status.Description, wantStatus)
+			}
+		})
+	}
+}
+
+// spanSink records the span in the context of each write
+type spanSink struct {
+	recordingSink
+	spans []trace.SpanContext
+}
+
+func (s *spanSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	s.spans = append(s.spans, trace.SpanContextFromContext(ctx))
+	return s.recordingSink.Write(ctx, reading, t)
+}
+
+func TestOTelWriteSpanWrapsWrite(t *testing.T) {
+	// The sink is written to inside the write's span, so spans it starts are
+	// children of it
+	telemetry := newTestTelemetry(t)
+	config := testConfig()
+	config.Clock = telemetry.clock
+	state := newRunState(config)
+	state.telemetry = telemetry.otelInstruments
+
+	sink := &spanSink{}
+	if err := state.writeToSink(sink, testReading(20, 0), testTime); err != nil {
+		t.Fatal(err)
+	}
+	spans := telemetry.spans.Ended()
+	if len(spans) != 1 || len(sink.spans) != 1 {
+		t.Fatalf("ended %d spans for %d writes, want 1", len(spans), len(sink.spans))
+	}
+	if !sink.spans[0].IsValid() || !sink.spans[0].Equal(spans[0].SpanContext()) {
+		t.Errorf("written in span %v, want the sink.write span %v", sink.spans[0], spans[0].SpanContext())
+	}
+}
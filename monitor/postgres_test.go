@@ -0,0 +1,236 @@
+package monitor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDB is a database/sql driver that records the rows inserted in
+// committed transactions, failing inserts while `fail` is set
+type fakeDB struct {
+	mu        sync.Mutex
+	fail      bool
+	committed [][]driver.Value
+}
+
+// fakeConn is a connection to a `fakeDB`, which database/sql only uses for
+// one statement or transaction at a time
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+type fakeTx struct {
+	conn *fakeConn
+	rows [][]driver.Value
+}
+
+func (db *fakeDB) Open(name string) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c, query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.tx = &fakeTx{conn: c}
+	return c.tx, nil
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return strings.Count(s.query, "$") }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.db.mu.Lock()
+	defer s.conn.db.mu.Unlock()
+	if s.conn.db.fail {
+		return nil, errors.New("connection lost")
+	}
+	if s.conn.tx != nil {
+		s.conn.tx.rows = append(s.conn.tx.rows, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.conn.tx = nil
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.committed = append(db.committed, tx.rows...)
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+func (db *fakeDB) setFail(fail bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.fail = fail
+}
+
+func (db *fakeDB) rows() [][]driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.committed
+}
+
+// Number of fake drivers registered, as each needs its own name
+var fakeDrivers atomic.Int64
+
+func newFakePostgresSink(t *testing.T, batchSize int, output OutputConfig) (*PostgresSink, *fakeDB) {
+	// A Postgres sink writing to a fake database, prepared as
+	// `newPostgresSink` does
+	fake := &fakeDB{}
+	name := fmt.Sprintf("fakepostgres-%d", fakeDrivers.Add(1))
+	sql.Register(name, fake)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert, err := db.Prepare(insertSQL(`"readings"`, append(postgresColumns(output), tagNames...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PostgresSink{db: db, insert: insert, batchSize: batchSize, output: output}, fake
+}
+
+func TestInsertSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+		want   string
+	}{
+		{
+			"values",
+			Fields{Temperature: true, Pressure: true, Humidity: true},
+			`INSERT INTO "readings" ("time", "temperature_c", "pressure_hpa", "humidity_pct", "sensor", "host", "location", "device") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		},
+		{
+			"voltage",
+			Fields{Temperature: true, Pressure: true, Humidity: true, Voltage: true},
+			`INSERT INTO "readings" ("time", "temperature_c", "pressure_hpa", "humidity_pct", "voltage_v", "sensor", "host", "location", "device") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		},
+		{
+			"gas",
+			Fields{Temperature: true, Pressure: true, Humidity: true, Gas: true},
+			`INSERT INTO "readings" ("time", "temperature_c", "pressure_hpa", "humidity_pct", "gas_ohms", "sensor", "host", "location", "device") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		},
+		{
+			"sequence numbers",
+			Fields{Temperature: true, Seq: true},
+			`INSERT INTO "readings" ("time", "temperature_c", "pressure_hpa", "humidity_pct", "seq", "sensor", "host", "location", "device") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: tt.fields}
+			if got := insertSQL(`"readings"`, append(postgresColumns(output), tagNames...)); got != tt.want {
+				t.Errorf("insertSQL() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTableSQL(t *testing.T) {
+	output := OutputConfig{TemperatureUnit: Fahrenheit, PressureUnit: InchOfHg, Fields: Fields{Temperature: true}}
+	got := createTableSQL(`"readings"`, output)
+	for _, want := range []string{`CREATE TABLE IF NOT EXISTS "readings"`, "time TIMESTAMPTZ NOT NULL", `"temperature_f" DOUBLE PRECISION`, `"pressure_inhg" DOUBLE PRECISION`, "humidity_pct DOUBLE PRECISION", "location TEXT"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("createTableSQL() =\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "voltage_v") {
+		t.Errorf("createTableSQL() =\n%s\nwant no voltage column", got)
+	}
+}
+
+func TestPostgresSinkInserts(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		writes    int
+		// Rows committed before closing the sink
+		beforeClose int
+	}{
+		{"unbatched", 1, 3, 3},
+		{"batched", 3, 7, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Humidity: true}, Location: "cellar"}
+			sink, db := newFakePostgresSink(t, tt.batchSize, output)
+			for i := 0; i < tt.writes; i++ {
+				reading := testReading(20+float64(i), time.Duration(i)*time.Second)
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatalf("Write() = %v", err)
+				}
+			}
+			if got := len(db.rows()); got != tt.beforeClose {
+				t.Errorf("%d rows inserted before closing, want %d", got, tt.beforeClose)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+
+			rows := db.rows()
+			if len(rows) != tt.writes {
+				t.Fatalf("%d rows inserted, want %d", len(rows), tt.writes)
+			}
+			// time, temperature, pressure (not written), humidity, sensor,
+			// host, location, device
+			want := []driver.Value{testTime, 20.0, nil, 50.0, nil, nil, "cellar", nil}
+			for i, value := range rows[0] {
+				if got, ok := value.(time.Time); ok && !got.Equal(want[i].(time.Time)) || !ok && value != want[i] {
+					t.Errorf("first row column %d = %v, want %v", i+1, value, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresSinkKeepsBatchOnFailure(t *testing.T) {
+	output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}}
+	sink, db := newFakePostgresSink(t, 2, output)
+	write := func(i int) error {
+		reading := testReading(20, time.Duration(i)*time.Second)
+		return sink.Write(context.Background(), reading, reading.Time)
+	}
+
+	db.setFail(true)
+	if err := write(0); err != nil {
+		t.Fatalf("batched Write() = %v, want nil", err)
+	}
+	// The failed reading is left to the caller to retry, and the one
+	// batched before it is kept
+	if err := write(1); err == nil {
+		t.Fatal("Write() while the database is failing = nil, want an error")
+	}
+	db.setFail(false)
+	if err := write(1); err != nil {
+		t.Fatalf("retried Write() = %v", err)
+	}
+	if got := len(db.rows()); got != 2 {
+		t.Errorf("%d rows inserted, want 2", got)
+	}
+	sink.Close()
+}
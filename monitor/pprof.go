@@ -0,0 +1,27 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+func servePprof(ctx context.Context, addr string) {
+	// Serve the runtime profiles at /debug/pprof/ on `addr` until `ctx` is
+	// cancelled, to look into goroutine leaks and memory growth. They're
+	// served on their own mux, so they're only reachable on `addr`.
+
+	slog.Warn("Serving profiles, which expose internal details of the monitor", "addr", addr, "path", "/debug/pprof/")
+	serveUntilDone(ctx, &http.Server{Addr: addr, Handler: pprofHandler()}, "Profiling")
+}
+
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,36 @@
+package monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPprofHandler(t *testing.T) {
+	tests := []struct {
+		path     string
+		status   int
+		contains string
+	}{
+		{"/debug/pprof/", http.StatusOK, "goroutine"},
+		{"/debug/pprof/goroutine?debug=1", http.StatusOK, "TestPprofHandler"},
+		{"/debug/pprof/heap?debug=1", http.StatusOK, "heap profile"},
+		{"/debug/pprof/cmdline", http.StatusOK, ""},
+		{"/debug/pprof/nonexistent", http.StatusNotFound, "Unknown profile"},
+		// Nothing but the profiles is served
+		{"/metrics", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			pprofHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.status)
+			}
+			if body := recorder.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Errorf("body doesn't contain %q:\n%.500s", tt.contains, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// rateTracker works out how quickly successive averages from a sensor are
+// changing
+type rateTracker struct {
+	last     physic.Env
+	lastTime time.Time
+}
+
+func (r *rateTracker) update(reading *Reading, t time.Time) {
+	// Set the rate of change of `reading`, made at time `t`, since the
+	// previous one. The first reading has no rate.
+
+	if !r.lastTime.IsZero() && t.After(r.lastTime) {
+		minutes := t.Sub(r.lastTime).Minutes()
+		reading.Rate = &physic.Env{
+			Temperature: physic.Temperature(float64(reading.Temperature-r.last.Temperature) / minutes),
+			Pressure:    physic.Pressure(float64(reading.Pressure-r.last.Pressure) / minutes),
+			Humidity:    physic.RelativeHumidity(float64(reading.Humidity-r.last.Humidity) / minutes),
+		}
+	}
+	r.last, r.lastTime = reading.Env, t
+}
+
+func rateFields(rate *physic.Env, hasHumidity bool, output OutputConfig) map[string]float64 {
+	// Convert `rate` to the same units per minute as `convertEnv`, as fields
+	// named e.g. temp_rate. The humidity rate is omitted if `hasHumidity` is
+	// false, and the rates of values that aren't written.
+
+	fields := map[string]float64{
+		"temp_rate":     convertTempDifference(rate.Temperature, output.TemperatureUnit),
+		"pressure_rate": convertPressure(rate.Pressure, output.PressureUnit),
+	}
+	if hasHumidity {
+		fields["humidity_rate"] = float64(rate.Humidity) / float64(physic.PercentRH)
+	}
+	return output.Fields.filter(fields)
+}
@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateTracker(t *testing.T) {
+	// An average made `at` after the first
+	type average struct {
+		at                    time.Duration
+		temp, pressure, humid float64
+	}
+	tests := []struct {
+		name     string
+		averages []average
+		// Rates of temperature in °C, pressure in hPa and humidity in % per
+		// minute for each average after the first, which has none
+		want [][3]float64
+	}{
+		{
+			"steady",
+			[]average{{0, 20, 1013, 50}, {time.Minute, 20, 1013, 50}},
+			[][3]float64{{0, 0, 0}},
+		},
+		{
+			"per minute",
+			[]average{{0, 20, 1013, 50}, {2 * time.Minute, 21, 1012, 56}, {3 * time.Minute, 20.5, 1012, 56}},
+			[][3]float64{{0.5, -0.5, 3}, {-0.5, 0, 0}},
+		},
+		{
+			"under a minute",
+			[]average{{0, 20, 1013, 50}, {30 * time.Second, 20.5, 1013, 49}},
+			[][3]float64{{1, 0, -2}},
+		},
+	}
+	output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Pressure: true, Humidity: true}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rates rateTracker
+			for i, a := range tt.averages {
+				reading := testReading(a.temp, a.at)
+				reading.Pressure = pressureIn(a.pressure, Hectopascal)
+				reading.Humidity = percentRH(a.humid)
+				rates.update(&reading, reading.Time)
+
+				if i == 0 {
+					if reading.Rate != nil {
+						t.Errorf("first average has a rate of %v", reading.Rate)
+					}
+					if _, ok := derivedFields(reading, output)["temp_rate"]; ok {
+						t.Error("first average has a temp_rate field")
+					}
+					continue
+				}
+				if reading.Rate == nil {
+					t.Fatalf("average %d has no rate", i+1)
+				}
+				fields := rateFields(reading.Rate, true, output)
+				for j, name := range []string{"temp_rate", "pressure_rate", "humidity_rate"} {
+					if got, want := fields[name], tt.want[i-1][j]; math.Abs(got-want) > 1e-4 {
+						t.Errorf("average %d %s = %v, want %v", i+1, name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRateFieldsOmitted(t *testing.T) {
+	rate := testReading(1, 0).Env
+	tests := []struct {
+		name        string
+		fields      Fields
+		hasHumidity bool
+		want        []string
+	}{
+		{"all", Fields{Temperature: true, Pressure: true, Humidity: true}, true, []string{"humidity_rate", "pressure_rate", "temp_rate"}},
+		{"no humidity", Fields{Temperature: true, Pressure: true, Humidity: true}, false, []string{"pressure_rate", "temp_rate"}},
+		{"temp only", Fields{Temperature: true}, false, []string{"temp_rate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: tt.fields}
+			got := rateFields(&rate, tt.hasHumidity, output)
+			if len(got) != len(tt.want) {
+				t.Fatalf("fields = %v, want %v", got, tt.want)
+			}
+			for _, name := range tt.want {
+				if _, ok := got[name]; !ok {
+					t.Errorf("fields = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
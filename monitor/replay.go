@@ -0,0 +1,308 @@
+package monitor
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+type ReplayConfig struct {
+	// File of recorded readings to replay instead of reading the sensors:
+	// NDJSON as written by the stdout sink, or CSV as written by the csv sink
+	// if it ends in .csv. Either can be gzipped, ending in .gz.
+	Path string
+	// How many times faster than they were recorded the readings are
+	// replayed, or 0 to replay them as fast as they can be averaged
+	Speed float64
+}
+
+func readRecording(path string) ([]Reading, error) {
+	// The readings recorded in the file at `path`, in the order they were
+	// taken
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		unzipped, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer unzipped.Close()
+		r = unzipped
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	var records []jsonRecord
+	if strings.HasSuffix(name, ".csv") {
+		records, err = readCSVRecords(r)
+	} else {
+		records, err = readJSONRecords(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	readings := make([]Reading, len(records))
+	for i, record := range records {
+		if readings[i], err = record.reading(); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].Time.Before(readings[j].Time) })
+	return readings, nil
+}
+
+func readJSONRecords(r io.Reader) ([]jsonRecord, error) {
+	decoder := json.NewDecoder(r)
+	var records []jsonRecord
+	for {
+		var record jsonRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func readCSVRecords(r io.Reader) ([]jsonRecord, error) {
+	// Read the rows of a CSV file with a header, as written by the csv sink,
+	// into the records the stdout sink would have written for them. Rotated
+	// files each start with a header, so a header row part way through is
+	// skipped.
+
+	reader := csv.NewReader(r)
+	// Files written with and without the optional columns can be joined
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []jsonRecord
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) > 0 && row[0] == "time" {
+			header = row
+			continue
+		}
+
+		var record jsonRecord
+		values := map[string]**float64{
+			"temperature_c": &record.TemperatureC,
+			"temperature_f": &record.TemperatureF,
+			"temperature_k": &record.TemperatureK,
+			"pressure_pa":   &record.PressurePa,
+			"pressure_hpa":  &record.PressureHPa,
+			"pressure_kpa":  &record.PressureKPa,
+			"pressure_inhg": &record.PressureInHg,
+			"humidity_pct":  &record.Humidity,
+			"voltage_v":     &record.Voltage,
+			"gas_ohms":      &record.Gas,
+		}
+		for i, value := range row {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			switch name := header[i]; name {
+			case "time":
+				record.Time = value
+			case "sensor":
+				record.Sensor = value
+			case "seq":
+				seq, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid seq %q: %w", value, err)
+				}
+				record.Seq = &seq
+			default:
+				field, ok := values[name]
+				if !ok {
+					// Statistics, derived values and the other tags are
+					// worked out again
+					continue
+				}
+				number, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s %q: %w", name, value, err)
+				}
+				*field = &number
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+func (r jsonRecord) reading() (Reading, error) {
+	// The reading `r` was written for, without the values that are worked
+	// out from the readings, such as statistics and derived values. Values
+	// missing from `r` are left at zero.
+
+	t, err := time.Parse(time.RFC3339, r.Time)
+	if err != nil {
+		return Reading{}, fmt.Errorf("invalid time %q: %w", r.Time, err)
+	}
+	reading := Reading{Label: r.Sensor, Time: t}
+	for unit, value := range map[TemperatureUnit]*float64{Celsius: r.TemperatureC, Fahrenheit: r.TemperatureF, Kelvin: r.TemperatureK} {
+		if value != nil {
+			reading.Temperature = temperatureIn(*value, unit)
+		}
+	}
+	for unit, value := range map[PressureUnit]*float64{Pascal: r.PressurePa, Hectopascal: r.PressureHPa, Kilopascal: r.PressureKPa, InchOfHg: r.PressureInHg} {
+		if value != nil {
+			reading.Pressure = pressureIn(*value, unit)
+		}
+	}
+	if r.Humidity != nil {
+		reading.HasHumidity = true
+		reading.Humidity = physic.RelativeHumidity(*r.Humidity * float64(physic.PercentRH))
+	}
+	if r.Voltage != nil {
+		v := physic.ElectricPotential(*r.Voltage * float64(physic.Volt))
+		reading.Voltage = &v
+	}
+	if r.Gas != nil {
+		g := physic.ElectricResistance(*r.Gas * float64(physic.Ohm))
+		reading.Gas = &g
+	}
+	if r.Seq != nil {
+		reading.Seq = *r.Seq
+	}
+	return reading, nil
+}
+
+func (s *runState) replay(ctx context.Context, config Config) error {
+	// Feed the readings recorded in `config.Replay.Path` through the
+	// averaging and sinks as if they had just been read, keeping the times
+	// they were recorded at. Each sensor label in the file is averaged
+	// separately, as the sensors are when they're read.
+
+	readings, err := readRecording(config.Replay.Path)
+	if err != nil {
+		return fmt.Errorf("could not read the recording %s: %w", config.Replay.Path, err)
+	}
+	if len(readings) == 0 {
+		return fmt.Errorf("no readings recorded in %s", config.Replay.Path)
+	}
+	if config.MaxSamples > 0 && len(readings) > config.MaxSamples {
+		readings = readings[:config.MaxSamples]
+	}
+
+	sink, err := newSink(config)
+	if err != nil {
+		return fmt.Errorf("could not create the sink: %w", err)
+	}
+	if len(config.Alerts.Thresholds) > 0 {
+		alerts, stop := setupAlerts(config.Alerts, config.Output)
+		s.alerts = alerts
+		defer stop()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	windowSize := new(atomic.Int64)
+	windowSize.Store(int64(config.WindowSize))
+	sensorWindows := map[string]int{}
+	for _, sensor := range config.Sensors {
+		sensorWindows[sensor.Label] = sensor.WindowSize
+	}
+	inputs := map[string]chan Reading{}
+	var averages []<-chan Reading
+	for _, reading := range readings {
+		if _, ok := inputs[reading.Label]; ok {
+			continue
+		}
+		input := make(chan Reading, config.ChannelBuffer)
+		inputs[reading.Label] = input
+		window := windowSize
+		if size := sensorWindows[reading.Label]; size > 0 {
+			window = new(atomic.Int64)
+			window.Store(int64(size))
+		}
+		averages = append(averages, s.averageReadings(config, input, window))
+	}
+	averaged := mergeReadings(averages, config.ChannelBuffer)
+
+	written := make(chan error, 1)
+	go func() {
+		err := s.logToSink(sink, config.Write, averaged)
+		if err != nil {
+			cancel()
+			for range averaged {
+			}
+		}
+		// Closed before the replay returns, so the points it holds are
+		// written by then
+		closeSink(sink, "averages")
+		written <- err
+	}()
+
+	slog.Info("Replaying readings", "path", config.Replay.Path, "readings", len(readings),
+		"from", readings[0].Time, "to", readings[len(readings)-1].Time, "speed", config.Replay.Speed)
+	replayed := replayReadings(ctx, s.clock, readings, config.Replay.Speed, inputs)
+	for _, input := range inputs {
+		close(input)
+	}
+	if err := <-written; err != nil {
+		return fmt.Errorf("stopped writing: %w", err)
+	}
+	slog.Info("Replay finished", "replayed", replayed, "readings", len(readings))
+	return nil
+}
+
+func replayReadings(ctx context.Context, clock Clock, readings []Reading, speed float64, inputs map[string]chan Reading) int {
+	// Send each of `readings` to the input for its sensor, spaced out by the
+	// time between them divided by `speed`, or straight away if `speed` is 0,
+	// until `ctx` is cancelled. Returns the number sent.
+
+	for i, reading := range readings {
+		if i > 0 && speed > 0 {
+			wait := time.Duration(float64(reading.Time.Sub(readings[i-1].Time)) / speed)
+			if wait > 0 {
+				timer := clock.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return i
+				case <-timer.C():
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return i
+		case inputs[reading.Label] <- reading:
+		}
+	}
+	return len(readings)
+}
@@ -0,0 +1,226 @@
+package monitor
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const recordedJSON = `{"temperature_f":68,"pressure_hpa":1013,"humidity_pct":50,"time":"2024-01-01T12:01:00Z","sensor":"b"}
+{"temperature_c":20,"pressure_hpa":1013,"time":"2024-01-01T12:00:00Z","sensor":"a","stats":{"temp_min":19}}
+`
+
+// Rotated files each start with a header, and may have different columns
+const recordedCSV = `time,sensor,temperature_c,pressure_hpa,humidity_pct,temp_min
+2024-01-01T12:00:00Z,a,20,1013,,19
+time,sensor,temperature_c,pressure_hpa,humidity_pct
+2024-01-01T12:01:00Z,b,20,1013,50
+`
+
+func writeRecording(t *testing.T, name, contents string) string {
+	// Write `contents` to a file called `name` in a temporary directory,
+	// gzipping it if the name ends in .gz
+
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if !strings.HasSuffix(name, ".gz") {
+		if _, err := file.WriteString(contents); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	zipped := gzip.NewWriter(file)
+	if _, err := zipped.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zipped.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadRecording(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		wantErr  string
+	}{
+		{"NDJSON", "readings.ndjson", recordedJSON, ""},
+		{"CSV", "readings.csv", recordedCSV, ""},
+		{"gzipped NDJSON", "readings.ndjson.gz", recordedJSON, ""},
+		{"gzipped CSV", "readings.csv.gz", recordedCSV, ""},
+		{"invalid time", "readings.ndjson", `{"temperature_c":20,"time":"noon"}`, "record 1: invalid time"},
+		{"invalid JSON", "readings.ndjson", recordedJSON + "{\n", "record 3"},
+		{"invalid CSV value", "readings.csv", "time,temperature_c\n2024-01-01T12:00:00Z,warm\n", "invalid temperature_c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readings, err := readRecording(writeRecording(t, tt.file, tt.contents))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readRecording() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Readings are in time order, whatever order they were recorded in
+			want := []struct {
+				label       string
+				at          time.Duration
+				hasHumidity bool
+			}{
+				{"a", 0, false},
+				{"b", time.Minute, true},
+			}
+			if len(readings) != len(want) {
+				t.Fatalf("read %d readings, want %d", len(readings), len(want))
+			}
+			for i, w := range want {
+				reading := readings[i]
+				if reading.Label != w.label || !reading.Time.Equal(testTime.Add(w.at)) || reading.HasHumidity != w.hasHumidity {
+					t.Errorf("reading %d = %q at %v with humidity %v, want %q at %v with humidity %v",
+						i+1, reading.Label, reading.Time, reading.HasHumidity, w.label, testTime.Add(w.at), w.hasHumidity)
+				}
+				temp, pressure, _ := convertEnv(reading.Env, testConfig().Output)
+				if math.Abs(temp-20) > 1e-6 || math.Abs(pressure-1013) > 1e-6 {
+					t.Errorf("reading %d = %v°C, %vhPa, want 20°C, 1013hPa", i+1, temp, pressure)
+				}
+			}
+		})
+	}
+}
+
+func TestReadRecordingMissingFile(t *testing.T) {
+	if _, err := readRecording(filepath.Join(t.TempDir(), "missing.ndjson")); !os.IsNotExist(err) {
+		t.Errorf("readRecording() error = %v, want a missing file", err)
+	}
+}
+
+func TestReplayReadingsSpeed(t *testing.T) {
+	// Readings recorded a minute and then two minutes apart are replayed a
+	// second and then two seconds apart at 60 times the speed
+	clock := NewFakeClock(testTime)
+	readings := []Reading{testReading(20, 0), testReading(21, time.Minute), testReading(22, 3*time.Minute)}
+	input := make(chan Reading, len(readings))
+	replayed := make(chan int, 1)
+	go func() {
+		replayed <- replayReadings(context.Background(), clock, readings, 60, map[string]chan Reading{"": input})
+	}()
+
+	for i, gap := range []time.Duration{0, time.Second, 2 * time.Second} {
+		if gap > 0 {
+			clock.BlockUntil(1)
+			clock.Advance(gap - time.Millisecond)
+			if len(input) > 0 {
+				t.Fatalf("reading %d replayed early", i+1)
+			}
+			clock.Advance(time.Millisecond)
+		}
+		reading, _ := receive(t, input)
+		if !reading.Time.Equal(readings[i].Time) {
+			t.Errorf("replayed reading %d recorded at %v, want %v", i+1, reading.Time, readings[i].Time)
+		}
+	}
+	if n, _ := receive(t, replayed); n != len(readings) {
+		t.Errorf("replayReadings() = %d, want %d", n, len(readings))
+	}
+}
+
+func TestReplayReadingsStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	input := make(chan Reading)
+	readings := []Reading{testReading(20, 0), testReading(21, time.Minute)}
+	if n := replayReadings(ctx, NewFakeClock(testTime), readings, 0, map[string]chan Reading{"": input}); n != 0 {
+		t.Errorf("replayReadings() = %d after cancelling, want 0", n)
+	}
+}
+
+func TestRunReplaysRecording(t *testing.T) {
+	// Recorded readings are averaged and written as if they had just been
+	// read, with the times they were recorded at
+	var recording strings.Builder
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&recording, `{"temperature_c":20,"pressure_hpa":1013,"humidity_pct":50,"time":"2024-01-01T12:%02d:00Z"}`+"\n", i)
+	}
+	path := filepath.Join(t.TempDir(), "averages.csv")
+	config := testConfig()
+	config.Clock = NewFakeClock(testTime)
+	config.WindowSize = 2
+	config.DryRun = false
+	config.Sinks = []string{"csv"}
+	config.CSV = CSVConfig{Path: path, SyncInterval: time.Second}
+	config.Replay = ReplayConfig{Path: writeRecording(t, "readings.ndjson", recording.String())}
+
+	if err := Run(context.Background(), config); err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(rows) != 3 {
+		t.Fatalf("wrote %d rows, want a header and two averages:\n%s", len(rows), data)
+	}
+	for i, at := range []string{"2024-01-01T12:01:00Z", "2024-01-01T12:03:00Z"} {
+		if !strings.HasPrefix(rows[i+1], at) {
+			t.Errorf("average %d = %q, want one at %s", i+1, rows[i+1], at)
+		}
+	}
+}
+
+func TestRunReplayStopsAfterMaxSamples(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSamples int
+		want       int
+	}{
+		{"no limit", 0, 4},
+		{"fewer than recorded", 2, 2},
+		{"more than recorded", 10, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recording strings.Builder
+			for i := 0; i < 4; i++ {
+				fmt.Fprintf(&recording, `{"temperature_c":20,"pressure_hpa":1013,"humidity_pct":50,"time":"2024-01-01T12:%02d:00Z"}`+"\n", i)
+			}
+			path := filepath.Join(t.TempDir(), "averages.csv")
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			config.WindowSize = 1
+			config.MaxSamples = tt.maxSamples
+			config.DryRun = false
+			config.Sinks = []string{"csv"}
+			config.CSV = CSVConfig{Path: path, SyncInterval: time.Second}
+			config.Replay = ReplayConfig{Path: writeRecording(t, "readings.ndjson", recording.String())}
+
+			if err := Run(context.Background(), config); err != nil {
+				t.Fatalf("Run() = %v, want nil", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows := strings.Split(strings.TrimSpace(string(data)), "\n"); len(rows)-1 != tt.want {
+				t.Errorf("wrote %d readings, want %d:\n%s", len(rows)-1, tt.want, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package monitor
+
+// ring holds the latest values pushed to it, up to its size, overwriting the
+// oldest as new ones are pushed. It's used for the sliding averaging window
+// and the history of averages.
+type ring[T any] struct {
+	values []T
+	// Index of the oldest value, and the number held
+	start, count int
+}
+
+func (r *ring[T]) resize(size int) {
+	// Hold up to `size` values, keeping the latest of those already held
+
+	if size == len(r.values) {
+		return
+	}
+	held := r.ordered()
+	if len(held) > size {
+		held = held[len(held)-size:]
+	}
+	r.values = make([]T, size)
+	copy(r.values, held)
+	r.start, r.count = 0, len(held)
+}
+
+func (r *ring[T]) push(value T) {
+	// Add `value`, overwriting the oldest value if the ring is full. A ring of
+	// size 0 holds nothing.
+
+	if len(r.values) == 0 {
+		return
+	}
+	if r.count < len(r.values) {
+		r.values[(r.start+r.count)%len(r.values)] = value
+		r.count++
+		return
+	}
+	r.values[r.start] = value
+	r.start = (r.start + 1) % len(r.values)
+}
+
+func (r *ring[T]) size() int {
+	return len(r.values)
+}
+
+func (r *ring[T]) full() bool {
+	return r.count == len(r.values)
+}
+
+func (r *ring[T]) ordered() []T {
+	// The values held, oldest first
+
+	values := make([]T, r.count)
+	for i := range values {
+		values[i] = r.values[(r.start+i)%len(r.values)]
+	}
+	return values
+}
@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		pushed []int
+		// Size it's changed to after the values are pushed, or -1 to keep it
+		resize int
+		want   []int
+		full   bool
+	}{
+		{"empty", 3, nil, -1, []int{}, false},
+		{"filling", 3, []int{1, 2}, -1, []int{1, 2}, false},
+		{"full", 3, []int{1, 2, 3}, -1, []int{1, 2, 3}, true},
+		{"overwrites the oldest", 3, []int{1, 2, 3, 4, 5}, -1, []int{3, 4, 5}, true},
+		{"shrunk keeps the latest", 4, []int{1, 2, 3, 4, 5}, 2, []int{4, 5}, true},
+		{"grown waits to fill", 2, []int{1, 2, 3}, 4, []int{2, 3}, false},
+		{"size 0 holds nothing", 0, []int{1, 2}, -1, []int{}, true},
+		{"shrunk to 0", 3, []int{1, 2}, 0, []int{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r ring[int]
+			r.resize(tt.size)
+			for _, value := range tt.pushed {
+				r.push(value)
+			}
+			if tt.resize >= 0 {
+				r.resize(tt.resize)
+			}
+
+			if got := r.ordered(); !slices.Equal(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+			if r.full() != tt.full {
+				t.Errorf("full() = %v, want %v", r.full(), tt.full)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/host/v3"
+)
+
+// Addresses that BMx80 sensors can be strapped to
+var sensorAddresses = []uint16{0x76, 0x77}
+
+// Register holding the chip ID on every BMx80 sensor
+const chipIDRegister = 0xD0
+
+// Sensors identified by their chip ID
+var chipIDs = map[byte]string{
+	0x55: "BMP180",
+	0x56: "BMP280 (sample)",
+	0x57: "BMP280 (sample)",
+	0x58: "BMP280",
+	0x60: "BME280",
+	0x61: "BME680",
+}
+
+func ListBuses(w io.Writer) error {
+	// Load the drivers and print each registered I²C bus, along with which of
+	// the BMx80 addresses respond on it and what's there, to help check the
+	// wiring without starting the monitor
+
+	if _, err := host.Init(); err != nil {
+		return fmt.Errorf("could not load the drivers: %w", err)
+	}
+	scanBuses(w, i2creg.All(), sensorAddresses)
+	return nil
+}
+
+func scanBuses(w io.Writer, refs []*i2creg.Ref, addresses []uint16) {
+	// Print each of `refs` and what responds at each of `addresses` on it.
+	// Buses that can't be opened are reported, and the rest still scanned.
+
+	if len(refs) == 0 {
+		fmt.Fprintln(w, "No I²C buses found")
+		return
+	}
+	for _, ref := range refs {
+		name := describeBus(ref)
+		bus, err := ref.Open()
+		if err != nil {
+			fmt.Fprintf(w, "%s: could not open: %v\n", name, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", name)
+		for _, address := range addresses {
+			fmt.Fprintf(w, "  %#02x: %s\n", address, probeAddress(bus, address))
+		}
+		bus.Close()
+	}
+}
+
+func probeAddress(bus i2c.Bus, address uint16) string {
+	// Describe what's at `address` on `bus`, by reading the chip ID register
+	// that BMx80 sensors have
+
+	id := make([]byte, 1)
+	if err := bus.Tx(address, []byte{chipIDRegister}, id); err != nil {
+		return "no response"
+	}
+	if model, ok := chipIDs[id[0]]; ok {
+		return fmt.Sprintf("%s (chip ID %#02x)", model, id[0])
+	}
+	return fmt.Sprintf("unknown device (chip ID %#02x)", id[0])
+}
+
+func TestSensor(w io.Writer, config Config, count int, interval time.Duration) error {
+	// Open the sensors described by `config` and print `count` readings from
+	// each, `interval` apart, to check a sensor works without setting up a
+	// sink. Each read gives up after `config.ReadTimeout`, if it's set.
+
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+	sensors, closeBus, err := startSensors(config)
+	if err != nil {
+		return err
+	}
+	defer closeBus()
+	defer func() {
+		for _, sensor := range sensors {
+			if !sensor.readInProgress() {
+				sensor.dev.Halt()
+			}
+		}
+	}()
+
+	failures := 0
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			config.Clock.Sleep(interval)
+		}
+		for j := range sensors {
+			sensor := &sensors[j]
+			if sensor.readInProgress() {
+				fmt.Fprintf(w, "%s: still waiting for an earlier read\n", sensor.dev)
+				failures++
+				continue
+			}
+			reading := Reading{HasHumidity: sensor.hasHumidity, Label: sensor.label}
+			err := senseWithTimeout(config.Clock, sensor.dev, &reading.Env, config.ReadTimeout)
+			if sensor.abandoned(err); err != nil {
+				fmt.Fprintf(w, "%s: read failed: %v\n", sensor.dev, err)
+				failures++
+				continue
+			}
+			fmt.Fprintf(w, "%s: %s\n", sensor.dev, reading)
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d reads failed", failures, count*len(sensors))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/conn/v3/physic"
+)
+
+// fakeBus is an I²C bus with a device at each of its addresses that answers
+// reads with its chip ID
+type fakeBus struct {
+	chipIDs map[uint16]byte
+	closed  bool
+}
+
+var errNoDevice = errors.New("no device at address")
+
+func (b *fakeBus) Tx(addr uint16, w, r []byte) error {
+	id, ok := b.chipIDs[addr]
+	if !ok {
+		return errNoDevice
+	}
+	r[0] = id
+	return nil
+}
+
+func (b *fakeBus) SetSpeed(physic.Frequency) error { return nil }
+func (b *fakeBus) String() string                  { return "fake" }
+func (b *fakeBus) Close() error {
+	b.closed = true
+	return nil
+}
+
+func fakeBusRef(name string, aliases []string, bus *fakeBus) *i2creg.Ref {
+	return &i2creg.Ref{Name: name, Aliases: aliases, Open: func() (i2c.BusCloser, error) {
+		if bus == nil {
+			return nil, errors.New("permission denied")
+		}
+		return bus, nil
+	}}
+}
+
+func TestScanBuses(t *testing.T) {
+	first := &fakeBus{chipIDs: map[uint16]byte{0x76: 0x60, 0x77: 0x42}}
+	second := &fakeBus{chipIDs: map[uint16]byte{0x77: 0x61}}
+	tests := []struct {
+		name string
+		refs []*i2creg.Ref
+		// Buses that should be closed after the scan
+		opened []*fakeBus
+		want   string
+	}{
+		{"no buses", nil, nil, "No I²C buses found\n"},
+		{
+			"known, unknown and missing devices",
+			[]*i2creg.Ref{fakeBusRef("/dev/i2c-1", []string{"I2C1"}, first)},
+			[]*fakeBus{first},
+			"/dev/i2c-1 (I2C1):\n  0x76: BME280 (chip ID 0x60)\n  0x77: unknown device (chip ID 0x42)\n",
+		},
+		{
+			"a bus that can't be opened",
+			[]*i2creg.Ref{fakeBusRef("/dev/i2c-1", nil, nil), fakeBusRef("/dev/i2c-2", nil, second)},
+			[]*fakeBus{second},
+			"/dev/i2c-1: could not open: permission denied\n/dev/i2c-2:\n  0x76: no response\n  0x77: BME680 (chip ID 0x61)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			scanBuses(&out, tt.refs, sensorAddresses)
+			if got := out.String(); got != tt.want {
+				t.Errorf("scanBuses() printed\n%s\nwant\n%s", got, tt.want)
+			}
+			for i, bus := range tt.opened {
+				if !bus.closed {
+					t.Errorf("bus %d wasn't closed", i+1)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/devices/v3/bmxx80"
+)
+
+// Values accepted by the oversampling flags, mapped to the sensor's settings
+var oversamplings = map[string]bmxx80.Oversampling{
+	"off": bmxx80.Off,
+	"1":   bmxx80.O1x,
+	"2":   bmxx80.O2x,
+	"4":   bmxx80.O4x,
+	"8":   bmxx80.O8x,
+	"16":  bmxx80.O16x,
+}
+
+// Values accepted by the `-iir_filter` flag, mapped to the sensor's settings
+var filters = map[string]bmxx80.Filter{
+	"off": bmxx80.NoFilter,
+	"2":   bmxx80.F2,
+	"4":   bmxx80.F4,
+	"8":   bmxx80.F8,
+	"16":  bmxx80.F16,
+}
+
+func parseOversampling(name, value string) (bmxx80.Oversampling, error) {
+	// Look up the oversampling `value` given for the `name` measurement
+
+	o, ok := oversamplings[strings.ToLower(value)]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s oversampling %q: must be off, 1, 2, 4, 8 or 16", name, value)
+	}
+	return o, nil
+}
+
+func ParseSensorOpts(temperature, pressure, humidity, filter string) (opts bmxx80.Opts, err error) {
+	// Build the sensor settings from the values of the oversampling and filter
+	// flags
+
+	if opts.Temperature, err = parseOversampling("temperature", temperature); err != nil {
+		return
+	}
+	if opts.Temperature == bmxx80.Off {
+		// Pressure and humidity are compensated using the temperature
+		return opts, fmt.Errorf("temperature oversampling can't be off, as it's needed to measure pressure and humidity")
+	}
+	if opts.Pressure, err = parseOversampling("pressure", pressure); err != nil {
+		return
+	}
+	if opts.Humidity, err = parseOversampling("humidity", humidity); err != nil {
+		return
+	}
+
+	var ok bool
+	if opts.Filter, ok = filters[strings.ToLower(filter)]; !ok {
+		return opts, fmt.Errorf("invalid IIR filter %q: must be off, 2, 4, 8 or 16", filter)
+	}
+	return opts, nil
+}
+
+// A Sensor measures the environment, such as a BME280
+type Sensor interface {
+	Sense(env *physic.Env) error
+	Halt() error
+}
+
+func sensorHasHumidity(dev Sensor) bool {
+	// Only the BME280 measures humidity, the BMP280 and BMP180 don't
+	if bmx, ok := dev.(*bmxx80.Dev); ok {
+		return strings.HasPrefix(bmx.String(), "BME")
+	}
+	return true
+}
+
+// MockSensor stands in for a real sensor, so the monitor can be run without
+// any hardware. It either replays a sequence of readings, or generates slowly
+// varying sine waves.
+type MockSensor struct {
+	mu       sync.Mutex
+	readings []physic.Env
+	next     int
+	start    time.Time
+	clock    Clock
+	// Whether the sensor also measures gas resistance, like a BME680
+	gas bool
+}
+
+// Period of the sine waves generated by a MockSensor
+const mockPeriod = 10 * time.Minute
+
+func newMockSensor(readings []physic.Env, clock Clock) *MockSensor {
+	// Create a sensor that replays `readings` in order, starting again from the
+	// first once they run out. If `readings` is empty, the sensor generates
+	// sine waves instead, following the time of `clock`.
+
+	return &MockSensor{readings: readings, start: clock.Now(), clock: clock}
+}
+
+func (m *MockSensor) String() string {
+	return "MockSensor"
+}
+
+func (m *MockSensor) Sense(env *physic.Env) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.readings) > 0 {
+		*env = m.readings[m.next]
+		m.next = (m.next + 1) % len(m.readings)
+		return nil
+	}
+
+	// Around 20°C, 1013hPa and 50%rH
+	phase := m.phase()
+	env.Temperature = physic.ZeroCelsius + physic.Temperature((20+5*phase)*float64(physic.Kelvin))
+	env.Pressure = physic.Pressure((1013 + 10*phase) * float64(100*physic.Pascal))
+	env.Humidity = physic.RelativeHumidity((50 + 20*phase) * float64(physic.PercentRH))
+	return nil
+}
+
+func (m *MockSensor) Gas() (physic.ElectricResistance, bool) {
+	// Around 50kΩ, falling as the other values rise, or nothing if the sensor
+	// doesn't measure gas
+
+	return physic.ElectricResistance((50 - 10*m.phase()) * float64(physic.KiloOhm)), m.gas
+}
+
+func (m *MockSensor) phase() float64 {
+	// Where the sine waves are in their period, from -1 to 1
+	return math.Sin(2 * math.Pi * float64(m.clock.Now().Sub(m.start)) / float64(mockPeriod))
+}
+
+func (m *MockSensor) Halt() error {
+	return nil
+}
+
+func ParseMockReadings(value string) ([]physic.Env, error) {
+	// Parse readings for a MockSensor, given as a comma separated list of
+	// <°C>:<hPa>:<%rH>, e.g. 21.5:1013.2:45,21.6:1013.1:46
+
+	readings := []physic.Env{}
+	if value == "" {
+		return readings, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid mock reading %q: expected <°C>:<hPa>:<%%rH>", entry)
+		}
+
+		values := [3]float64{}
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mock reading %q: %v", entry, err)
+			}
+			values[i] = v
+		}
+
+		readings = append(readings, NewEnv(values[0], values[1], values[2]))
+	}
+	return readings, nil
+}
+
+func NewEnv(celsius, hectopascals, percentRH float64) physic.Env {
+	return physic.Env{
+		Temperature: physic.ZeroCelsius + physic.Temperature(celsius*float64(physic.Kelvin)),
+		Pressure:    physic.Pressure(hectopascals * float64(100*physic.Pascal)),
+		Humidity:    physic.RelativeHumidity(percentRH * float64(physic.PercentRH)),
+	}
+}
@@ -0,0 +1,704 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/devices/v3/bmxx80"
+)
+
+func TestMockSensorFollowsClock(t *testing.T) {
+	tests := []struct {
+		elapsed  time.Duration
+		temp     float64
+		pressure float64
+		humidity float64
+	}{
+		{0, 20, 1013, 50},
+		{mockPeriod / 4, 25, 1023, 70},
+		{mockPeriod / 2, 20, 1013, 50},
+		{mockPeriod * 3 / 4, 15, 1003, 30},
+		{mockPeriod, 20, 1013, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.elapsed.String(), func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			sensor := newMockSensor(nil, clock)
+			clock.Advance(tt.elapsed)
+
+			var env physic.Env
+			if err := sensor.Sense(&env); err != nil {
+				t.Fatal(err)
+			}
+			got := []float64{env.Temperature.Celsius(), float64(env.Pressure) / float64(100*physic.Pascal), float64(env.Humidity) / float64(physic.PercentRH)}
+			want := []float64{tt.temp, tt.pressure, tt.humidity}
+			for i := range got {
+				if math.Abs(got[i]-want[i]) > 1e-3 {
+					t.Errorf("Sense() = %v, want %v", got, want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestMockSensorReplaysReadings(t *testing.T) {
+	readings := []physic.Env{{Temperature: celsius(1)}, {Temperature: celsius(2)}}
+	sensor := newMockSensor(readings, NewFakeClock(testTime))
+	for i, want := range []float64{1, 2, 1} {
+		var env physic.Env
+		sensor.Sense(&env)
+		if got := env.Temperature.Celsius(); math.Abs(got-want) > 1e-6 {
+			t.Errorf("read %d = %v°C, want %v°C", i+1, got, want)
+		}
+	}
+}
+
+// Bounds that every reading is within
+var testBounds = Bounds{Max: physic.Env{Temperature: physic.ZeroCelsius + 1000*physic.Kelvin, Pressure: 2000 * 100 * physic.Pascal, Humidity: 100 * physic.PercentRH}}
+
+func TestSensorWithoutHumidity(t *testing.T) {
+	// A BMP280 is read like a BME280, but its readings are written without
+	// any of the humidity fields
+
+	tests := []struct {
+		name        string
+		hasHumidity bool
+	}{
+		{"BME280", true},
+		{"BMP280", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			state := newRunState(config)
+			sensor := newMockSensor(nil, clock)
+
+			var window accumulator
+			for i := 0; i < 3; i++ {
+				reading, ok, err := state.readSensor(sensor, "", tt.hasHumidity, nil, uint64(i+1), testBounds, 0)
+				if !ok || err != nil {
+					t.Fatalf("readSensor() = %v, %v", ok, err)
+				}
+				window.add(reading)
+				clock.Advance(time.Minute)
+			}
+			average := window.average(mean, "end")
+
+			var humidity []string
+			for _, field := range newInfluxPoint(average, average.Time, "environment", config.Output).FieldList() {
+				if strings.Contains(field.Key, "humidity") || field.Key == "dew_point" || field.Key == "heat_index" {
+					humidity = append(humidity, field.Key)
+				}
+			}
+			if tt.hasHumidity && len(humidity) == 0 {
+				t.Error("point has no humidity fields")
+			}
+			if !tt.hasHumidity && len(humidity) > 0 {
+				t.Errorf("point has humidity fields %v", humidity)
+			}
+
+			record := newJSONRecord(average, average.Time, config.Output)
+			if got := record.Humidity != nil; got != tt.hasHumidity {
+				t.Errorf("JSON record has humidity = %v, want %v", got, tt.hasHumidity)
+			}
+		})
+	}
+}
+
+// flakySensor is a mock sensor whose reads fail when their index is in
+// `fail`
+type flakySensor struct {
+	*MockSensor
+	fail  map[int]bool
+	reads int
+	halts int
+}
+
+var errReadFailed = errors.New("read failed")
+
+func (s *flakySensor) Sense(env *physic.Env) error {
+	defer func() { s.reads++ }()
+	if s.fail[s.reads] {
+		return errReadFailed
+	}
+	return s.MockSensor.Sense(env)
+}
+
+func (s *flakySensor) Halt() error {
+	s.halts++
+	return nil
+}
+
+func newTestSensorReader(state *runState, dev Sensor) *sensorReader {
+	return &sensorReader{
+		dev:         dev,
+		hasHumidity: true,
+		reopen:      func() (Sensor, error) { return dev, nil },
+		logging:     make(chan Reading, 100),
+		dropPolicy:  "oldest",
+		run:         state,
+	}
+}
+
+func TestSensorReaderSurvivesFailedReads(t *testing.T) {
+	tests := []struct {
+		name        string
+		reads       int
+		fail        map[int]bool
+		maxFailures int
+		// Index of the read that gives up, or -1 if none does
+		givesUp  int
+		readings int
+	}{
+		{"no failures", 4, nil, 3, -1, 4},
+		{"intermittent", 6, map[int]bool{1: true, 2: true, 4: true}, 3, -1, 3},
+		{"gives up after consecutive failures", 5, map[int]bool{1: true, 2: true, 3: true}, 3, 3, 1},
+		{"never gives up without a limit", 5, map[int]bool{0: true, 1: true, 2: true, 3: true}, 0, -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			sensor := &flakySensor{MockSensor: newMockSensor(nil, clock), fail: tt.fail}
+			reader := newTestSensorReader(newRunState(config), sensor)
+
+			for i := 0; i < tt.reads; i++ {
+				ok := reader.read(tt.maxFailures, 0, testBounds, nil, time.Hour, 0)
+				if want := i != tt.givesUp; ok != want {
+					t.Fatalf("read %d = %v, want %v", i+1, ok, want)
+				}
+				if !ok {
+					break
+				}
+				clock.Advance(time.Minute)
+			}
+			if got := len(reader.logging); got != tt.readings {
+				t.Errorf("queued %d readings, want %d", got, tt.readings)
+			}
+			// Each failure reopens the sensor, as the delay before the next
+			// attempt has passed by the next read
+			if tt.givesUp < 0 && sensor.halts != len(tt.fail) {
+				t.Errorf("halted the sensor %d times, want %d", sensor.halts, len(tt.fail))
+			}
+		})
+	}
+}
+
+func TestSensorOpener(t *testing.T) {
+	// Mocks are opened whatever the interface, and otherwise each interface
+	// fails with an error naming it when its bus doesn't exist
+
+	tests := []struct {
+		name      string
+		mock      bool
+		iface     string
+		model     string
+		wantGas   bool
+		openerErr string
+		openErr   string
+	}{
+		{"mock on I²C", true, "i2c", "bme280", false, "", ""},
+		{"mock on SPI", true, "spi", "bme280", false, "", ""},
+		{"mock BME680", true, "i2c", "bme680", true, "", ""},
+		{"missing SPI port", false, "spi", "bme280", false, "SPI port", ""},
+		{"missing I²C bus", false, "i2c", "bme280", false, "", "I²C bus"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			config.Mock, config.Interface, config.SensorModel = tt.mock, tt.iface, tt.model
+			config.BusName = "nonexistent"
+
+			open, closeBus, err := sensorOpener(config)
+			if tt.openerErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.openerErr) {
+					t.Fatalf("sensorOpener() error = %v, want one mentioning %q", err, tt.openerErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sensorOpener() error = %v", err)
+			}
+			defer closeBus()
+
+			sensor, err := open("", 0x76)
+			if tt.openErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.openErr) {
+					t.Fatalf("open() error = %v, want one mentioning %q", err, tt.openErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open() error = %v", err)
+			}
+			mock, ok := sensor.(*MockSensor)
+			if !ok {
+				t.Fatalf("opened %T, want *MockSensor", sensor)
+			}
+			if mock.gas != tt.wantGas {
+				t.Errorf("mock measures gas = %v, want %v", mock.gas, tt.wantGas)
+			}
+		})
+	}
+}
+
+func TestParseSensorOpts(t *testing.T) {
+	tests := []struct {
+		name                                    string
+		temperature, pressure, humidity, filter string
+		want                                    bmxx80.Opts
+		wantErr                                 bool
+	}{
+		{"defaults", "4", "4", "4", "off", bmxx80.Opts{Temperature: bmxx80.O4x, Pressure: bmxx80.O4x, Humidity: bmxx80.O4x, Filter: bmxx80.NoFilter}, false},
+		{"each value", "1", "16", "2", "8", bmxx80.Opts{Temperature: bmxx80.O1x, Pressure: bmxx80.O16x, Humidity: bmxx80.O2x, Filter: bmxx80.F8}, false},
+		{"pressure and humidity off", "8", "off", "OFF", "16", bmxx80.Opts{Temperature: bmxx80.O8x, Pressure: bmxx80.Off, Humidity: bmxx80.Off, Filter: bmxx80.F16}, false},
+		{"temperature off", "off", "4", "4", "off", bmxx80.Opts{}, true},
+		{"invalid oversampling", "4", "3", "4", "off", bmxx80.Opts{}, true},
+		{"invalid filter", "4", "4", "4", "32", bmxx80.Opts{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSensorOpts(tt.temperature, tt.pressure, tt.humidity, tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSensorOpts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseSensorOpts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStuckSensor(t *testing.T) {
+	a := physic.Env{Temperature: celsius(20), Pressure: 1013 * 100 * physic.Pascal, Humidity: percentRH(50)}
+	b := physic.Env{Temperature: celsius(21), Pressure: 1013 * 100 * physic.Pascal, Humidity: percentRH(50)}
+	tests := []struct {
+		name       string
+		readings   []physic.Env
+		stuckReads int
+		// Whether the sensor is reported as stuck after each read
+		stuck []bool
+	}{
+		{"constant", []physic.Env{a, a, a, a}, 3, []bool{false, false, true, true}},
+		{"changing", []physic.Env{a, b, a, b}, 2, []bool{false, false, false, false}},
+		{"recovers", []physic.Env{a, a, a, b, b}, 3, []bool{false, false, true, false, false}},
+		{"not checked", []physic.Env{a, a, a, a}, 0, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			state := newRunState(config)
+			reader := newTestSensorReader(state, newMockSensor(tt.readings, clock))
+
+			for i, want := range tt.stuck {
+				reader.read(0, tt.stuckReads, testBounds, nil, time.Hour, 0)
+				if got := state.health.stuckSensors() > 0; got != want {
+					t.Errorf("after read %d, stuck = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmupSamplesAreDiscarded(t *testing.T) {
+	for _, warmup := range []int{0, 1, 3} {
+		t.Run(strconv.Itoa(warmup), func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			var replayed []physic.Env
+			for i := 0; i < 6; i++ {
+				replayed = append(replayed, physic.Env{Temperature: celsius(float64(i)), Pressure: 1013 * 100 * physic.Pascal})
+			}
+			reader := newTestSensorReader(newRunState(config), newMockSensor(replayed, clock))
+			reader.warmup = warmup
+
+			for i := 0; i < len(replayed); i++ {
+				reader.read(0, 0, testBounds, nil, time.Hour, 0)
+			}
+			close(reader.logging)
+			var temps []float64
+			for reading := range reader.logging {
+				temps = append(temps, math.Round(reading.Temperature.Celsius()))
+			}
+			var want []float64
+			for i := warmup; i < len(replayed); i++ {
+				want = append(want, float64(i))
+			}
+			if !slices.Equal(temps, want) {
+				t.Errorf("queued %v, want %v", temps, want)
+			}
+		})
+	}
+}
+
+func TestGapDetection(t *testing.T) {
+	tests := []struct {
+		name string
+		// Time between each read and the one before
+		delays []time.Duration
+		missed float64
+	}{
+		{"on time", []time.Duration{time.Minute, time.Minute, time.Minute}, 0},
+		{"within the margin", []time.Duration{time.Minute, 90 * time.Second, time.Minute}, 0},
+		{"one tick missed", []time.Duration{time.Minute, 2 * time.Minute, time.Minute}, 1},
+		{"several gaps", []time.Duration{2 * time.Minute, time.Minute, 5 * time.Minute}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			reader := newTestSensorReader(newRunState(config), newMockSensor(nil, clock))
+			missedBefore := testutil.ToFloat64(missedReadings)
+
+			reader.read(0, 0, testBounds, nil, maxReadGap(time.Minute, 0), 0)
+			for _, delay := range tt.delays {
+				clock.Advance(delay)
+				reader.read(0, 0, testBounds, nil, maxReadGap(time.Minute, 0), 0)
+			}
+			if missed := testutil.ToFloat64(missedReadings) - missedBefore; missed != tt.missed {
+				t.Errorf("counted %v missed readings, want %v", missed, tt.missed)
+			}
+		})
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	// Readings are numbered from 1 as they're read. Implausible readings are
+	// numbered too, so the gap shows they were dropped, but failed reads
+	// aren't.
+
+	config := testConfig()
+	clock := NewFakeClock(testTime)
+	config.Clock = clock
+	config.Output.Fields.Seq = true
+	pressure := 1013 * 100 * physic.Pascal
+	replayed := []physic.Env{
+		{Temperature: celsius(20), Pressure: pressure},
+		{Temperature: celsius(500), Pressure: pressure},
+		{Temperature: celsius(21), Pressure: pressure},
+		{Temperature: celsius(22), Pressure: pressure},
+	}
+	sensor := &flakySensor{MockSensor: newMockSensor(replayed, clock), fail: map[int]bool{3: true}}
+	reader := newTestSensorReader(newRunState(config), sensor)
+	bounds := Bounds{Max: physic.Env{Temperature: celsius(85), Pressure: 2 * pressure}}
+	for i := 0; i < 5; i++ {
+		reader.read(0, 0, bounds, nil, time.Hour, 0)
+		clock.Advance(time.Minute)
+	}
+	close(reader.logging)
+
+	var seqs []int64
+	for reading := range reader.logging {
+		for _, field := range newInfluxPoint(reading, reading.Time, "environment", config.Output).FieldList() {
+			if field.Key == "seq" {
+				seqs = append(seqs, field.Value.(int64))
+			}
+		}
+	}
+	if want := []int64{1, 3, 4}; !slices.Equal(seqs, want) {
+		t.Errorf("seq fields = %v, want %v", seqs, want)
+	}
+}
+
+// hangingSensor is a mock sensor whose reads don't return until `release`
+// is closed
+type hangingSensor struct {
+	*MockSensor
+	release chan struct{}
+	reads   atomic.Int64
+}
+
+func (s *hangingSensor) Sense(env *physic.Env) error {
+	s.reads.Add(1)
+	<-s.release
+	return s.MockSensor.Sense(env)
+}
+
+func TestSenseWithTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// Whether the sensor responds before the timeout
+		responds bool
+		wantErr  bool
+	}{
+		{"no timeout", 0, true, false},
+		{"responds in time", time.Second, true, false},
+		{"hangs", time.Second, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			sensor := &hangingSensor{MockSensor: newMockSensor(nil, clock), release: make(chan struct{})}
+			if tt.responds {
+				close(sensor.release)
+			}
+			errs := make(chan error, 1)
+			var env physic.Env
+			go func() { errs <- senseWithTimeout(clock, sensor, &env, tt.timeout) }()
+			if !tt.responds {
+				clock.BlockUntil(1)
+				clock.Advance(tt.timeout)
+			}
+
+			err, _ := receive(t, errs)
+			var timeoutErr *readTimeoutError
+			if errors.As(err, &timeoutErr) != tt.wantErr {
+				t.Fatalf("senseWithTimeout() = %v, want a timeout %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && env.Temperature != celsius(20) {
+				t.Errorf("read %v, want 20°C", env.Temperature)
+			}
+			if tt.wantErr {
+				// The driver's goroutine exits once the read returns, without
+				// changing the values
+				close(sensor.release)
+				receive(t, timeoutErr.done)
+				if env != (physic.Env{}) {
+					t.Errorf("abandoned read changed the values to %v", env)
+				}
+			}
+		})
+	}
+}
+
+func TestSensorReaderAbandonsHungReads(t *testing.T) {
+	config := testConfig()
+	clock := NewFakeClock(testTime)
+	config.Clock = clock
+	sensor := &hangingSensor{MockSensor: newMockSensor(nil, clock), release: make(chan struct{})}
+	reader := newTestSensorReader(newRunState(config), sensor)
+	read := func() bool { return reader.read(3, 0, testBounds, nil, time.Hour, 5*time.Second) }
+
+	// The read times out, and polling carries on
+	done := make(chan bool, 1)
+	go func() { done <- read() }()
+	clock.BlockUntil(1)
+	for sensor.reads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(5 * time.Second)
+	if ok, _ := receive(t, done); !ok {
+		t.Fatal("gave up after one timed out read")
+	}
+
+	// Until the hung read returns, the sensor isn't read again
+	if !read() {
+		t.Fatal("gave up after two timed out reads")
+	}
+	if got := sensor.reads.Load(); got != 1 {
+		t.Errorf("read the sensor %d times while it was hung, want 1", got)
+	}
+
+	close(sensor.release)
+	receive(t, reader.hung)
+	if !read() {
+		t.Fatal("read after the sensor responded failed")
+	}
+	if got := len(reader.logging); got != 1 {
+		t.Errorf("queued %d readings, want only the one after the sensor responded", got)
+	}
+	if got := sensor.reads.Load(); got != 2 {
+		t.Errorf("read the sensor %d times, want 2", got)
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	// Reads carry on while nothing takes readings from a sensor's full
+	// queue, dropping readings according to the policy
+
+	tests := []struct {
+		policy  string
+		buffer  int
+		reads   int
+		want    []uint64
+		dropped float64
+	}{
+		{"oldest", 3, 5, []uint64{3, 4, 5}, 2},
+		{"newest", 3, 5, []uint64{1, 2, 3}, 2},
+		{"oldest", 3, 3, []uint64{1, 2, 3}, 0},
+		{"newest", 1, 4, []uint64{1}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d into %d", tt.policy, tt.reads, tt.buffer), func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			reader := newTestSensorReader(newRunState(config), newMockSensor(nil, clock))
+			reader.logging = make(chan Reading, tt.buffer)
+			reader.dropPolicy = tt.policy
+
+			droppedBefore := testutil.ToFloat64(droppedReadings)
+			for i := 0; i < tt.reads; i++ {
+				if !reader.read(0, 0, testBounds, nil, time.Hour, 0) {
+					t.Fatalf("read %d failed", i+1)
+				}
+				clock.Advance(time.Second)
+			}
+			close(reader.logging)
+			var seqs []uint64
+			for reading := range reader.logging {
+				seqs = append(seqs, reading.Seq)
+			}
+			if !slices.Equal(seqs, tt.want) {
+				t.Errorf("queued readings %v, want %v", seqs, tt.want)
+			}
+			if dropped := testutil.ToFloat64(droppedReadings) - droppedBefore; dropped != tt.dropped {
+				t.Errorf("dropped %v readings, want %v", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestQueueRaw(t *testing.T) {
+	// Raw readings are queued alongside those to be averaged, and dropped
+	// rather than waited for when the raw sink is behind
+	tests := []struct {
+		name string
+		// Size of the raw queue, or -1 for no raw sink
+		buffer  int
+		raw     int
+		dropped float64
+	}{
+		{"no raw sink", -1, 0, 0},
+		{"keeping up", 5, 3, 0},
+		{"behind", 1, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			reader := newTestSensorReader(newRunState(config), newMockSensor(nil, clock))
+			var raw chan Reading
+			if tt.buffer >= 0 {
+				raw = make(chan Reading, tt.buffer)
+				reader.raw = raw
+			}
+
+			droppedBefore := testutil.ToFloat64(droppedRawReadings)
+			for i := 0; i < 3; i++ {
+				if !reader.read(0, 0, testBounds, nil, time.Hour, 0) {
+					t.Fatalf("read %d failed", i+1)
+				}
+				clock.Advance(time.Second)
+			}
+			if got := len(reader.logging); got != 3 {
+				t.Errorf("queued %d readings to average, want 3", got)
+			}
+			if got := len(raw); got != tt.raw {
+				t.Errorf("queued %d raw readings, want %d", got, tt.raw)
+			}
+			if dropped := testutil.ToFloat64(droppedRawReadings) - droppedBefore; dropped != tt.dropped {
+				t.Errorf("dropped %v raw readings, want %v", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestSensorReaderReconnectBackoff(t *testing.T) {
+	// A disconnected sensor fails every read until it's reopened, which fails
+	// `failedReopens` times before the sensor comes back. Reads are made
+	// every second.
+	tests := []struct {
+		name          string
+		failedReopens int
+		// Seconds after the first failure at which the sensor is reopened,
+		// the delay doubling after each failed attempt
+		wantAttempts []int
+	}{
+		{"reopens straight away", 0, []int{0}},
+		{"fails then recovers", 4, []int{0, 1, 3, 7, 15}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			fail := map[int]bool{}
+			for i := 0; i < 100; i++ {
+				fail[i] = true
+			}
+			disconnected := &flakySensor{MockSensor: newMockSensor(nil, clock), fail: fail}
+			reader := newTestSensorReader(newRunState(config), disconnected)
+			var attempts []int
+			reader.reopen = func() (Sensor, error) {
+				attempts = append(attempts, int(clock.Now().Sub(testTime)/time.Second))
+				if len(attempts) <= tt.failedReopens {
+					return nil, errors.New("no device at this address")
+				}
+				return newMockSensor(nil, clock), nil
+			}
+
+			recovered := tt.wantAttempts[len(tt.wantAttempts)-1]
+			for i := 0; i <= recovered+2; i++ {
+				if !reader.read(0, 0, testBounds, nil, time.Hour, 0) {
+					t.Fatalf("read %d gave up", i+1)
+				}
+				clock.Advance(time.Second)
+			}
+			if !slices.Equal(attempts, tt.wantAttempts) {
+				t.Errorf("reopened at %v seconds, want %v", attempts, tt.wantAttempts)
+			}
+			// Readings resume with the read after the sensor is reopened
+			if got := len(reader.logging); got != 2 {
+				t.Errorf("queued %d readings after recovering, want 2", got)
+			}
+			if reader.failures != 0 || reader.reconnectDelay != 0 {
+				t.Errorf("failures = %d, reconnect delay = %v after recovering, want both reset", reader.failures, reader.reconnectDelay)
+			}
+		})
+	}
+}
+
+func TestSensorReaderReconnectDelayIsCapped(t *testing.T) {
+	config := testConfig()
+	clock := NewFakeClock(testTime)
+	config.Clock = clock
+	reader := newTestSensorReader(newRunState(config), newMockSensor(nil, clock))
+	reopens := 0
+	reader.reopen = func() (Sensor, error) {
+		reopens++
+		return nil, errors.New("no device at this address")
+	}
+
+	var delays []time.Duration
+	for i := 0; i < 12; i++ {
+		reader.reconnect()
+		delays = append(delays, reader.reconnectDelay)
+
+		// Nothing is reopened until the delay has passed
+		clock.Advance(reader.reconnectDelay - time.Millisecond)
+		reader.reconnect()
+		if reopens != i+1 {
+			t.Fatalf("reopened %d times before the delay of %v passed, want %d", reopens, reader.reconnectDelay, i+1)
+		}
+		clock.Advance(time.Millisecond)
+	}
+
+	want := []time.Duration{1, 2, 4, 8, 16, 32, 64, 128, 256, 300, 300, 300}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	if !slices.Equal(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SeriesLimitSink passes readings on to another sink until `max` distinct
+// combinations of tags have been written, after which readings that would
+// start another series are dropped. This stops a bug that puts a changing
+// value in a tag, such as a timestamp in a sensor label, from creating a new
+// series in the database with every point.
+type SeriesLimitSink struct {
+	sink   Sink
+	max    int
+	output OutputConfig
+	// Keys of the series written so far, from `seriesKey`
+	series map[string]bool
+}
+
+func newSeriesLimitSink(sink Sink, max int, output OutputConfig) *SeriesLimitSink {
+	return &SeriesLimitSink{sink: sink, max: max, output: output, series: map[string]bool{}}
+}
+
+func seriesKey(tags map[string]string) string {
+	// Identify the series written with `tags`, regardless of their order
+
+	pairs := make([]string, 0, len(tags))
+	for name, value := range tags {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (s *SeriesLimitSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	tags := readingTags(reading, s.output)
+	key := seriesKey(tags)
+	if !s.series[key] && len(s.series) >= s.max {
+		// Refusing the point rather than failing the write keeps it from
+		// being retried and queued, and the other series are still written
+		slog.Error("Too many series, dropping point with new tags", "tags", tags, "max_series", s.max)
+		seriesRefused.Inc()
+		return nil
+	}
+
+	if err := s.sink.Write(ctx, reading, t); err != nil {
+		return err
+	}
+	s.series[key] = true
+	return nil
+}
+
+func (s *SeriesLimitSink) Close() error {
+	return s.sink.Close()
+}
@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesKey(t *testing.T) {
+	a := seriesKey(map[string]string{"sensor": "indoor", "host": "pi", "location": "loft"})
+	b := seriesKey(map[string]string{"location": "loft", "sensor": "indoor", "host": "pi"})
+	if a != b {
+		t.Errorf("keys of the same tags differ: %q, %q", a, b)
+	}
+	if c := seriesKey(map[string]string{"sensor": "indoor", "host": "pi2", "location": "loft"}); c == a {
+		t.Errorf("keys of different tags are both %q", a)
+	}
+}
+
+func TestSeriesLimitSink(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int
+		labels []string
+		// Labels of the readings written, in order
+		want    []string
+		refused float64
+	}{
+		{"under the limit", 3, []string{"a", "b", "a", "c"}, []string{"a", "b", "a", "c"}, 0},
+		{"new series over the limit", 2, []string{"a", "b", "c", "d"}, []string{"a", "b"}, 2},
+		{"existing series over the limit", 2, []string{"a", "b", "c", "a", "b"}, []string{"a", "b", "a", "b"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recording := &recordingSink{}
+			sink := newSeriesLimitSink(recording, tt.max, testConfig().Output)
+			refusedBefore := testutil.ToFloat64(seriesRefused)
+			for _, label := range tt.labels {
+				reading := testReading(20, 0)
+				reading.Label = label
+				// Refused points aren't errors, so they aren't retried
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatalf("Write(%s) = %v", label, err)
+				}
+			}
+
+			var written []string
+			for _, reading := range recording.written {
+				written = append(written, reading.Label)
+			}
+			if !slices.Equal(written, tt.want) {
+				t.Errorf("wrote %v, want %v", written, tt.want)
+			}
+			if refused := testutil.ToFloat64(seriesRefused) - refusedBefore; refused != tt.refused {
+				t.Errorf("refused %v points, want %v", refused, tt.refused)
+			}
+		})
+	}
+}
+
+func TestSeriesLimitSinkCountsWrittenSeries(t *testing.T) {
+	// A series whose first write failed hasn't been written, so doesn't use
+	// up the limit
+	recording := &recordingSink{err: errWriteFailed}
+	sink := newSeriesLimitSink(recording, 1, testConfig().Output)
+	failed := testReading(20, 0)
+	failed.Label = "a"
+	if err := sink.Write(context.Background(), failed, failed.Time); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Write() = %v, want %v", err, errWriteFailed)
+	}
+
+	recording.setErr(nil)
+	reading := testReading(20, 0)
+	reading.Label = "b"
+	if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+		t.Fatal(err)
+	}
+	if got := recording.count(); got != 1 {
+		t.Errorf("wrote %d points, want 1", got)
+	}
+}
@@ -0,0 +1,240 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// A Sink is a destination for averaged readings, such as a database
+type Sink interface {
+	// Write records `reading`, measured at time `t`
+	Write(ctx context.Context, reading Reading, t time.Time) error
+	// Close flushes any pending writes and releases the sink's resources
+	Close() error
+}
+
+// Names accepted by the `-sink` flag
+var SinkNames = []string{"influx", "stdout", "csv", "mqtt", "postgres", "lineprotocol"}
+
+func newSink(config Config) (Sink, error) {
+	// Create the sinks selected by `config.Sinks`, combined in a `MultiSink`
+	// if there are several, or a sink that writes nothing for a dry run. With
+	// `config.Dedup.Enabled`, unchanged readings are skipped before reaching
+	// them, and with `config.MaxSeries`, readings beyond that many series are
+	// dropped. With `config.HeartbeatInterval`, each sensor's last reading is
+	// written again whenever none has been written for that long, including
+	// while unchanged readings are being skipped.
+
+	sink, err := newSelectedSink(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxSeries > 0 {
+		sink = newSeriesLimitSink(sink, config.MaxSeries, config.Output)
+	}
+	if config.HeartbeatInterval > 0 {
+		sink = newHeartbeatSink(sink, config.HeartbeatInterval, config.Clock)
+	}
+	if config.Dedup.Enabled {
+		sink = newDedupSink(sink, config.Dedup, config.Output)
+	}
+	return sink, nil
+}
+
+func newRawSink(config Config) (Sink, error) {
+	// Create the InfluxDB sink that raw readings are written to, using
+	// `config.RawMeasurement` instead of the usual measurement, or a sink
+	// that writes nothing for a dry run
+
+	if config.DryRun {
+		return newNoopSink(), nil
+	}
+	config.Influx.Measurement = config.RawMeasurement
+	return newNamedSink("influx", config)
+}
+
+func newSelectedSink(config Config) (Sink, error) {
+	if config.DryRun {
+		return newNoopSink(), nil
+	}
+	if len(config.Sinks) == 1 {
+		return newNamedSink(config.Sinks[0], config)
+	}
+
+	// The multisink gives up on each sink after its write timeout itself
+	sinks := make([]Sink, 0, len(config.Sinks))
+	for _, name := range config.Sinks {
+		sink, err := openNamedSink(name, config)
+		if err != nil {
+			for _, sink := range sinks {
+				sink.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return newMultiSink(config.Sinks, sinks, config.WriteTimeouts, config.Clock), nil
+}
+
+func newNamedSink(name string, config Config) (Sink, error) {
+	// Create the sink called `name`, configured from `config`, giving up on
+	// writes to it after its timeout in `config.WriteTimeouts`
+
+	sink, err := openNamedSink(name, config)
+	if err != nil {
+		return nil, err
+	}
+	if timeout := config.WriteTimeouts.For(name); timeout > 0 {
+		return newTimeoutSink(sink, name, timeout, config.Clock), nil
+	}
+	return sink, nil
+}
+
+func openNamedSink(name string, config Config) (Sink, error) {
+	switch name {
+	case "influx":
+		switch config.Influx.Version {
+		case 1:
+			return newInfluxV1Sink(config.Influx, config.Output)
+		case 2:
+			return newInfluxSink(config.Influx, config.Output)
+		default:
+			return nil, fmt.Errorf("unsupported InfluxDB version %d: must be 1 or 2", config.Influx.Version)
+		}
+	case "stdout":
+		switch config.Format {
+		case "json":
+			return newStdoutSink(os.Stdout, config.Output), nil
+		case "table":
+			return newTableSink(os.Stdout, config.Output), nil
+		default:
+			return nil, fmt.Errorf("unknown format %q: must be json or table", config.Format)
+		}
+	case "csv":
+		return newCSVSink(config.CSV, config.Output, config.Clock)
+	case "mqtt":
+		return newMQTTSink(config.MQTT, config.Output, config.Clock)
+	case "postgres":
+		return newPostgresSink(config.Postgres, config.Output)
+	case "lineprotocol":
+		return newLineProtocolSink(config.LineProtocol, config.Influx, config.Output)
+	default:
+		return nil, fmt.Errorf("unknown sink %q", name)
+	}
+}
+
+type WriteConfig struct {
+	// Number of consecutive failed writes before giving up, or 0 to keep trying
+	MaxWriteFailures int
+	// Number of times a failed write is retried, and the delay before the
+	// first retry. The delay doubles with each retry.
+	WriteRetryMax  int
+	WriteRetryBase time.Duration
+	// Maximum number of failed points held in memory for replay
+	WriteQueueSize int
+}
+
+type queuedPoint struct {
+	reading Reading
+	t       time.Time
+}
+
+func (s *runState) logToSink(sink Sink, config WriteConfig, datapoints <-chan Reading) error {
+	// Write each value from `datapoints` to `sink` until the channel is closed.
+	// Each write is retried with exponential backoff; values that still fail
+	// are queued and replayed after the next successful write. If
+	// `config.MaxWriteFailures` values fail in a row, the last error is
+	// returned. A `MaxWriteFailures` of 0 never gives up.
+
+	failures := 0
+	queue := []queuedPoint{}
+	for data := range datapoints {
+		slog.Info("Writing point", "reading", data)
+
+		if err := s.writeWithRetry(sink, data, data.Time, config.WriteRetryMax, config.WriteRetryBase); err != nil {
+			failures++
+			slog.Error("Failed to write point", "reading", data, "time", data.Time,
+				"failures", failures, "error", err)
+
+			if config.WriteQueueSize > 0 {
+				if len(queue) == config.WriteQueueSize {
+					slog.Warn("Write queue full, dropping oldest point", "time", queue[0].t)
+					queue = queue[1:]
+				}
+				queue = append(queue, queuedPoint{data, data.Time})
+			}
+
+			if config.MaxWriteFailures > 0 && failures >= config.MaxWriteFailures {
+				return fmt.Errorf("giving up after %d consecutive write failures: %w", failures, err)
+			}
+			continue
+		}
+		failures = 0
+
+		// The sink is reachable again, so replay anything that failed before
+		for len(queue) > 0 {
+			if err := s.writeToSink(sink, queue[0].reading, queue[0].t); err != nil {
+				slog.Warn("Failed to replay queued points", "queued", len(queue), "error", err)
+				break
+			}
+			queue = queue[1:]
+		}
+	}
+
+	// Shutting down, so give the queued points one last chance
+	for len(queue) > 0 {
+		if err := s.writeToSink(sink, queue[0].reading, queue[0].t); err != nil {
+			break
+		}
+		queue = queue[1:]
+	}
+	if len(queue) > 0 {
+		slog.Warn("Discarding queued points that could not be written", "queued", len(queue))
+	}
+	return nil
+}
+
+func closeSink(sink Sink, name string) {
+	// Close `sink`, which for batching sinks sends the points they still hold,
+	// logging if that fails
+	if err := sink.Close(); err != nil {
+		slog.Error("Could not close the sink", "sink", name, "error", err)
+	}
+}
+
+func (s *runState) writeToSink(sink Sink, reading Reading, t time.Time) error {
+	// Write `reading` to `sink` once, counting the outcome in the metrics and
+	// recording successes for the health endpoints
+
+	ctx, end := context.Background(), func(error) {}
+	if s.telemetry != nil {
+		ctx, end = s.telemetry.startWrite(ctx)
+	}
+	err := sink.Write(ctx, reading, t)
+	end(err)
+	if err != nil {
+		sinkWriteFailures.Inc()
+		return err
+	}
+	sinkWrites.Inc()
+	s.health.recordWrite(s.clock.Now())
+	return nil
+}
+
+func (s *runState) writeWithRetry(sink Sink, reading Reading, t time.Time, retries int, delay time.Duration) (err error) {
+	// Write `reading` to `sink`, retrying up to `retries` times on failure. The
+	// delay between attempts starts at `delay` and doubles after each failed
+	// retry.
+
+	for attempt := 0; ; attempt++ {
+		if err = s.writeToSink(sink, reading, t); err == nil || attempt >= retries {
+			return
+		}
+		slog.Warn("Write failed, retrying", "delay", delay, "error", err)
+		s.clock.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,174 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps the readings written to it, and fails writes with
+// `err` while it's set
+type recordingSink struct {
+	mu      sync.Mutex
+	written []Reading
+	times   []time.Time
+	err     error
+	closed  bool
+}
+
+func (s *recordingSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.written = append(s.written, reading)
+	s.times = append(s.times, t)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.written)
+}
+
+// blockingSink is a sink whose writes don't return, even when cancelled,
+// until `release` is closed
+type blockingSink struct {
+	recordingSink
+	release chan struct{}
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{release: make(chan struct{})}
+}
+
+func (s *blockingSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	<-s.release
+	return s.recordingSink.Write(ctx, reading, t)
+}
+
+func TestLogToSinkLosesNoPoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		readings  int
+		fail      map[int]bool
+		retries   int
+		queueSize int
+	}{
+		{"retried until written", 3, map[int]bool{0: true, 1: true}, 3, 0},
+		{"queued and replayed after the next write", 3, map[int]bool{0: true, 1: true, 2: true}, 1, 10},
+		{"queued and replayed on shutdown", 3, map[int]bool{2: true, 3: true}, 1, 10},
+		{"intermittent", 6, map[int]bool{1: true, 2: true, 4: true, 7: true, 8: true, 9: true}, 1, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			writeAPI := &fakeWriteAPI{fail: tt.fail}
+			// Retry without a delay, so the fake clock never has to advance
+			write := WriteConfig{WriteRetryMax: tt.retries, WriteQueueSize: tt.queueSize}
+
+			if err := newRunState(config).logToSink(newFakeInfluxSink(writeAPI), write, sendReadings(tt.readings)); err != nil {
+				t.Fatalf("logToSink() = %v, want nil", err)
+			}
+			var got []time.Time
+			for _, point := range writeAPI.written {
+				got = append(got, point.Time())
+			}
+			slices.SortFunc(got, func(a, b time.Time) int { return a.Compare(b) })
+			var want []time.Time
+			for i := 0; i < tt.readings; i++ {
+				want = append(want, testTime.Add(time.Duration(i)*time.Second))
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("wrote points at %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// attemptSink fails every write, recording when each was attempted
+type attemptSink struct {
+	clock    Clock
+	attempts chan time.Time
+}
+
+func (s *attemptSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	s.attempts <- s.clock.Now()
+	return errWriteFailed
+}
+
+func (s *attemptSink) Close() error {
+	return nil
+}
+
+func TestWriteWithRetryBacksOff(t *testing.T) {
+	config := testConfig()
+	clock := NewFakeClock(testTime)
+	config.Clock = clock
+	sink := &attemptSink{clock, make(chan time.Time, 10)}
+	done := make(chan error)
+	go func() {
+		done <- newRunState(config).writeWithRetry(sink, testReading(20, 0), testTime, 3, time.Second)
+	}()
+
+	for i, want := range []time.Duration{0, time.Second, 3 * time.Second, 7 * time.Second} {
+		at, _ := receive(t, sink.attempts)
+		if !at.Equal(testTime.Add(want)) {
+			t.Errorf("attempt %d at %v, want %v", i+1, at.Sub(testTime), want)
+		}
+		if i < 3 {
+			clock.BlockUntil(1)
+			clock.Advance(time.Second << i)
+		}
+	}
+	if err, _ := receive(t, done); !errors.Is(err, errWriteFailed) {
+		t.Errorf("writeWithRetry() = %v, want %v", err, errWriteFailed)
+	}
+}
+
+func TestLogToSinkRetriesQueueOnShutdown(t *testing.T) {
+	// Points still queued when the input closes are given one last chance
+	tests := []struct {
+		name      string
+		queueSize int
+		fail      map[int]bool
+		written   int
+	}{
+		{"written on the last chance", 10, map[int]bool{1: true}, 2},
+		{"last chance fails too", 10, map[int]bool{1: true, 2: true}, 1},
+		{"nothing queued", 0, map[int]bool{1: true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Clock = NewFakeClock(testTime)
+			writeAPI := &fakeWriteAPI{fail: tt.fail}
+			write := WriteConfig{WriteQueueSize: tt.queueSize}
+			if err := newRunState(config).logToSink(newFakeInfluxSink(writeAPI), write, sendReadings(2)); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(writeAPI.written); got != tt.written {
+				t.Errorf("wrote %d points, want %d", got, tt.written)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Times of the last successful sensor read and sink write, and the labels of
+// sensors whose readings are stuck, reported by the health endpoints
+type healthState struct {
+	mu        sync.Mutex
+	lastRead  time.Time
+	lastWrite time.Time
+	stuck     map[string]bool
+}
+
+func (h *healthState) recordRead(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastRead = t
+}
+
+func (h *healthState) recordWrite(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastWrite = t
+}
+
+func (h *healthState) setStuck(label string, stuck bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stuck == nil {
+		h.stuck = map[string]bool{}
+	}
+	if stuck {
+		h.stuck[label] = true
+	} else {
+		delete(h.stuck, label)
+	}
+}
+
+func (h *healthState) stuckSensors() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.stuck)
+}
+
+func (h *healthState) times() (lastRead, lastWrite time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastRead, h.lastWrite
+}
+
+// The most recent average from each sensor, served at /latest
+type latestReadings struct {
+	mu       sync.Mutex
+	readings map[string]timedReading
+}
+
+type timedReading struct {
+	reading Reading
+	t       time.Time
+}
+
+func (s *runState) recordAverage(reading Reading, t time.Time) {
+	// Make a new average, produced at time `t`, available to the HTTP
+	// endpoints: served at /latest, streamed to WebSocket clients and kept
+	// for Grafana. It's also checked against any alert thresholds.
+
+	s.latest.record(reading, t)
+	s.hub.publish(reading, t)
+	s.history.record(reading, t)
+	if s.alerts != nil {
+		s.alerts.check(reading, t)
+	}
+}
+
+func (l *latestReadings) record(reading Reading, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readings == nil {
+		l.readings = map[string]timedReading{}
+	}
+	l.readings[reading.Label] = timedReading{reading, t}
+}
+
+func (l *latestReadings) records(output OutputConfig) []jsonRecord {
+	// The latest reading from each sensor, ordered by label
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	labels := make([]string, 0, len(l.readings))
+	for label := range l.readings {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	records := make([]jsonRecord, 0, len(labels))
+	for _, label := range labels {
+		r := l.readings[label]
+		records = append(records, newJSONRecord(r.reading, r.t, output))
+	}
+	return records
+}
+
+// How the readings served at /latest were averaged
+type averagingParams struct {
+	Mode           string  `json:"mode"`
+	Window         int     `json:"window,omitempty"`
+	WindowDuration string  `json:"window_duration,omitempty"`
+	EMAAlpha       float64 `json:"ema_alpha,omitempty"`
+}
+
+func newAveragingParams(config Config) averagingParams {
+	params := averagingParams{Mode: config.AverageMode}
+	switch {
+	case config.AverageMode == "ema":
+		params.EMAAlpha = config.EMAAlpha
+	case config.WindowDuration > 0:
+		params.WindowDuration = config.WindowDuration.String()
+	default:
+		params.Window = config.WindowSize
+	}
+	return params
+}
+
+func latestHandler(latest *latestReadings, config Config) http.HandlerFunc {
+	// Respond with the latest average from each sensor, along with how they
+	// were averaged
+
+	averaging := newAveragingParams(config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, struct {
+			Averaging averagingParams `json:"averaging"`
+			Readings  []jsonRecord    `json:"readings"`
+		}{averaging, latest.records(config.Output)})
+	}
+}
+
+// Body of the health endpoints' responses
+type healthStatus struct {
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason,omitempty"`
+	LastRead  *time.Time `json:"last_read,omitempty"`
+	LastWrite *time.Time `json:"last_write,omitempty"`
+}
+
+func newHealthStatus(lastRead, lastWrite time.Time) healthStatus {
+	status := healthStatus{Status: "ok"}
+	if !lastRead.IsZero() {
+		status.LastRead = &lastRead
+	}
+	if !lastWrite.IsZero() {
+		status.LastWrite = &lastWrite
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Debug("Could not write HTTP response", "error", err)
+	}
+}
+
+func healthzHandler(health *healthState, clock Clock, maxAge time.Duration) http.HandlerFunc {
+	// Respond with 200 if a sensor was read successfully within `maxAge` and
+	// no sensor is stuck, or 503 otherwise
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastRead, lastWrite := health.times()
+		status := newHealthStatus(lastRead, lastWrite)
+		switch {
+		case lastRead.IsZero():
+			status.Status, status.Reason = "unhealthy", "no sensor has been read yet"
+		case clock.Now().Sub(lastRead) > maxAge:
+			status.Status, status.Reason = "unhealthy", "no sensor has been read in the last "+maxAge.String()
+		case health.stuckSensors() > 0:
+			status.Status, status.Reason = "unhealthy", fmt.Sprintf("readings from %d sensor(s) are stuck", health.stuckSensors())
+		default:
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		writeJSON(w, http.StatusServiceUnavailable, status)
+	}
+}
+
+func readyzHandler(health *healthState) http.HandlerFunc {
+	// Respond with 200 once a sensor has been read and a reading written to the
+	// sink, or 503 until then
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastRead, lastWrite := health.times()
+		status := newHealthStatus(lastRead, lastWrite)
+		switch {
+		case lastRead.IsZero():
+			status.Status, status.Reason = "not ready", "no sensor has been read yet"
+		case lastWrite.IsZero():
+			status.Status, status.Reason = "not ready", "no reading has been written yet"
+		default:
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		writeJSON(w, http.StatusServiceUnavailable, status)
+	}
+}
+
+func (s *runState) serveStatus(ctx context.Context, config Config) {
+	// Serve the health endpoints, the latest readings and the recent ones for
+	// Grafana, and stream new ones, on `config.HTTPAddr` until `ctx` is
+	// cancelled
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthzHandler(&s.health, s.clock, config.HealthMaxAge))
+	mux.Handle("/readyz", readyzHandler(&s.health))
+	mux.Handle("/latest", latestHandler(&s.latest, config))
+	mux.Handle("/ws", wsHandler(ctx, &s.hub, config.Output))
+	mux.Handle("/history", historyHandler(&s.history, config.Output))
+	mux.HandleFunc("/grafana/", grafanaTestHandler)
+	mux.Handle("/grafana/search", grafanaSearchHandler(&s.history, config.Output))
+	mux.Handle("/grafana/query", grafanaQueryHandler(&s.history, s.clock, config.Output))
+
+	slog.Info("Serving status", "addr", config.HTTPAddr, "paths", []string{"/healthz", "/readyz", "/latest", "/ws", "/history", "/grafana/"})
+	serveUntilDone(ctx, &http.Server{Addr: config.HTTPAddr, Handler: mux}, "Status")
+}
+
+func serveUntilDone(ctx context.Context, server *http.Server, name string) {
+	// Run `server` until `ctx` is cancelled, then shut it down gracefully.
+	// `name` describes the server in log messages.
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error(name+" server failed", "error", err)
+	}
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatestHandler(t *testing.T) {
+	type response struct {
+		Averaging averagingParams `json:"averaging"`
+		Readings  []jsonRecord    `json:"readings"`
+	}
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		// Averages recorded, in order, by their label. Their temperatures
+		// go up by 1°C from 20°C.
+		averages  []Reading
+		averaging averagingParams
+		// Temperature of each reading served, ordered by label
+		temps []float64
+	}{
+		{
+			"nothing yet",
+			func(c *Config) {},
+			nil,
+			averagingParams{Mode: "window", Window: 3},
+			nil,
+		},
+		{
+			"latest of each sensor",
+			func(c *Config) {},
+			[]Reading{{Label: "outside"}, {Label: "inside"}, {Label: "outside"}},
+			averagingParams{Mode: "window", Window: 3},
+			[]float64{21, 22},
+		},
+		{
+			"timed windows",
+			func(c *Config) { c.WindowDuration = 5 * time.Minute },
+			[]Reading{{}},
+			averagingParams{Mode: "window", WindowDuration: "5m0s"},
+			[]float64{20},
+		},
+		{
+			"moving average",
+			func(c *Config) { c.AverageMode, c.EMAAlpha = "ema", 0.3 },
+			[]Reading{{}, {}},
+			averagingParams{Mode: "ema", EMAAlpha: 0.3},
+			[]float64{21},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			tt.configure(&config)
+			var latest latestReadings
+			for i, average := range tt.averages {
+				reading := testReading(20+float64(i), time.Duration(i)*time.Minute)
+				reading.Label = average.Label
+				latest.record(reading, reading.Time)
+			}
+
+			recorder := httptest.NewRecorder()
+			latestHandler(&latest, config)(recorder, httptest.NewRequest(http.MethodGet, "/latest", nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+			}
+			if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var body response
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Averaging != tt.averaging {
+				t.Errorf("averaging = %+v, want %+v", body.Averaging, tt.averaging)
+			}
+			if len(body.Readings) != len(tt.temps) {
+				t.Fatalf("got %d readings, want %d", len(body.Readings), len(tt.temps))
+			}
+			for i, record := range body.Readings {
+				if record.TemperatureC == nil || *record.TemperatureC != tt.temps[i] {
+					t.Errorf("reading %d temperature = %v, want %v", i+1, record.TemperatureC, tt.temps[i])
+				}
+				if record.Time == "" {
+					t.Errorf("reading %d has no time", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastRead time.Duration
+		read     bool
+		stuck    bool
+		want     int
+	}{
+		{"not read yet", 0, false, false, http.StatusServiceUnavailable},
+		{"read recently", -time.Minute, true, false, http.StatusOK},
+		{"read too long ago", -time.Hour, true, false, http.StatusServiceUnavailable},
+		{"stuck", -time.Minute, true, true, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			var health healthState
+			if tt.read {
+				health.recordRead(testTime.Add(tt.lastRead))
+			}
+			health.setStuck("", tt.stuck)
+
+			recorder := httptest.NewRecorder()
+			healthzHandler(&health, clock, 10*time.Minute)(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
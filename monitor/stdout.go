@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// StdoutSink writes each reading as a line of JSON, for debugging without a
+// database or piping into tools like `jq`
+type StdoutSink struct {
+	encoder *json.Encoder
+	output  OutputConfig
+}
+
+// The JSON object written for each reading by the stdout and MQTT sinks. The
+// field names are part of the output format, so must not change.
+type jsonRecord struct {
+	// Temperature, under the key for the configured unit. Only one is present,
+	// unless it isn't written.
+	TemperatureC *float64 `json:"temperature_c,omitempty"`
+	TemperatureF *float64 `json:"temperature_f,omitempty"`
+	TemperatureK *float64 `json:"temperature_k,omitempty"`
+	// Pressure, under the key for the configured unit. Only one is present,
+	// unless it isn't written.
+	PressurePa   *float64 `json:"pressure_pa,omitempty"`
+	PressureHPa  *float64 `json:"pressure_hpa,omitempty"`
+	PressureKPa  *float64 `json:"pressure_kpa,omitempty"`
+	PressureInHg *float64 `json:"pressure_inhg,omitempty"`
+	// Relative humidity in %, omitted for sensors without humidity
+	Humidity *float64 `json:"humidity_pct,omitempty"`
+	// Supply voltage in V, omitted unless it's measured and could be read
+	Voltage *float64 `json:"voltage_v,omitempty"`
+	// Gas resistance in Ω, omitted unless it's measured and was valid
+	Gas *float64 `json:"gas_ohms,omitempty"`
+	// Whether the pressure is falling quickly, omitted unless it's checked
+	PressureFalling *bool `json:"pressure_falling,omitempty"`
+	// Whether the humidity risks mold, omitted unless it's checked
+	MoldRisk *bool `json:"mold_risk,omitempty"`
+	// Sequence number of the reading, omitted unless it's selected
+	Seq *uint64 `json:"seq,omitempty"`
+	// Time of the reading, in RFC3339 format
+	Time string `json:"time"`
+	// Label of the sensor, omitted when only one sensor is in use
+	Sensor string `json:"sensor,omitempty"`
+	// Host name, location and device ID of the monitor, omitted when unknown
+	Host     string `json:"host,omitempty"`
+	Location string `json:"location,omitempty"`
+	Device   string `json:"device,omitempty"`
+	// Minimum, maximum and standard deviation of each field over the
+	// averaging window, keyed as e.g. temp_min, temp_max and temp_std. Omitted
+	// for moving averages.
+	Stats map[string]float64 `json:"stats,omitempty"`
+	// Values calculated from the reading, such as the dew_point temperature. Values that
+	// need the humidity are omitted for sensors without one.
+	Derived map[string]float64 `json:"derived,omitempty"`
+	// Unit of each of the values above that has one, keyed by the value's
+	// name, such as "hPa" for pressure_hpa and pressure_min
+	Units map[string]string `json:"units,omitempty"`
+}
+
+func newStdoutSink(w io.Writer, output OutputConfig) *StdoutSink {
+	return &StdoutSink{encoder: json.NewEncoder(w), output: output}
+}
+
+func newJSONRecord(reading Reading, t time.Time, output OutputConfig) jsonRecord {
+	temp, pressure, humidity := convertEnv(reading.Env, output)
+	tags := readingTags(reading, output)
+
+	record := jsonRecord{
+		Time:     t.Format(time.RFC3339),
+		Sensor:   tags["sensor"],
+		Host:     tags["host"],
+		Location: tags["location"],
+		Device:   tags["device"],
+	}
+	if output.Fields.Temperature {
+		switch output.TemperatureUnit {
+		case Celsius:
+			record.TemperatureC = &temp
+		case Fahrenheit:
+			record.TemperatureF = &temp
+		case Kelvin:
+			record.TemperatureK = &temp
+		}
+	}
+	if output.Fields.Pressure {
+		switch output.PressureUnit {
+		case Pascal:
+			record.PressurePa = &pressure
+		case Hectopascal:
+			record.PressureHPa = &pressure
+		case Kilopascal:
+			record.PressureKPa = &pressure
+		case InchOfHg:
+			record.PressureInHg = &pressure
+		}
+	}
+	if reading.HasHumidity {
+		record.Humidity = &humidity
+	}
+	if reading.Voltage != nil {
+		v := volts(*reading.Voltage)
+		record.Voltage = &v
+	}
+	if reading.Gas != nil {
+		g := ohms(*reading.Gas)
+		record.Gas = &g
+	}
+	if output.Fields.Pressure {
+		record.PressureFalling = reading.PressureFalling
+	}
+	if output.Fields.Humidity {
+		record.MoldRisk = reading.MoldRisk
+	}
+	if output.Fields.Seq {
+		record.Seq = &reading.Seq
+	}
+	if reading.Stats != nil {
+		record.Stats = convertStats(reading.Stats, reading.HasHumidity, output)
+	}
+	if derived := derivedFields(reading, output); len(derived) > 0 {
+		record.Derived = derived
+	}
+	record.Units = record.units(output)
+	return record
+}
+
+func (r jsonRecord) units(output OutputConfig) map[string]string {
+	// The unit of each value in `r`, which depends on the configured units,
+	// so that consumers don't have to know how the monitor was set up
+
+	temp := temperatureUnitSymbols[output.TemperatureUnit]
+	pressure := pressureUnitSymbols[output.PressureUnit]
+	// Units of the statistics, derived values and rates, by the value
+	// they're calculated from
+	valueUnits := map[string]string{
+		"temp":               temp,
+		"pressure":           pressure,
+		"humidity":           "%rH",
+		"dew_point":          temp,
+		"heat_index":         temp,
+		"absolute_humidity":  "g/m³",
+		"sea_level_pressure": pressure,
+	}
+
+	units := map[string]string{}
+	if r.TemperatureC != nil || r.TemperatureF != nil || r.TemperatureK != nil {
+		units["temperature_"+string(output.TemperatureUnit)] = temp
+	}
+	if r.PressurePa != nil || r.PressureHPa != nil || r.PressureKPa != nil || r.PressureInHg != nil {
+		units["pressure_"+string(output.PressureUnit)] = pressure
+	}
+	if r.Humidity != nil {
+		units["humidity_pct"] = "%rH"
+	}
+	if r.Voltage != nil {
+		units["voltage_v"] = "V"
+	}
+	if r.Gas != nil {
+		units["gas_ohms"] = "Ω"
+	}
+	for name := range r.Stats {
+		value, _, _ := strings.Cut(name, "_")
+		units[name] = valueUnits[value]
+	}
+	for name := range r.Derived {
+		if value, ok := strings.CutSuffix(name, "_rate"); ok {
+			units[name] = valueUnits[value] + "/min"
+		} else {
+			units[name] = valueUnits[name]
+		}
+	}
+	if len(units) == 0 {
+		return nil
+	}
+	return units
+}
+
+func (s *StdoutSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	return s.encoder.Encode(newJSONRecord(reading, t, s.output))
+}
+
+func (s *StdoutSink) Close() error {
+	return nil
+}
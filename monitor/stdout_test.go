@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestJSONRecordUnits(t *testing.T) {
+	tests := []struct {
+		name     string
+		temp     TemperatureUnit
+		pressure PressureUnit
+		fields   Fields
+		want     map[string]string
+	}{
+		{"celsius and hectopascals", Celsius, Hectopascal, Fields{Temperature: true, Pressure: true, Humidity: true}, map[string]string{
+			"temperature_c": "°C", "pressure_hpa": "hPa", "humidity_pct": "%rH",
+			"temp_min": "°C", "temp_max": "°C", "temp_std": "°C",
+			"pressure_min": "hPa", "pressure_max": "hPa", "pressure_std": "hPa",
+			"humidity_min": "%rH", "humidity_max": "%rH", "humidity_std": "%rH",
+			"dew_point": "°C", "heat_index": "°C", "absolute_humidity": "g/m³", "sea_level_pressure": "hPa",
+			"temp_rate": "°C/min", "pressure_rate": "hPa/min", "humidity_rate": "%rH/min",
+		}},
+		{"fahrenheit and inches of mercury", Fahrenheit, InchOfHg, Fields{Temperature: true, Pressure: true, Humidity: true}, map[string]string{
+			"temperature_f": "°F", "pressure_inhg": "inHg", "humidity_pct": "%rH",
+			"temp_min": "°F", "temp_max": "°F", "temp_std": "°F",
+			"pressure_min": "inHg", "pressure_max": "inHg", "pressure_std": "inHg",
+			"humidity_min": "%rH", "humidity_max": "%rH", "humidity_std": "%rH",
+			"dew_point": "°F", "heat_index": "°F", "absolute_humidity": "g/m³", "sea_level_pressure": "inHg",
+			"temp_rate": "°F/min", "pressure_rate": "inHg/min", "humidity_rate": "%rH/min",
+		}},
+		// Only the values written are described
+		{"pressure only", Kelvin, Pascal, Fields{Pressure: true}, map[string]string{
+			"pressure_pa": "Pa", "humidity_pct": "%rH",
+			"pressure_min": "Pa", "pressure_max": "Pa", "pressure_std": "Pa",
+			"humidity_min": "%rH", "humidity_max": "%rH", "humidity_std": "%rH",
+			"pressure_rate": "Pa/min", "humidity_rate": "%rH/min",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var window accumulator
+			window.add(testReading(20, 0))
+			window.add(testReading(22, time.Second))
+			reading := window.average(mean, "end")
+			reading.Rate = &physic.Env{Temperature: physic.Kelvin, Pressure: 10 * physic.Pascal, Humidity: physic.PercentRH}
+			output := OutputConfig{TemperatureUnit: tt.temp, PressureUnit: tt.pressure, Fields: tt.fields, Altitude: 100}
+
+			record := newJSONRecord(reading, reading.Time, output)
+			if !maps.Equal(record.Units, tt.want) {
+				t.Errorf("units = %v, want %v", record.Units, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONUnitsFollowPressureUnit(t *testing.T) {
+	for _, unit := range pressureUnits {
+		t.Run(string(unit), func(t *testing.T) {
+			var out bytes.Buffer
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: unit, Fields: Fields{Pressure: true}}
+			if err := newStdoutSink(&out, output).Write(context.Background(), testReading(20, 0), testTime); err != nil {
+				t.Fatal(err)
+			}
+
+			var record struct {
+				Units map[string]string `json:"units"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+				t.Fatalf("invalid JSON %q: %v", out.String(), err)
+			}
+			want := map[string]string{"pressure_" + string(unit): pressureUnitSymbols[unit], "humidity_pct": "%rH"}
+			if !maps.Equal(record.Units, want) {
+				t.Errorf("units = %v, want %v", record.Units, want)
+			}
+		})
+	}
+}
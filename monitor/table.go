@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Number of rows printed by the table sink between repeats of the header, so
+// it stays in view while watching a terminal
+const tableHeaderEvery = 20
+
+// Widths of the table's columns
+const (
+	tableTimeWidth   = 20
+	tableSensorWidth = 12
+	tableValueWidth  = 15
+)
+
+// TableSink prints each reading as a row of a table with aligned columns, for
+// watching in a terminal
+type TableSink struct {
+	w      io.Writer
+	output OutputConfig
+	// Number of rows printed since the header
+	rows int
+}
+
+func newTableSink(w io.Writer, output OutputConfig) *TableSink {
+	return &TableSink{w: w, output: output}
+}
+
+func tableHeader(output OutputConfig) string {
+	return fmt.Sprintf("%s %s %s %s %s",
+		alignLeft("TIME", tableTimeWidth),
+		alignLeft("SENSOR", tableSensorWidth),
+		alignRight("TEMP ("+temperatureUnitSymbols[output.TemperatureUnit]+")", tableValueWidth),
+		alignRight("PRESSURE ("+pressureUnitSymbols[output.PressureUnit]+")", tableValueWidth),
+		alignRight("HUMIDITY (%rH)", tableValueWidth))
+}
+
+func padding(s string, width int) string {
+	// The spaces needed to pad `s` to `width` characters. Unlike `fmt`'s
+	// padding, this counts characters rather than bytes, so symbols like °
+	// don't shift the columns.
+	return strings.Repeat(" ", max(width-utf8.RuneCountInString(s), 0))
+}
+
+func alignLeft(s string, width int) string {
+	return s + padding(s, width)
+}
+
+func alignRight(s string, width int) string {
+	return padding(s, width) + s
+}
+
+func formatReading(reading Reading, output OutputConfig) string {
+	// Format the values of `reading` as right-aligned columns in the units
+	// written by the sinks. Columns are blank for values that aren't written,
+	// and for the humidity of readings without one.
+
+	temp, pressure, humidity := convertEnv(reading.Env, output)
+	column := func(value float64, written bool) string {
+		if !written {
+			return fmt.Sprintf("%*s", tableValueWidth, "-")
+		}
+		return fmt.Sprintf("%*.2f", tableValueWidth, value)
+	}
+	return column(temp, output.Fields.Temperature) + " " +
+		column(pressure, output.Fields.Pressure) + " " +
+		column(humidity, reading.HasHumidity)
+}
+
+func (s *TableSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	if s.rows%tableHeaderEvery == 0 {
+		if _, err := fmt.Fprintln(s.w, tableHeader(s.output)); err != nil {
+			return err
+		}
+	}
+	s.rows++
+
+	label := reading.Label
+	if label == "" {
+		label = "-"
+	}
+	_, err := fmt.Fprintf(s.w, "%s %s %s\n",
+		alignLeft(t.Format(time.RFC3339), tableTimeWidth),
+		alignLeft(label, tableSensorWidth),
+		formatReading(reading, s.output))
+	return err
+}
+
+func (s *TableSink) Close() error {
+	return nil
+}
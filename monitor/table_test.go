@@ -0,0 +1,93 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestFormatReadingAlignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		temp     float64
+		pressure float64
+		unit     PressureUnit
+		humidity float64
+		fields   Fields
+		want     string
+	}{
+		{"typical", 21.5, 1013.25, Hectopascal, 45, Fields{Temperature: true, Pressure: true, Humidity: true},
+			"          21.50         1013.25           45.00"},
+		{"below zero", -40, 1013.25, Hectopascal, 0, Fields{Temperature: true, Pressure: true, Humidity: true},
+			"         -40.00         1013.25            0.00"},
+		{"large pressure", 0, 101325, Pascal, 100, Fields{Temperature: true, Pressure: true, Humidity: true},
+			"           0.00       101325.00          100.00"},
+		{"small pressure", 0.004, 29.92, InchOfHg, 0.5, Fields{Temperature: true, Pressure: true, Humidity: true},
+			"           0.00           29.92            0.50"},
+		{"not written", 21.5, 1013.25, Hectopascal, 45, Fields{Temperature: true},
+			"          21.50               -               -"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: tt.unit, Fields: tt.fields}
+			reading := testReading(tt.temp, 0)
+			reading.Pressure = pressureIn(tt.pressure, tt.unit)
+			reading.Humidity = percentRH(tt.humidity)
+			reading.HasHumidity = tt.fields.Humidity
+
+			if got := formatReading(reading, output); got != tt.want {
+				t.Errorf("formatReading() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableSink(t *testing.T) {
+	tests := []struct {
+		name  string
+		unit  TemperatureUnit
+		rows  int
+		label string
+		// Lines that are headers
+		headers []int
+	}{
+		{"one row", Celsius, 1, "", []int{0}},
+		{"header repeated", Fahrenheit, tableHeaderEvery + 1, "garden", []int{0, tableHeaderEvery + 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			output := OutputConfig{TemperatureUnit: tt.unit, PressureUnit: Hectopascal, Fields: Fields{Temperature: true, Pressure: true, Humidity: true}}
+			sink := newTableSink(&out, output)
+			for i := 0; i < tt.rows; i++ {
+				reading := testReading(20, time.Duration(i)*time.Second)
+				reading.Label = tt.label
+				if err := sink.Write(context.Background(), reading, reading.Time); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != tt.rows+len(tt.headers) {
+				t.Fatalf("printed %d lines, want %d", len(lines), tt.rows+len(tt.headers))
+			}
+			header := tableHeader(output)
+			for i, line := range lines {
+				isHeader := false
+				for _, h := range tt.headers {
+					isHeader = isHeader || h == i
+				}
+				if isHeader != (line == header) {
+					t.Errorf("line %d = %q, header = %v, want %v", i+1, line, line == header, isHeader)
+				}
+				// Columns line up with the header's, even with the ° in it
+				if got, want := utf8.RuneCountInString(line), utf8.RuneCountInString(header); got != want {
+					t.Errorf("line %d is %d characters wide, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
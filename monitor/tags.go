@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Names of the tags identifying where a reading was taken, in the order the
+// CSV sink writes them
+var tagNames = []string{"sensor", "host", "location", "device"}
+
+func readingTags(reading Reading, output OutputConfig) map[string]string {
+	// The tags identifying where `reading` was taken: the label of its sensor,
+	// and the host, location and device ID of the monitor. Empty tags are
+	// omitted, so each sink writes the same tags.
+
+	tags := map[string]string{}
+	for name, value := range map[string]string{
+		"sensor":   reading.Label,
+		"host":     output.Host,
+		"location": output.Location,
+		"device":   output.DeviceID,
+	} {
+		if value != "" {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
+func ValidateDeviceID(id string) error {
+	// Check that `id` can be written as a tag. Spaces, commas and equals
+	// signs are escaped in line protocol, but line breaks and other control
+	// characters can't be, and a trailing backslash would escape the
+	// character after it.
+
+	if strings.IndexFunc(id, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid device ID %q: must not contain control characters", id)
+	}
+	if strings.HasSuffix(id, `\`) {
+		return fmt.Errorf("invalid device ID %q: must not end with a backslash", id)
+	}
+	return nil
+}
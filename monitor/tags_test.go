@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestPointsAreTagged(t *testing.T) {
+	tests := []struct {
+		name     string
+		label    string
+		host     string
+		location string
+		device   string
+		want     map[string]string
+	}{
+		{"untagged", "", "", "", "", map[string]string{}},
+		{"host", "", "pi", "", "", map[string]string{"host": "pi"}},
+		{"host and location", "", "pi", "kitchen", "", map[string]string{"host": "pi", "location": "kitchen"}},
+		{"sensor label", "window", "pi", "kitchen", "", map[string]string{"sensor": "window", "host": "pi", "location": "kitchen"}},
+		{"device ID", "", "pi", "", "unit-7", map[string]string{"host": "pi", "device": "unit-7"}},
+		{"device ID with spaces", "window", "pi", "kitchen", "unit 7, shed", map[string]string{"sensor": "window", "host": "pi", "location": "kitchen", "device": "unit 7, shed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}, Host: tt.host, Location: tt.location, DeviceID: tt.device}
+			reading := testReading(20, 0)
+			reading.Label = tt.label
+
+			got := map[string]string{}
+			for _, tag := range newInfluxPoint(reading, reading.Time, "environment", output).TagList() {
+				got[tag.Key] = tag.Value
+			}
+			// The units are tagged too, whatever the other tags
+			want := maps.Clone(tt.want)
+			want["temp_unit"], want["pressure_unit"] = "c", "hpa"
+			if !maps.Equal(got, want) {
+				t.Errorf("point tags = %v, want %v", got, want)
+			}
+
+			record := newJSONRecord(reading, reading.Time, output)
+			if record.Sensor != tt.want["sensor"] || record.Host != tt.want["host"] || record.Location != tt.want["location"] || record.Device != tt.want["device"] {
+				t.Errorf("JSON record sensor, host, location, device = %q, %q, %q, %q, want %q, %q, %q, %q",
+					record.Sensor, record.Host, record.Location, record.Device, tt.want["sensor"], tt.want["host"], tt.want["location"], tt.want["device"])
+			}
+		})
+	}
+}
+
+func TestValidateDeviceID(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{"", false},
+		{"unit-7", false},
+		{"unit 7, shed=north", false},
+		{`c:\units\7`, false},
+		{"unit\n7", true},
+		{"unit\t7", true},
+		{`unit-7\`, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateDeviceID(tt.id); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateDeviceID(%q) = %v, want error %v", tt.id, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WriteTimeouts is the time allowed for each write to a sink before it's
+// given up on as failed, by sink name. A timeout of 0 lets writes take as
+// long as they need.
+type WriteTimeouts struct {
+	// Timeout for the sinks that aren't in `Sinks`
+	Default time.Duration
+	Sinks   map[string]time.Duration
+}
+
+func (w WriteTimeouts) For(name string) time.Duration {
+	if timeout, ok := w.Sinks[name]; ok {
+		return timeout
+	}
+	return w.Default
+}
+
+func ParseWriteTimeouts(value string) (timeouts WriteTimeouts, err error) {
+	// Parse a comma-separated list of timeouts, each either a duration for
+	// every sink, or `<sink>=<duration>` for one of them, such as
+	// "10s,postgres=30s"
+
+	timeouts.Sinks = map[string]time.Duration{}
+	seenDefault := false
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		name, duration, named := strings.Cut(entry, "=")
+		if !named {
+			name, duration = "", entry
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil {
+			return WriteTimeouts{}, fmt.Errorf("invalid write timeout %q: %v", entry, err)
+		}
+		if timeout < 0 {
+			return WriteTimeouts{}, fmt.Errorf("invalid write timeout %q: must be at least 0", entry)
+		}
+
+		if !named {
+			if seenDefault {
+				return WriteTimeouts{}, fmt.Errorf("write timeout %q given for every sink twice", entry)
+			}
+			timeouts.Default, seenDefault = timeout, true
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if !slices.Contains(SinkNames, name) {
+			return WriteTimeouts{}, fmt.Errorf("unknown sink %q in write timeout %q", name, entry)
+		}
+		if _, ok := timeouts.Sinks[name]; ok {
+			return WriteTimeouts{}, fmt.Errorf("write timeout given for %s twice", name)
+		}
+		timeouts.Sinks[name] = timeout
+	}
+	return timeouts, nil
+}
+
+// TimeoutSink gives up on writes to another sink that take longer than
+// `timeout`, failing them so they're retried or queued like any other failed
+// write, rather than holding up the writes after them. The write is
+// cancelled through its context, but as not every sink stops when it's told
+// to, further writes fail straight away until it returns, so the sink is
+// never written to concurrently.
+type TimeoutSink struct {
+	sink    Sink
+	name    string
+	timeout time.Duration
+	clock   Clock
+	// Closed once a write that timed out returns, or nil if none is still
+	// running
+	hung <-chan struct{}
+}
+
+func newTimeoutSink(sink Sink, name string, timeout time.Duration, clock Clock) *TimeoutSink {
+	return &TimeoutSink{sink: sink, name: name, timeout: timeout, clock: clock}
+}
+
+func (s *TimeoutSink) Write(ctx context.Context, reading Reading, t time.Time) error {
+	if s.hung != nil {
+		select {
+		case <-s.hung:
+			s.hung = nil
+		default:
+			return fmt.Errorf("an earlier write to %s that timed out still hasn't returned", s.name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errs <- s.sink.Write(ctx, reading, t)
+	}()
+
+	timer := s.clock.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errs:
+		return err
+	case <-timer.C():
+		s.hung = done
+		slog.Warn("Write timed out", "sink", s.name, "timeout", s.timeout)
+		sinkWriteTimeouts.Inc()
+		return fmt.Errorf("write to %s timed out after %s", s.name, s.timeout)
+	}
+}
+
+func (s *TimeoutSink) Close() error {
+	// A write that timed out may still be using the sink, so wait for it
+	// before closing it. Each write is cancelled when it times out, so this
+	// is only as long as the sink takes to notice.
+
+	if s.hung != nil {
+		<-s.hung
+	}
+	return s.sink.Close()
+}
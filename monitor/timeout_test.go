@@ -0,0 +1,159 @@
+package monitor
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseWriteTimeouts(t *testing.T) {
+	tests := []struct {
+		value       string
+		wantDefault time.Duration
+		wantSinks   map[string]time.Duration
+		wantErr     bool
+	}{
+		{"30s", 30 * time.Second, map[string]time.Duration{}, false},
+		{"0", 0, map[string]time.Duration{}, false},
+		{"postgres=1m", 0, map[string]time.Duration{"postgres": time.Minute}, false},
+		{"10s, postgres = 1m, mqtt=0", 10 * time.Second, map[string]time.Duration{"postgres": time.Minute, "mqtt": 0}, false},
+		{"10", 0, nil, true},
+		{"-1s", 0, nil, true},
+		{"10s,20s", 0, nil, true},
+		{"kafka=10s", 0, nil, true},
+		{"csv=10s,csv=20s", 0, nil, true},
+		{"csv=", 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseWriteTimeouts(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWriteTimeouts(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Default != tt.wantDefault || !maps.Equal(got.Sinks, tt.wantSinks) {
+				t.Errorf("ParseWriteTimeouts(%q) = %+v, want default %v and %v", tt.value, got, tt.wantDefault, tt.wantSinks)
+			}
+		})
+	}
+}
+
+func TestWriteTimeoutsFor(t *testing.T) {
+	timeouts := WriteTimeouts{Default: 30 * time.Second, Sinks: map[string]time.Duration{"postgres": time.Minute, "mqtt": 0}}
+	tests := []struct {
+		sink string
+		want time.Duration
+	}{
+		{"influx", 30 * time.Second},
+		{"postgres", time.Minute},
+		{"mqtt", 0},
+	}
+	for _, tt := range tests {
+		if got := timeouts.For(tt.sink); got != tt.want {
+			t.Errorf("For(%q) = %v, want %v", tt.sink, got, tt.want)
+		}
+	}
+}
+
+func TestTimeoutSink(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the write returns before the timeout
+		returns bool
+		wantErr string
+	}{
+		{"returns in time", true, ""},
+		{"hangs", false, "write to influx timed out after 10s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testTime)
+			slow := newBlockingSink()
+			if tt.returns {
+				close(slow.release)
+			}
+			sink := newTimeoutSink(slow, "influx", 10*time.Second, clock)
+			timeouts := testutil.ToFloat64(sinkWriteTimeouts)
+
+			written := make(chan error, 1)
+			go func() { written <- sink.Write(context.Background(), testReading(20, 0), testTime) }()
+			if !tt.returns {
+				// The timer is only certain to still be pending when the
+				// write hangs
+				clock.BlockUntil(1)
+				clock.Advance(10 * time.Second)
+			}
+			err, _ := receive(t, written)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Write() = %v, want no error", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("Write() = %v, want %q", err, tt.wantErr)
+			}
+			wantTimeouts := 0.0
+			if !tt.returns {
+				wantTimeouts = 1
+			}
+			if got := testutil.ToFloat64(sinkWriteTimeouts) - timeouts; got != wantTimeouts {
+				t.Errorf("counted %v timeouts, want %v", got, wantTimeouts)
+			}
+
+			if !tt.returns {
+				// Writes fail straight away while the last one hangs, and
+				// Close waits for it
+				err := sink.Write(context.Background(), testReading(21, time.Minute), testTime.Add(time.Minute))
+				if err == nil || !strings.Contains(err.Error(), "still hasn't returned") {
+					t.Errorf("Write() while hung = %v, want it to fail straight away", err)
+				}
+				closed := make(chan error, 1)
+				go func() { closed <- sink.Close() }()
+				select {
+				case <-closed:
+					t.Fatal("Close() returned while a write was hung")
+				case <-time.After(10 * time.Millisecond):
+				}
+				close(slow.release)
+				receive(t, closed)
+			} else if err := sink.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+			if !slow.closed {
+				t.Error("the sink wasn't closed")
+			}
+		})
+	}
+}
+
+func TestNewNamedSinkTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts WriteTimeouts
+		want     bool
+	}{
+		{"default", WriteTimeouts{Default: 30 * time.Second}, true},
+		{"for the sink", WriteTimeouts{Sinks: map[string]time.Duration{"stdout": time.Second}}, true},
+		{"for another sink", WriteTimeouts{Sinks: map[string]time.Duration{"csv": time.Second}}, false},
+		{"turned off for the sink", WriteTimeouts{Default: 30 * time.Second, Sinks: map[string]time.Duration{"stdout": 0}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Format = "json"
+			config.Clock = NewFakeClock(testTime)
+			config.WriteTimeouts = tt.timeouts
+			sink, err := newNamedSink("stdout", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, got := sink.(*TimeoutSink); got != tt.want {
+				t.Errorf("newNamedSink() = %T, want a timeout %v", sink, tt.want)
+			}
+		})
+	}
+}
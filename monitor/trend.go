@@ -0,0 +1,89 @@
+package monitor
+
+import (
+	"log/slog"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// PressureTrendConfig describes when pressure is reported as falling, which
+// often means a storm is coming
+type PressureTrendConfig struct {
+	// Fall in hPa per hour beyond which the pressure is falling, or 0 to not
+	// check
+	FallRate float64
+	// Length of time the rate is worked out over
+	Window time.Duration
+}
+
+type pressureSample struct {
+	t time.Time
+	// Pressure in hPa
+	pressure float64
+}
+
+// pressureTrendTracker works out whether the pressure of successive averages
+// from a sensor is falling quickly
+type pressureTrendTracker struct {
+	// Averages within the window, oldest first
+	samples []pressureSample
+	falling bool
+}
+
+func (p *pressureTrendTracker) update(reading *Reading, t time.Time, config PressureTrendConfig) {
+	// Mark `reading`, made at time `t`, as falling or not, from the slope of
+	// the pressures over the `config.Window` up to it. Averages aren't
+	// marked until half a window of them has been seen.
+
+	if config.FallRate <= 0 {
+		return
+	}
+	p.samples = append(p.samples, pressureSample{t, float64(reading.Pressure) / float64(100*physic.Pascal)})
+	start := 0
+	for start < len(p.samples) && t.Sub(p.samples[start].t) > config.Window {
+		start++
+	}
+	p.samples = p.samples[start:]
+	if t.Sub(p.samples[0].t) < config.Window/2 {
+		return
+	}
+
+	slope := pressureSlope(p.samples)
+	falling := slope <= -config.FallRate
+	if falling != p.falling {
+		if falling {
+			slog.Warn("Pressure falling quickly", "sensor", reading.Label, "hpa_per_hour", slope, "fall_rate", config.FallRate)
+		} else {
+			slog.Info("Pressure no longer falling quickly", "sensor", reading.Label, "hpa_per_hour", slope)
+		}
+		p.falling = falling
+	}
+	reading.PressureFalling = &falling
+}
+
+func pressureSlope(samples []pressureSample) float64 {
+	// The change in pressure in hPa per hour over `samples`, from a least
+	// squares fit, so unevenly spaced samples and noise in any one of them
+	// are allowed for. Returns 0 for fewer than 2 samples or if they're all
+	// at the same time.
+
+	if len(samples) < 2 {
+		return 0
+	}
+	// Times are measured from the first sample, to keep the sums small
+	n := float64(len(samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.t.Sub(samples[0].t).Hours()
+		sumX += x
+		sumY += s.pressure
+		sumXY += x * s.pressure
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
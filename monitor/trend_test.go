@@ -0,0 +1,124 @@
+package monitor
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func hectopascals(p float64) physic.Pressure {
+	return physic.Pressure(math.Round(p*100)) * physic.Pascal
+}
+
+func TestPressureSlope(t *testing.T) {
+	tests := []struct {
+		name string
+		// Minutes since the first sample, and pressure in hPa, of each sample
+		minutes   []time.Duration
+		pressures []float64
+		want      float64
+	}{
+		{"no samples", nil, nil, 0},
+		{"one sample", []time.Duration{0}, []float64{1013}, 0},
+		{"all at once", []time.Duration{0, 0}, []float64{1013, 1010}, 0},
+		{"flat", []time.Duration{0, 30, 60}, []float64{1013, 1013, 1013}, 0},
+		{"falling", []time.Duration{0, 30, 60}, []float64{1013, 1012, 1011}, -2},
+		{"rising", []time.Duration{0, 60, 120}, []float64{1000, 1001, 1002}, 1},
+		{"unevenly spaced", []time.Duration{0, 10, 60}, []float64{1013, 1012.5, 1010}, -3},
+		// The least squares fit isn't swung by a single noisy sample
+		{"noisy", []time.Duration{0, 30, 60, 90, 120}, []float64{1013, 1012.5, 1013, 1011.5, 1011}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := make([]pressureSample, len(tt.minutes))
+			for i, minutes := range tt.minutes {
+				samples[i] = pressureSample{testTime.Add(minutes * time.Minute), tt.pressures[i]}
+			}
+			if got := pressureSlope(samples); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("pressureSlope() = %v hPa/h, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPressureTrendTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallRate float64
+		// Pressure of averages 30 minutes apart, in hPa
+		pressures []float64
+		// How each is marked: "" for not marked, "falling" or "steady"
+		want []string
+	}{
+		{"not checked", 0, []float64{1013, 1010, 1007}, []string{"", "", ""}},
+		{"steady", 1, []float64{1013, 1013, 1013, 1013}, []string{"", "", "steady", "steady"}},
+		{"falling at the rate", 1, []float64{1013, 1012.5, 1012, 1011.5}, []string{"", "", "falling", "falling"}},
+		{"falling slowly", 1, []float64{1013, 1012.75, 1012.5, 1012.25}, []string{"", "", "steady", "steady"}},
+		{"rising", 1, []float64{1000, 1002, 1004, 1006}, []string{"", "", "steady", "steady"}},
+		// Once the fall is out of the window, it's no longer falling
+		{"recovers", 1, []float64{1013, 1012, 1011, 1011, 1011, 1011, 1011}, []string{"", "", "falling", "falling", "falling", "steady", "steady"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := PressureTrendConfig{FallRate: tt.fallRate, Window: 2 * time.Hour}
+			var tracker pressureTrendTracker
+			var got []string
+			for i, pressure := range tt.pressures {
+				reading := testReading(20, time.Duration(i)*30*time.Minute)
+				reading.Pressure = hectopascals(pressure)
+				tracker.update(&reading, reading.Time, config)
+				switch {
+				case reading.PressureFalling == nil:
+					got = append(got, "")
+				case *reading.PressureFalling:
+					got = append(got, "falling")
+				default:
+					got = append(got, "steady")
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("marked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPressureFallingIsWritten(t *testing.T) {
+	falling := true
+	tests := []struct {
+		name     string
+		fields   Fields
+		falling  *bool
+		wantSent bool
+	}{
+		{"marked", Fields{Temperature: true, Pressure: true}, &falling, true},
+		{"not marked", Fields{Temperature: true, Pressure: true}, nil, false},
+		{"pressure not written", Fields{Temperature: true}, &falling, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := testConfig().Output
+			output.Fields = tt.fields
+			reading := testReading(20, 0)
+			reading.PressureFalling = tt.falling
+
+			point := newInfluxPoint(reading, reading.Time, "environment", output)
+			inPoint := false
+			for _, field := range point.FieldList() {
+				if field.Key == "pressure_falling" {
+					inPoint = field.Value == true
+				}
+			}
+			if inPoint != tt.wantSent {
+				t.Errorf("point has pressure_falling = %v, want %v", inPoint, tt.wantSent)
+			}
+			record := newJSONRecord(reading, reading.Time, output)
+			if inRecord := record.PressureFalling != nil; inRecord != tt.wantSent {
+				t.Errorf("JSON record has pressure_falling = %v, want %v", inRecord, tt.wantSent)
+			}
+		})
+	}
+}
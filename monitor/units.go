@@ -0,0 +1,343 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// A TemperatureUnit is a unit that temperatures can be written in
+type TemperatureUnit string
+
+const (
+	Celsius    TemperatureUnit = "c"
+	Fahrenheit TemperatureUnit = "f"
+	Kelvin     TemperatureUnit = "k"
+)
+
+var temperatureUnits = []TemperatureUnit{Celsius, Fahrenheit, Kelvin}
+
+// Symbols shown alongside temperatures in each unit
+var temperatureUnitSymbols = map[TemperatureUnit]string{
+	Celsius:    "°C",
+	Fahrenheit: "°F",
+	Kelvin:     "K",
+}
+
+// A PressureUnit is a unit that pressures can be written in
+type PressureUnit string
+
+const (
+	Pascal      PressureUnit = "pa"
+	Hectopascal PressureUnit = "hpa"
+	Kilopascal  PressureUnit = "kpa"
+	InchOfHg    PressureUnit = "inhg"
+)
+
+var pressureUnits = []PressureUnit{Pascal, Hectopascal, Kilopascal, InchOfHg}
+
+// Symbols shown alongside pressures in each unit
+var pressureUnitSymbols = map[PressureUnit]string{
+	Pascal:      "Pa",
+	Hectopascal: "hPa",
+	Kilopascal:  "kPa",
+	InchOfHg:    "inHg",
+}
+
+// One inch of mercury at 0°C, in Pa
+const inchOfHgPascals = 3386.389
+
+// Fields selects which of the measured values are averaged and written
+type Fields struct {
+	Temperature bool
+	Pressure    bool
+	Humidity    bool
+	// Set when the supply voltage is measured, rather than by `parseFields`
+	Voltage bool
+	// Set when the sensors are BME680s, which measure gas resistance
+	Gas bool
+	// Sequence number of each reading, for finding missed ones afterwards
+	Seq bool
+}
+
+func ParseFields(value string) (fields Fields, err error) {
+	// Parse a comma-separated list of the values to write, such as
+	// "temp,pressure"
+
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "temp":
+			fields.Temperature = true
+		case "pressure":
+			fields.Pressure = true
+		case "humidity":
+			fields.Humidity = true
+		case "seq":
+			fields.Seq = true
+		default:
+			return fields, fmt.Errorf("unknown field %q: must be temp, pressure, humidity or seq", name)
+		}
+	}
+	return fields, nil
+}
+
+// Names of the fields that `-field_names` can rename
+var renamableFields = []string{"temp", "pressure", "humidity"}
+
+func ParseFieldNames(value string) (map[string]string, error) {
+	// Parse a comma-separated list of fields to rename in InfluxDB points,
+	// each given as <field>=<name>, such as "temp=temperature,humidity=rh".
+	// Every field ends up with a different name.
+
+	names := map[string]string{}
+	if strings.TrimSpace(value) == "" {
+		return names, nil
+	}
+	for _, mapping := range strings.Split(value, ",") {
+		field, name, ok := strings.Cut(mapping, "=")
+		field, name = strings.TrimSpace(field), strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid field name %q: expected <field>=<name>, e.g. temp=temperature", mapping)
+		}
+		if !slices.Contains(renamableFields, field) {
+			return nil, fmt.Errorf("unknown field %q: must be temp, pressure or humidity", field)
+		}
+		if _, ok := names[field]; ok {
+			return nil, fmt.Errorf("field %q is renamed more than once", field)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("the new name for field %q can't be empty", field)
+		}
+		if strings.HasPrefix(name, "_") {
+			return nil, fmt.Errorf("invalid name %q for field %q: names starting with _ are reserved", name, field)
+		}
+		names[field] = name
+	}
+
+	written := map[string]string{}
+	for _, field := range renamableFields {
+		name := field
+		if renamed, ok := names[field]; ok {
+			name = renamed
+		}
+		if other, ok := written[name]; ok {
+			return nil, fmt.Errorf("fields %q and %q would both be written as %q", other, field, name)
+		}
+		written[name] = field
+	}
+	return names, nil
+}
+
+func (o OutputConfig) fieldName(field string) string {
+	// The name `field` is written as in InfluxDB points
+	if name, ok := o.FieldNames[field]; ok {
+		return name
+	}
+	return field
+}
+
+func (f Fields) mask(env physic.Env) physic.Env {
+	// `env` with the values that aren't selected zeroed, so they're never
+	// averaged, checked against alerts or written
+
+	if !f.Temperature {
+		env.Temperature = 0
+	}
+	if !f.Pressure {
+		env.Pressure = 0
+	}
+	if !f.Humidity {
+		env.Humidity = 0
+	}
+	return env
+}
+
+func (f Fields) filter(fields map[string]float64) map[string]float64 {
+	// Remove the fields describing disabled values from `fields`, which are
+	// named after the value, such as temp_min or pressure_rate
+
+	for name := range fields {
+		if (!f.Temperature && strings.HasPrefix(name, "temp_")) || (!f.Pressure && strings.HasPrefix(name, "pressure_")) {
+			delete(fields, name)
+		}
+	}
+	return fields
+}
+
+// OutputConfig controls how sinks present readings
+type OutputConfig struct {
+	TemperatureUnit TemperatureUnit
+	PressureUnit    PressureUnit
+	// Values to write. Readings without humidity are written without it even
+	// if it's selected.
+	Fields Fields
+	// Altitude of the sensors in m, used to calculate the sea-level pressure,
+	// or 0 to skip it
+	Altitude float64
+	// If `Round` is set, temperatures, pressures and humidities, including
+	// their statistics and derived values, are rounded to `Decimals` decimal
+	// places as they're written. Readings are averaged at full precision.
+	Round    bool
+	Decimals int
+	// Name of the machine, user-supplied location and stable identifier of
+	// the unit, written with each reading so that several monitors can share
+	// a database
+	Host     string
+	Location string
+	DeviceID string
+	// New names for the temp, pressure and humidity fields of InfluxDB
+	// points, keyed by their usual names. Fields that aren't in it keep them.
+	FieldNames map[string]string
+}
+
+func ParseTemperatureUnit(value string) (TemperatureUnit, error) {
+	for _, unit := range temperatureUnits {
+		if strings.ToLower(value) == string(unit) {
+			return unit, nil
+		}
+	}
+	return "", fmt.Errorf("unknown temperature unit %q", value)
+}
+
+func convertTemp(t physic.Temperature, unit TemperatureUnit) float64 {
+	// Convert `t` to a number of degrees in `unit`
+
+	switch unit {
+	case Fahrenheit:
+		return t.Celsius()*9/5 + 32
+	case Kelvin:
+		return float64(t) / float64(physic.Kelvin)
+	default:
+		return t.Celsius()
+	}
+}
+
+func temperatureIn(value float64, unit TemperatureUnit) physic.Temperature {
+	// The temperature of `value` degrees in `unit`, the reverse of
+	// `convertTemp`
+
+	switch unit {
+	case Fahrenheit:
+		value = (value - 32) * 5 / 9
+	case Kelvin:
+		return physic.Temperature(value * float64(physic.Kelvin))
+	}
+	return physic.ZeroCelsius + physic.Temperature(value*float64(physic.Kelvin))
+}
+
+func convertCelsius(celsius float64, unit TemperatureUnit) float64 {
+	// Convert a temperature of `celsius` °C to `unit`
+	return convertTemp(physic.ZeroCelsius+physic.Temperature(celsius*float64(physic.Kelvin)), unit)
+}
+
+func convertTempDifference(d physic.Temperature, unit TemperatureUnit) float64 {
+	// Convert a difference between temperatures, such as a standard deviation,
+	// to `unit`. It's the same in K and °C, but larger in °F.
+
+	degrees := float64(d) / float64(physic.Kelvin)
+	if unit == Fahrenheit {
+		return degrees * 9 / 5
+	}
+	return degrees
+}
+
+func ParsePressureUnit(value string) (PressureUnit, error) {
+	for _, unit := range pressureUnits {
+		if strings.ToLower(value) == string(unit) {
+			return unit, nil
+		}
+	}
+	return "", fmt.Errorf("unknown pressure unit %q", value)
+}
+
+func convertPressure(p physic.Pressure, unit PressureUnit) float64 {
+	// Convert `p` to a number of `unit`s
+
+	pascals := float64(p) / float64(physic.Pascal)
+	switch unit {
+	case Hectopascal:
+		return pascals / 100
+	case Kilopascal:
+		return pascals / 1000
+	case InchOfHg:
+		return pascals / inchOfHgPascals
+	default:
+		return pascals
+	}
+}
+
+func pressureIn(value float64, unit PressureUnit) physic.Pressure {
+	// The pressure of `value` `unit`s, the reverse of `convertPressure`
+
+	switch unit {
+	case Hectopascal:
+		value *= 100
+	case Kilopascal:
+		value *= 1000
+	case InchOfHg:
+		value *= inchOfHgPascals
+	}
+	return physic.Pressure(value * float64(physic.Pascal))
+}
+
+func convertEnv(env physic.Env, output OutputConfig) (temp, pressure, humidity float64) {
+	// Convert `env` to the units written by the sinks: the configured
+	// temperature and pressure units, and %RH. The humidity should be ignored for readings
+	// without one.
+
+	temp = output.round(convertTemp(env.Temperature, output.TemperatureUnit))
+	pressure = output.round(convertPressure(env.Pressure, output.PressureUnit))
+	humidity = output.round(float64(env.Humidity) / float64(physic.PercentRH))
+	return
+}
+
+func (o OutputConfig) round(value float64) float64 {
+	// Round `value` to `o.Decimals` decimal places, if `o.Round` is set
+
+	if !o.Round {
+		return value
+	}
+	scale := math.Pow(10, float64(o.Decimals))
+	return math.Round(value*scale) / scale
+}
+
+func (o OutputConfig) roundFields(fields map[string]float64) map[string]float64 {
+	// Round each of `fields` in place, if `o.Round` is set
+
+	for name, value := range fields {
+		fields[name] = o.round(value)
+	}
+	return fields
+}
+
+// Names of the fields written by `convertStats`, in the order they're written
+// to CSV files
+var statsFieldNames = []string{
+	"temp_min", "temp_max", "temp_std",
+	"pressure_min", "pressure_max", "pressure_std",
+	"humidity_min", "humidity_max", "humidity_std",
+}
+
+func convertStats(stats *WindowStats, hasHumidity bool, output OutputConfig) map[string]float64 {
+	// Convert `stats` to the same units as `convertEnv`, as fields named e.g.
+	// temp_min, temp_max and temp_std. The humidity fields are omitted if
+	// `hasHumidity` is false, and the fields of values that aren't written.
+
+	fields := map[string]float64{
+		"temp_min":     convertTemp(stats.Min.Temperature, output.TemperatureUnit),
+		"temp_max":     convertTemp(stats.Max.Temperature, output.TemperatureUnit),
+		"temp_std":     convertTempDifference(stats.StdDev.Temperature, output.TemperatureUnit),
+		"pressure_min": convertPressure(stats.Min.Pressure, output.PressureUnit),
+		"pressure_max": convertPressure(stats.Max.Pressure, output.PressureUnit),
+		"pressure_std": convertPressure(stats.StdDev.Pressure, output.PressureUnit),
+	}
+	if hasHumidity {
+		fields["humidity_min"] = float64(stats.Min.Humidity) / float64(physic.PercentRH)
+		fields["humidity_max"] = float64(stats.Max.Humidity) / float64(physic.PercentRH)
+		fields["humidity_std"] = float64(stats.StdDev.Humidity) / float64(physic.PercentRH)
+	}
+	return output.roundFields(output.Fields.filter(fields))
+}
@@ -0,0 +1,265 @@
+package monitor
+
+import (
+	"maps"
+	"math"
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestConvertPressure(t *testing.T) {
+	// One standard atmosphere in each unit
+	atmosphere := 101325 * physic.Pascal
+	tests := []struct {
+		unit PressureUnit
+		want float64
+	}{
+		{Pascal, 101325},
+		{Hectopascal, 1013.25},
+		{Kilopascal, 101.325},
+		{InchOfHg, 29.9213},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.unit), func(t *testing.T) {
+			if got := convertPressure(atmosphere, tt.unit); math.Abs(got-tt.want) > 1e-4 {
+				t.Errorf("convertPressure(1 atm, %s) = %v, want %v", tt.unit, got, tt.want)
+			}
+			if got := pressureIn(tt.want, tt.unit); math.Abs(float64(got-atmosphere)) > float64(physic.Pascal) {
+				t.Errorf("pressureIn(%v, %s) = %v, want %v", tt.want, tt.unit, got, atmosphere)
+			}
+		})
+	}
+}
+
+func TestParsePressureUnit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    PressureUnit
+		wantErr bool
+	}{
+		{"pa", Pascal, false},
+		{"hPa", Hectopascal, false},
+		{"KPA", Kilopascal, false},
+		{"inhg", InchOfHg, false},
+		{"mbar", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParsePressureUnit(tt.value)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("ParsePressureUnit(%q) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPointRecordsPressureUnit(t *testing.T) {
+	for _, unit := range pressureUnits {
+		t.Run(string(unit), func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: unit, Fields: Fields{Pressure: true}}
+			reading := testReading(20, 0)
+			point := newInfluxPoint(reading, reading.Time, "environment", output)
+
+			tags := map[string]string{}
+			for _, tag := range point.TagList() {
+				tags[tag.Key] = tag.Value
+			}
+			if tags["pressure_unit"] != string(unit) {
+				t.Errorf("pressure_unit tag = %q, want %q", tags["pressure_unit"], unit)
+			}
+			for _, field := range point.FieldList() {
+				if field.Key == "pressure" && field.Value != convertPressure(reading.Pressure, unit) {
+					t.Errorf("pressure = %v, want %v", field.Value, convertPressure(reading.Pressure, unit))
+				}
+			}
+		})
+	}
+}
+
+func TestConvertTemp(t *testing.T) {
+	tests := []struct {
+		name                string
+		celsius             float64
+		fahrenheit, kelvins float64
+	}{
+		{"absolute zero", -273.15, -459.67, 0},
+		{"-40 is the same in °C and °F", -40, -40, 233.15},
+		{"freezing", 0, 32, 273.15},
+		{"room", 21.5, 70.7, 294.65},
+		{"boiling", 100, 212, 373.15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			temp := celsius(tt.celsius)
+			for unit, want := range map[TemperatureUnit]float64{Celsius: tt.celsius, Fahrenheit: tt.fahrenheit, Kelvin: tt.kelvins} {
+				if got := convertTemp(temp, unit); math.Abs(got-want) > 1e-6 {
+					t.Errorf("convertTemp(%v°C, %s) = %v, want %v", tt.celsius, unit, got, want)
+				}
+				if got := temperatureIn(want, unit); math.Abs(float64(got-temp)) > float64(physic.MilliKelvin) {
+					t.Errorf("temperatureIn(%v, %s) = %v, want %v", want, unit, got, temp)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertTempDifference(t *testing.T) {
+	tests := []struct {
+		unit TemperatureUnit
+		want float64
+	}{
+		{Celsius, 2},
+		{Kelvin, 2},
+		{Fahrenheit, 3.6},
+	}
+	for _, tt := range tests {
+		if got := convertTempDifference(2*physic.Kelvin, tt.unit); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("convertTempDifference(2K, %s) = %v, want %v", tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestParseTemperatureUnit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    TemperatureUnit
+		wantErr bool
+	}{
+		{"c", Celsius, false},
+		{"F", Fahrenheit, false},
+		{"k", Kelvin, false},
+		{"celsius", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTemperatureUnit(tt.value)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("ParseTemperatureUnit(%q) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPointRecordsTemperatureUnit(t *testing.T) {
+	for _, unit := range temperatureUnits {
+		t.Run(string(unit), func(t *testing.T) {
+			output := OutputConfig{TemperatureUnit: unit, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}}
+			reading := testReading(-10, 0)
+			point := newInfluxPoint(reading, reading.Time, "environment", output)
+
+			tags := map[string]string{}
+			for _, tag := range point.TagList() {
+				tags[tag.Key] = tag.Value
+			}
+			if tags["temp_unit"] != string(unit) {
+				t.Errorf("temp_unit tag = %q, want %q", tags["temp_unit"], unit)
+			}
+			for _, field := range point.FieldList() {
+				if field.Key == "temp" && field.Value != convertTemp(reading.Temperature, unit) {
+					t.Errorf("temp = %v, want %v", field.Value, convertTemp(reading.Temperature, unit))
+				}
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		round    bool
+		decimals int
+		value    float64
+		want     float64
+	}{
+		{false, 1, 21.456, 21.456},
+		{true, 0, 21.5, 22},
+		{true, 1, 21.456, 21.5},
+		{true, 2, 21.454, 21.45},
+		{true, 1, -3.14, -3.1},
+		{true, 1, -3.15, -3.2},
+		{true, 3, 1013.25, 1013.25},
+	}
+	for _, tt := range tests {
+		output := OutputConfig{Round: tt.round, Decimals: tt.decimals}
+		if got := output.round(tt.value); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("round(%v) to %d decimals = %v, want %v", tt.value, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestRoundingOnlyAtOutput(t *testing.T) {
+	// The mean of 20.04, 20.04 and 20.14 is 20.073, which rounds to 20.1, but
+	// rounding them before averaging would give 20.0
+	output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}, Round: true, Decimals: 1}
+	var window accumulator
+	for _, temp := range []float64{20.04, 20.04, 20.14} {
+		window.add(testReading(temp, 0))
+	}
+	average := window.average(mean, "end")
+	if got := average.Temperature.Celsius(); math.Abs(got-20.0733333) > 1e-6 {
+		t.Errorf("average = %v°C, want full precision", got)
+	}
+
+	want := map[string]float64{"temp": 20.1, "temp_min": 20.0, "temp_max": 20.1, "temp_std": 0}
+	for _, field := range newInfluxPoint(average, average.Time, "environment", output).FieldList() {
+		if value, ok := want[field.Key]; ok && field.Value != value {
+			t.Errorf("%s = %v, want %v", field.Key, field.Value, value)
+		}
+	}
+}
+
+func TestParseFieldNames(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"temp=temperature", map[string]string{"temp": "temperature"}, false},
+		{"temp=temperature, humidity = rh", map[string]string{"temp": "temperature", "humidity": "rh"}, false},
+		// Swapping names leaves every field with a different one
+		{"temp=pressure,pressure=temp", map[string]string{"temp": "pressure", "pressure": "temp"}, false},
+		{"temp", nil, true},
+		{"gas=voc", nil, true},
+		{"temp=a,temp=b", nil, true},
+		{"temp=", nil, true},
+		{"temp=_time", nil, true},
+		{"temp=pressure", nil, true},
+		{"temp=rh,humidity=rh", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseFieldNames(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFieldNames(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("ParseFieldNames(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointUsesFieldNames(t *testing.T) {
+	output := testConfig().Output
+	output.FieldNames = map[string]string{"temp": "temperature", "humidity": "rh"}
+	reading := testReading(20, 0)
+	point := newInfluxPoint(reading, reading.Time, "environment", output)
+
+	fields := map[string]interface{}{}
+	for _, field := range point.FieldList() {
+		fields[field.Key] = field.Value
+	}
+	want := map[string]interface{}{"temperature": 20.0, "pressure": 1013.0, "rh": 50.0}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("field %s = %v, want %v", name, fields[name], value)
+		}
+	}
+	for _, name := range []string{"temp", "humidity"} {
+		if _, ok := fields[name]; ok {
+			t.Errorf("point still has the field %s", name)
+		}
+	}
+}
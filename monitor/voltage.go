@@ -0,0 +1,107 @@
+package monitor
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"periph.io/x/conn/v3/analog"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/devices/v3/ads1x15"
+)
+
+// Inputs of the ADS1115 accepted by the `-voltage_pin` flag
+var voltagePins = map[string]ads1x15.Channel{
+	"A0": ads1x15.Channel0,
+	"A1": ads1x15.Channel1,
+	"A2": ads1x15.Channel2,
+	"A3": ads1x15.Channel3,
+}
+
+// Full-scale range of the ADC. The widest range covers any voltage the
+// ADS1115 can be powered with, so a battery can be read through a divider
+// without tuning the gain.
+const voltageRange = 6144 * physic.MilliVolt
+
+func openVoltagePin(config Config) (pin analog.PinADC, closePin func(), err error) {
+	// Open the input called `config.VoltagePin` of an ADS1115 ADC at its
+	// default address, 0x48. It's on the I²C bus the sensors are read on, or
+	// the first bus when they're read over SPI. With mock sensors, the pin is
+	// a mock too. Returns a nil pin if no input is configured.
+
+	if config.VoltagePin == "" {
+		return nil, func() {}, nil
+	}
+	channel, ok := voltagePins[strings.ToUpper(config.VoltagePin)]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid voltage pin %q: must be one of A0, A1, A2 or A3", config.VoltagePin)
+	}
+	if config.Mock {
+		return mockADC{}, func() {}, nil
+	}
+
+	busName := config.BusName
+	if config.Interface == "spi" {
+		busName = ""
+	}
+	bus, err := i2creg.Open(busName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open I²C bus %q: %v", busName, err)
+	}
+	adc, err := ads1x15.NewADS1115(bus, &ads1x15.DefaultOpts)
+	if err != nil {
+		bus.Close()
+		return nil, nil, err
+	}
+	// The readings are infrequent, so convert at the lowest rate, which is
+	// the least noisy
+	pin, err = adc.PinForChannel(channel, voltageRange, physic.Hertz, ads1x15.BestQuality)
+	if err != nil {
+		bus.Close()
+		return nil, nil, err
+	}
+	return pin, func() {
+		pin.Halt()
+		bus.Close()
+	}, nil
+}
+
+func volts(v physic.ElectricPotential) float64 {
+	return float64(v) / float64(physic.Volt)
+}
+
+func readVoltage(pin analog.PinADC) *physic.ElectricPotential {
+	// Read the voltage on `pin`. Returns nil if `pin` is nil or can't be read,
+	// so that the readings are written without it rather than failing.
+
+	if pin == nil {
+		return nil
+	}
+	sample, err := pin.Read()
+	if err != nil {
+		slog.Warn("Could not read the voltage", "pin", pin, "error", err)
+		return nil
+	}
+	return &sample.V
+}
+
+// Voltage read from a mockADC, that of a charged lithium-ion cell
+const mockVoltage = 3700 * physic.MilliVolt
+
+// mockADC stands in for the ADC when running with mock sensors
+type mockADC struct{}
+
+func (mockADC) String() string   { return "MockADC" }
+func (mockADC) Halt() error      { return nil }
+func (mockADC) Name() string     { return "MockADC" }
+func (mockADC) Number() int      { return -1 }
+func (mockADC) Function() string { return "ADC" }
+
+func (mockADC) Range() (analog.Sample, analog.Sample) {
+	return analog.Sample{}, analog.Sample{V: voltageRange}
+}
+
+func (mockADC) Read() (analog.Sample, error) {
+	return analog.Sample{V: mockVoltage}, nil
+}
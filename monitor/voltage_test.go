@@ -0,0 +1,56 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"periph.io/x/conn/v3/analog"
+)
+
+// failingADC is an ADC whose reads always fail
+type failingADC struct{ mockADC }
+
+func (failingADC) Read() (analog.Sample, error) {
+	return analog.Sample{}, errors.New("ADC not responding")
+}
+
+func TestVoltageIsWrittenWithReading(t *testing.T) {
+	tests := []struct {
+		name string
+		pin  analog.PinADC
+		// Voltage field of the point written, or 0 for none
+		want float64
+	}{
+		{"no pin", nil, 0},
+		{"read", mockADC{}, 3.7},
+		{"read fails", failingADC{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			clock := NewFakeClock(testTime)
+			config.Clock = clock
+			state := newRunState(config)
+
+			// A failed read of the voltage leaves it out rather than failing
+			// the reading
+			reading, ok, err := state.readSensor(newMockSensor(nil, clock), "", true, readVoltage(tt.pin), 1, testBounds, 0)
+			if !ok || err != nil {
+				t.Fatalf("readSensor() = %v, %v", ok, err)
+			}
+			fields := newInfluxPoint(reading, reading.Time, "environment", config.Output).FieldList()
+			var got float64
+			for _, field := range fields {
+				if field.Key == "voltage" {
+					got = field.Value.(float64)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("voltage = %v, want %v", got, tt.want)
+			}
+			if len(fields) < 3 {
+				t.Errorf("point has fields %v, want the environmental ones too", fields)
+			}
+		})
+	}
+}
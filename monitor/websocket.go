@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Number of readings queued for each WebSocket client. A client that falls
+// this far behind is disconnected rather than holding up the others.
+const wsClientBuffer = 16
+
+// How long to wait for a WebSocket client to accept a message
+const wsWriteTimeout = 10 * time.Second
+
+// readingHub broadcasts each new average to the connected WebSocket clients
+type readingHub struct {
+	mu      sync.Mutex
+	clients map[chan timedReading]struct{}
+}
+
+func (h *readingHub) subscribe() chan timedReading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients == nil {
+		h.clients = map[chan timedReading]struct{}{}
+	}
+	ch := make(chan timedReading, wsClientBuffer)
+	h.clients[ch] = struct{}{}
+	return ch
+}
+
+func (h *readingHub) unsubscribe(ch chan timedReading) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+func (h *readingHub) publish(reading Reading, t time.Time) {
+	// Queue `reading` for each client without blocking. Clients whose queue
+	// is full are dropped, closing their channel.
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- timedReading{reading, t}:
+		default:
+			slog.Warn("Dropping slow WebSocket client")
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// Dashboards are often served from elsewhere, and the stream is read-only,
+// so connections are accepted from any origin
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func wsHandler(ctx context.Context, hub *readingHub, output OutputConfig) http.HandlerFunc {
+	// Stream each new average to the client as a JSON message, in the same
+	// format as the stdout sink, until the client disconnects or `ctx` is
+	// cancelled
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already responded with the error
+			slog.Debug("Could not upgrade to WebSocket", "error", err)
+			return
+		}
+		defer conn.Close()
+
+		readings := hub.subscribe()
+		defer hub.unsubscribe(readings)
+		slog.Debug("WebSocket client connected", "remote", r.RemoteAddr)
+
+		// Messages from the client are ignored, but must be read to notice
+		// when it disconnects
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			case <-closed:
+				return
+			case reading, open := <-readings:
+				if !open {
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+						time.Now().Add(wsWriteTimeout))
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(newJSONRecord(reading.reading, reading.t, output)); err != nil {
+					slog.Debug("WebSocket client disconnected", "remote", r.RemoteAddr, "error", err)
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package monitor
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func (h *readingHub) subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+func TestWebSocketStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var hub readingHub
+	output := OutputConfig{TemperatureUnit: Celsius, PressureUnit: Hectopascal, Fields: Fields{Temperature: true}}
+	server := httptest.NewServer(wsHandler(ctx, &hub, output))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); hub.subscribers() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("client never subscribed")
+		}
+	}
+
+	for _, temp := range []float64{20, 21} {
+		reading := testReading(temp, 0)
+		hub.publish(reading, reading.Time)
+
+		var record jsonRecord
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record.TemperatureC == nil || *record.TemperatureC != temp {
+			t.Errorf("received temperature %v, want %v", record.TemperatureC, temp)
+		}
+	}
+
+	// Shutting down closes the stream
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read after shutdown = %v, want a going away close", err)
+	}
+}
+
+func TestReadingHubDropsSlowClients(t *testing.T) {
+	tests := []struct {
+		name      string
+		published int
+		dropped   bool
+	}{
+		{"keeping up", wsClientBuffer, false},
+		{"too slow", wsClientBuffer + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hub readingHub
+			slow := hub.subscribe()
+			reading := testReading(20, 0)
+			// Publishing never blocks, however far behind the client is
+			for i := 0; i < tt.published; i++ {
+				hub.publish(reading, reading.Time)
+			}
+
+			received := 0
+			open := true
+			for open && received <= tt.published {
+				select {
+				case _, open = <-slow:
+					if open {
+						received++
+					}
+				default:
+					open = false
+				}
+			}
+			if dropped := hub.subscribers() == 0; dropped != tt.dropped {
+				t.Errorf("dropped = %v, want %v", dropped, tt.dropped)
+			}
+			if received != min(tt.published, wsClientBuffer) {
+				t.Errorf("client received %d readings, want %d", received, min(tt.published, wsClientBuffer))
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func watchSignals(ctx context.Context, stop, reload func()) {
+	// Call `stop` on SIGINT or SIGTERM, and `reload` on SIGHUP, unless it's
+	// nil, until `ctx` is cancelled
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	// A nil channel never fires, so SIGHUP keeps its default behaviour unless
+	// there's something to reload
+	var hups chan os.Signal
+	if reload != nil {
+		hups = make(chan os.Signal, 1)
+		signal.Notify(hups, syscall.SIGHUP)
+		defer signal.Stop(hups)
+	}
+
+	for {
+		select {
+		case sig := <-sigs:
+			slog.Info("Signal received, shutting down", "signal", sig)
+			stop()
+			return
+		case <-hups:
+			reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchSignalsReloadsOnSIGHUP(t *testing.T) {
+	// Keep SIGHUP from terminating the test if it arrives before
+	// `watchSignals` is listening for it
+	ignored := make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGHUP)
+	defer signal.Stop(ignored)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan struct{}, 10)
+	stopped := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchSignals(ctx, func() { stopped <- struct{}{} }, func() { reloads <- struct{}{} })
+	}()
+
+	// Signal until it's been received, as `watchSignals` may not be
+	// listening yet
+	timeout := time.After(5 * time.Second)
+	for received := false; !received; {
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		select {
+		case <-reloads:
+			received = true
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("SIGHUP didn't reload the config")
+		}
+	}
+	if len(stopped) > 0 {
+		t.Error("SIGHUP stopped the monitor")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// Build information, set when building with e.g.
+// -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+func printVersion(w io.Writer) {
+	// Print the build information for `-version`. If they weren't set with
+	// -ldflags, the commit and build date fall back to what `go build` records
+	// in a git checkout: the commit, marked if there were uncommitted changes,
+	// and its time, the closest to a build date available.
+
+	commit, buildDate := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok && commit == "" {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				commit = setting.Value + commit
+			case "vcs.modified":
+				if setting.Value == "true" {
+					commit += "-dirty"
+				}
+			case "vcs.time":
+				if buildDate == "" {
+					buildDate = setting.Value
+				}
+			}
+		}
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+
+	fmt.Fprintf(w, "environmentmonitor %s (commit %s, built %s)\n", version, commit, buildDate)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintVersion(t *testing.T) {
+	tests := []struct {
+		name                       string
+		version, commit, buildDate string
+		want                       string
+	}{
+		{
+			"injected",
+			"1.2.0", "0123abc", "2024-01-01T12:00:00Z",
+			"environmentmonitor 1.2.0 (commit 0123abc, built 2024-01-01T12:00:00Z)\n",
+		},
+		{
+			// Test binaries aren't stamped with the git checkout they're
+			// built from
+			"not injected",
+			"dev", "", "",
+			"environmentmonitor dev (commit unknown, built unknown)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+			version, commit, buildDate = tt.version, tt.commit, tt.buildDate
+
+			var out strings.Builder
+			printVersion(&out)
+			if got := out.String(); got != tt.want {
+				t.Errorf("printVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}